@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go-common/categorias"
+)
+
+// validarCategoria rechaza ventas de categorias inactivas y, con
+// category_registry.unknown reject, de ids que no estan registrados.
+func validarCategoria(reg *categorias.Registro, id int32, politica string) error {
+	cat, ok := reg.Buscar(id)
+	switch {
+	case !ok && politica == "reject":
+		return fmt.Errorf("categoria %d no registrada", id)
+	case ok && !cat.Activa:
+		return fmt.Errorf("categoria %d (%s) inactiva", id, cat.Slug)
+	}
+	return nil
+}
+
+type cuerpoCategoria struct {
+	Slug   string `json:"slug"`
+	Nombre string `json:"nombre"`
+	Activa *bool  `json:"activa"`
+}
+
+// rutasCategorias agrega GET /categorias (las activas, para los clientes) y
+// la administracion en /admin/categorias. DELETE solo desactiva: las claves
+// del consumer siguen existiendo y el id no se puede reutilizar con otro slug.
+func rutasCategorias(r *gin.Engine, reg *categorias.Registro, auth, admin gin.HandlerFunc) {
+	r.GET("/categorias", auth, func(c *gin.Context) {
+		activas := []categorias.Categoria{}
+		for _, cat := range reg.Lista() {
+			if cat.Activa {
+				activas = append(activas, cat)
+			}
+		}
+		c.JSON(http.StatusOK, activas)
+	})
+
+	g := r.Group("/admin/categorias", auth, admin)
+	g.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, reg.Lista())
+	})
+	g.PUT("/:id", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "id invalido"})
+			return
+		}
+		var body cuerpoCategoria
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		cat, existe := reg.Buscar(int32(id))
+		if !existe {
+			cat = categorias.Categoria{ID: int32(id), Activa: true}
+		}
+		if body.Slug != "" {
+			cat.Slug = body.Slug
+		}
+		if body.Nombre != "" {
+			cat.Nombre = body.Nombre
+		}
+		if body.Activa != nil {
+			cat.Activa = *body.Activa
+		}
+		guardarCategoria(c, reg, cat)
+	})
+	g.DELETE("/:id", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "id invalido"})
+			return
+		}
+		cat, existe := reg.Buscar(int32(id))
+		if !existe {
+			c.JSON(http.StatusNotFound, gin.H{"error": "categoria no registrada"})
+			return
+		}
+		cat.Activa = false
+		guardarCategoria(c, reg, cat)
+	})
+}
+
+func guardarCategoria(c *gin.Context, reg *categorias.Registro, cat categorias.Categoria) {
+	err := reg.Guardar(c.Request.Context(), cat)
+	switch {
+	case errors.Is(err, categorias.ErrSoloLectura):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, categorias.ErrSlugFijo):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, cat)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "go-bridge/pb"
+	"go-common/config"
+	"go-common/eventid"
+	"google.golang.org/grpc"
+)
+
+// cuerpoAjuste es el body de los reembolsos y cancelaciones. event_id es el
+// id del ajuste mismo: si el cliente lo envia, un reintento con el mismo id
+// se aplica una sola vez.
+type cuerpoAjuste struct {
+	EventID  string `json:"event_id"`
+	Cantidad int32  `json:"cantidad"`
+	Motivo   string `json:"motivo"`
+}
+
+// rutasAjustes agrega POST /ventas/:event_id/reembolso y
+// POST /ventas/:event_id/cancelacion, donde :event_id es el de la venta
+// original. La reversion la aplica el consumer, y solo si quien la pide es
+// quien hizo la venta o un administrador; la respuesta solo confirma que el
+// ajuste quedo en Kafka.
+func rutasAjustes(r *gin.Engine, client pb.ProductSaleServiceClient, loader *config.Loader, secreto []byte, mw ...gin.HandlerFunc) {
+	type rpcAjuste func(context.Context, *pb.AjusteVenta, ...grpc.CallOption) (*pb.ProductSaleResponse, error)
+	manejar := func(rpc rpcAjuste, conCantidad bool) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			var body cuerpoAjuste
+			if c.Request.ContentLength != 0 {
+				if err := c.ShouldBindJSON(&body); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			original := c.Param("event_id")
+			if err := eventid.Validar(original); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if body.Cantidad < 0 || (!conCantidad && body.Cantidad != 0) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cantidad invalida; una cancelacion revierte toda la venta"})
+				return
+			}
+			cfg := loader.Get()
+			sobre, err := armarSobre(Venta{EventID: body.EventID}, time.Unix(0, c.GetInt64(ctxRecibido)), cfg.Bridge.Evento)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Bridge.RequestTimeout)
+			defer cancel()
+			ctx = contextoConIdentidad(ctx, c, secreto)
+			res, err := rpc(ctx, &pb.AjusteVenta{
+				EventIdOriginal: original,
+				Cantidad:        body.Cantidad,
+				Motivo:          body.Motivo,
+				Envelope:        sobre,
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if res.Estado != "Procesado" {
+				c.JSON(http.StatusBadGateway, gin.H{"error": res.Estado})
+				return
+			}
+			c.JSON(http.StatusAccepted, gin.H{"estado": res.Estado, "event_id": sobre.EventId, "event_id_original": original})
+		}
+	}
+	g := r.Group("/ventas/:event_id", mw...)
+	g.POST("/reembolso", manejar(client.ProcesarReembolso, true))
+	g.POST("/cancelacion", manejar(client.CancelarVenta, false))
+}
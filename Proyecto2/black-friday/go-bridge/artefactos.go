@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"go-common/categorias"
+	"go-common/config"
+	"go-common/oci"
+)
+
+func opcionesOCI(a config.Artifact) oci.Options {
+	return oci.Options{
+		Ref:          a.Ref,
+		Digest:       a.Digest,
+		Archivo:      a.File,
+		CacheDir:     a.CacheDir,
+		Username:     a.Username,
+		Password:     a.Password,
+		PasswordFile: a.PasswordFile,
+		Insecure:     a.Insecure,
+	}
+}
+
+// categoriasDeConfig arma el registro desde category_registry.artifact, si
+// tiene ref, o desde category_registry.entries o el mapa categorias. Sin
+// registry y sin copia en cache no se puede arrancar.
+func categoriasDeConfig(cfg *config.Config) []categorias.Categoria {
+	if art := cfg.Registry.Artifact; art.Ref != "" {
+		f, err := oci.New(opcionesOCI(art))
+		if err != nil {
+			log.Fatalf("Fatal category_registry.artifact: %v", err)
+		}
+		if _, err := f.Descargar(context.Background()); err != nil {
+			log.Fatalf("Fatal category_registry.artifact: %v", err)
+		}
+		cs, err := categorias.LeerArchivo(f.Ruta())
+		if err != nil {
+			log.Fatalf("Fatal category_registry.artifact: %v", err)
+		}
+		return cs
+	}
+	var cs []categorias.Categoria
+	for _, e := range cfg.Registry.Semilla(cfg.Categorias) {
+		cs = append(cs, categorias.Categoria{ID: e.ID, Slug: e.Slug, Nombre: e.Name, Activa: e.Active == nil || *e.Active})
+	}
+	return cs
+}
+
+// vigilarArtefactoCategorias aplica las versiones nuevas del artefacto de
+// categorias. Con source valkey el artefacto solo siembra el registro y los
+// cambios se hacen con la API de administracion.
+func vigilarArtefactoCategorias(ctx context.Context, cfg *config.Config, reg *categorias.Registro) {
+	art := cfg.Registry.Artifact
+	if art.Ref == "" || cfg.Registry.Source != "config" {
+		return
+	}
+	f, err := oci.New(opcionesOCI(art))
+	if err != nil {
+		log.Printf("category_registry.artifact: %v", err)
+		return
+	}
+	f.Vigilar(ctx, art.Interval, func(ruta string) {
+		cs, err := categorias.LeerArchivo(ruta)
+		if err != nil {
+			log.Printf("category_registry.artifact: se mantiene el registro anterior: %v", err)
+			return
+		}
+		reg.Reemplazar(cs)
+		log.Printf("Registro de categorias actualizado desde %s (%d categorias)", art.Ref, len(cs))
+	})
+}
+
+// rutaCatalogo descarga el catalogo si viene de un artefacto y deja al
+// fetcher revisando el tag; Catalogo.Recargar toma el archivo nuevo por su
+// mtime.
+func rutaCatalogo(ctx context.Context, cfg config.Catalog) (string, error) {
+	if cfg.Artifact.Ref == "" {
+		return cfg.Path, nil
+	}
+	f, err := oci.New(opcionesOCI(cfg.Artifact))
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Descargar(ctx); err != nil {
+		return "", err
+	}
+	go f.Vigilar(ctx, cfg.Artifact.Interval, nil)
+	return f.Ruta(), nil
+}
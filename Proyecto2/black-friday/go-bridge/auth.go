@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go-common/config"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	ctxIdentidad       = "identidad"
+	mdCallerIdentity   = "x-caller-identity"
+	mdCallerSignature  = "x-caller-signature"
+	identidadAnonima   = "anonimo"
+	intervaloRecargaAK = 30 * time.Second
+)
+
+var errNoCredenciales = errors.New("sin credenciales")
+
+// Autenticador valida una solicitud HTTP y devuelve la identidad del llamante.
+// Devuelve errNoCredenciales si la solicitud no trae credenciales de su tipo,
+// para que el siguiente autenticador de la cadena lo intente.
+type Autenticador interface {
+	Autenticar(r *http.Request) (string, error)
+}
+
+// APIKeyAuth valida el header X-API-Key contra claves estaticas. La fuente
+// puede ser un archivo "clave identidad" por linea o un directorio montado
+// desde un Secret de Kubernetes (nombre de archivo = identidad, contenido =
+// clave).
+type APIKeyAuth struct {
+	ruta string
+
+	mu     sync.RWMutex
+	claves map[string]string
+}
+
+func NewAPIKeyAuth(ruta string) (*APIKeyAuth, error) {
+	a := &APIKeyAuth{ruta: ruta}
+	if err := a.cargar(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *APIKeyAuth) cargar() error {
+	info, err := os.Stat(a.ruta)
+	if err != nil {
+		return fmt.Errorf("api keys: %w", err)
+	}
+	claves := map[string]string{}
+	if info.IsDir() {
+		entradas, err := os.ReadDir(a.ruta)
+		if err != nil {
+			return fmt.Errorf("api keys: %w", err)
+		}
+		for _, e := range entradas {
+			// Los Secrets montados incluyen enlaces ..data que se ignoran.
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(a.ruta, e.Name()))
+			if err != nil {
+				return fmt.Errorf("api keys: %w", err)
+			}
+			if clave := strings.TrimSpace(string(b)); clave != "" {
+				claves[clave] = e.Name()
+			}
+		}
+	} else {
+		b, err := os.ReadFile(a.ruta)
+		if err != nil {
+			return fmt.Errorf("api keys: %w", err)
+		}
+		for i, linea := range strings.Split(string(b), "\n") {
+			linea = strings.TrimSpace(linea)
+			if linea == "" || strings.HasPrefix(linea, "#") {
+				continue
+			}
+			campos := strings.Fields(linea)
+			if len(campos) != 2 {
+				return fmt.Errorf("api keys: linea %d invalida, se espera \"clave identidad\"", i+1)
+			}
+			claves[campos[0]] = campos[1]
+		}
+	}
+	if len(claves) == 0 {
+		return fmt.Errorf("api keys: %s no contiene claves", a.ruta)
+	}
+	a.mu.Lock()
+	a.claves = claves
+	a.mu.Unlock()
+	return nil
+}
+
+// Recargar relee las claves periodicamente para tomar rotaciones del Secret.
+func (a *APIKeyAuth) Recargar(ctx context.Context, intervalo time.Duration) {
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.cargar(); err != nil {
+				log.Printf("Error recargando api keys: %v", err)
+			}
+		}
+	}
+}
+
+func (a *APIKeyAuth) Autenticar(r *http.Request) (string, error) {
+	clave := r.Header.Get("X-API-Key")
+	if clave == "" {
+		return "", errNoCredenciales
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for k, id := range a.claves {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(clave)) == 1 {
+			return id, nil
+		}
+	}
+	return "", errors.New("api key invalida")
+}
+
+// JWTAuth verifica tokens Bearer firmados con alguna clave de un JWKS local.
+type JWTAuth struct {
+	claves   map[string]any
+	issuer   string
+	audience string
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func NewJWTAuth(jwksPath, issuer, audience string) (*JWTAuth, error) {
+	b, err := os.ReadFile(jwksPath)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	claves := map[string]any{}
+	for _, k := range set.Keys {
+		pub, err := k.clavePublica()
+		if err != nil {
+			return nil, fmt.Errorf("jwks kid %q: %w", k.Kid, err)
+		}
+		claves[k.Kid] = pub
+	}
+	if len(claves) == 0 {
+		return nil, fmt.Errorf("jwks: %s no contiene claves", jwksPath)
+	}
+	return &JWTAuth{claves: claves, issuer: issuer, audience: audience}, nil
+}
+
+func decodeB64(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jwk) clavePublica() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeB64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeB64(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curva elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curva = elliptic.P256()
+		case "P-384":
+			curva = elliptic.P384()
+		case "P-521":
+			curva = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("curva no soportada %q", k.Crv)
+		}
+		x, err := decodeB64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeB64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curva, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("tipo de clave no soportado %q", k.Kty)
+}
+
+func (j *JWTAuth) Autenticar(r *http.Request) (string, error) {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return "", errNoCredenciales
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}), jwt.WithExpirationRequired()}
+	if j.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(j.issuer))
+	}
+	if j.audience != "" {
+		opts = append(opts, jwt.WithAudience(j.audience))
+	}
+	tok, err := jwt.Parse(strings.TrimPrefix(h, "Bearer "), func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		if k, ok := j.claves[kid]; ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("kid desconocido %q", kid)
+	}, opts...)
+	if err != nil {
+		return "", err
+	}
+	sub, err := tok.Claims.GetSubject()
+	if err != nil || sub == "" {
+		return "", errors.New("token sin sub")
+	}
+	return sub, nil
+}
+
+// NewAutenticadores construye la cadena segun auth.mode ("none", "apikey",
+// "jwt" o "apikey,jwt").
+func NewAutenticadores(ctx context.Context, cfg config.Auth) ([]Autenticador, error) {
+	var auths []Autenticador
+	for _, m := range strings.Split(cfg.Mode, ",") {
+		switch strings.TrimSpace(m) {
+		case "", "none":
+		case "apikey":
+			a, err := NewAPIKeyAuth(cfg.APIKeysPath)
+			if err != nil {
+				return nil, err
+			}
+			go a.Recargar(ctx, intervaloRecargaAK)
+			auths = append(auths, a)
+		case "jwt":
+			j, err := NewJWTAuth(cfg.JWKSPath, cfg.JWTIssuer, cfg.JWTAudience)
+			if err != nil {
+				return nil, err
+			}
+			auths = append(auths, j)
+		default:
+			return nil, fmt.Errorf("auth.mode desconocido %q", m)
+		}
+	}
+	return auths, nil
+}
+
+// AuthMiddleware prueba cada autenticador en orden. Sin autenticadores
+// configurados todas las solicitudes pasan como "anonimo".
+func AuthMiddleware(auths []Autenticador) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(auths) == 0 {
+			c.Set(ctxIdentidad, identidadAnonima)
+			c.Next()
+			return
+		}
+		for _, a := range auths {
+			id, err := a.Autenticar(c.Request)
+			if errors.Is(err, errNoCredenciales) {
+				continue
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			c.Set(ctxIdentidad, id)
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": errNoCredenciales.Error()})
+	}
+}
+
+// AdminMiddleware deja pasar a /admin/* solo a auth.admin_identities. Va
+// despues de AuthMiddleware; con auth.mode none y la lista vacia la API queda
+// abierta, igual que /forward.
+func AdminMiddleware(loader *config.Loader) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permitidas := loader.Get().Auth.AdminIdentities
+		id := c.GetString(ctxIdentidad)
+		if (len(permitidas) == 0 && id == identidadAnonima) || slices.Contains(permitidas, id) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("la identidad %q no es administradora", id)})
+	}
+}
+
+// firmarIdentidad firma la identidad con auth.identity_secret para que el
+// writer pueda comprobar que la asigno el bridge.
+func firmarIdentidad(secreto []byte, identidad string) string {
+	mac := hmac.New(sha256.New, secreto)
+	mac.Write([]byte(identidad))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// contextoConIdentidad agrega la identidad autenticada y su firma como
+// metadata gRPC. Sin secreto no se envia: el writer descarta identidades sin
+// firmar.
+func contextoConIdentidad(ctx context.Context, c *gin.Context, secreto []byte) context.Context {
+	id := c.GetString(ctxIdentidad)
+	if id == "" || len(secreto) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx,
+		mdCallerIdentity, id,
+		mdCallerSignature, firmarIdentidad(secreto, id))
+}
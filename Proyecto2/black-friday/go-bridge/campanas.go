@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	pb "go-bridge/pb"
+	"go-common/config"
+)
+
+// campanaAplica indica si la campana cubre el producto y la categoria de la
+// venta, sin mirar la ventana.
+func campanaAplica(cp config.Campaign, v Venta) bool {
+	if len(cp.Products) == 0 && len(cp.Categories) == 0 {
+		return true
+	}
+	id := normalizarProducto(v.ProductoID)
+	for _, p := range cp.Products {
+		if normalizarProducto(p) == id {
+			return true
+		}
+	}
+	return slices.Contains(cp.Categories, v.Categoria)
+}
+
+// aplicarCampana busca la primera campana vigente para la venta en el momento
+// en que llego al bridge; el event_time del cliente no cuenta porque lo puede
+// elegir. Devuelve nil si ninguna aplica y un error si la venta se debe
+// rechazar: fuera de la ventana de una campana exclusiva o por encima del
+// limite por venta.
+func aplicarCampana(campanas []config.Campaign, v Venta, identidad string, ahora time.Time) (*pb.CampanaVenta, error) {
+	var fuera error
+	for _, cp := range campanas {
+		if !campanaAplica(cp, v) {
+			continue
+		}
+		enVentana := !ahora.Before(cp.Start) && ahora.Before(cp.End)
+		ea := cp.EarlyAccess
+		anticipado := !enVentana && !ea.Start.IsZero() &&
+			!ahora.Before(ea.Start) && ahora.Before(cp.Start) &&
+			slices.Contains(ea.Identities, identidad)
+		if !enVentana && !anticipado {
+			// Otra campana mas abajo puede cubrir el producto en este
+			// momento, p.ej. una segunda ventana del mismo doorbuster.
+			if cp.Exclusive && fuera == nil {
+				fuera = fmt.Errorf("campana %s: solo disponible entre %s y %s",
+					cp.ID, cp.Start.Format(time.RFC3339), cp.End.Format(time.RFC3339))
+			}
+			continue
+		}
+		if cp.MaxPerOrder > 0 && v.CantidadVendida > cp.MaxPerOrder {
+			return nil, fmt.Errorf("campana %s: maximo %d unidades por venta", cp.ID, cp.MaxPerOrder)
+		}
+		info := &pb.CampanaVenta{
+			Id:               cp.ID,
+			Nombre:           cp.Name,
+			PrecioEfectivo:   v.Precio,
+			AccesoAnticipado: anticipado,
+		}
+		switch {
+		case cp.Price > 0:
+			info.PrecioEfectivo = cp.Price
+		case cp.DiscountPct > 0:
+			info.PrecioEfectivo = math.Round(v.Precio*(100-cp.DiscountPct)) / 100
+		}
+		if v.Precio > 0 && info.PrecioEfectivo < v.Precio {
+			info.DescuentoPct = math.Round((1-info.PrecioEfectivo/v.Precio)*10000) / 100
+		}
+		return info, nil
+	}
+	return nil, fuera
+}
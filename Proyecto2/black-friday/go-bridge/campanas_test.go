@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go-common/config"
+)
+
+func TestAplicarCampana(t *testing.T) {
+	inicio := time.Date(2026, 11, 27, 0, 0, 0, 0, time.UTC)
+	campanas := []config.Campaign{
+		{
+			ID: "doorbuster-tv", Name: "TV a medianoche", Start: inicio, End: inicio.Add(time.Hour),
+			Products: []string{"TV 55"}, Price: 199, MaxPerOrder: 2, Exclusive: true,
+			EarlyAccess: config.EarlyAccess{Start: inicio.Add(-time.Hour), Identities: []string{"socios"}},
+		},
+		{
+			ID: "doorbuster-tv-2", Start: inicio.Add(12 * time.Hour), End: inicio.Add(13 * time.Hour),
+			Products: []string{"tv-55"}, Price: 249, Exclusive: true,
+		},
+		{
+			ID: "electronica", Start: inicio, End: inicio.Add(24 * time.Hour),
+			Categories: []int32{1}, DiscountPct: 15,
+		},
+	}
+
+	casos := []struct {
+		nombre     string
+		venta      Venta
+		identidad  string
+		ahora      time.Time
+		id         string
+		precio     float64
+		descuento  float64
+		anticipado bool
+		error      string
+	}{
+		{nombre: "doorbuster en su ventana", venta: Venta{ProductoID: "tv_55", Precio: 400, CantidadVendida: 1},
+			ahora: inicio.Add(time.Minute), id: "doorbuster-tv", precio: 199, descuento: 50.25},
+		{nombre: "doorbuster por encima del limite", venta: Venta{ProductoID: "tv-55", Precio: 400, CantidadVendida: 3},
+			ahora: inicio.Add(time.Minute), error: "maximo 2 unidades"},
+		{nombre: "acceso anticipado", venta: Venta{ProductoID: "tv-55", Precio: 400, CantidadVendida: 1}, identidad: "socios",
+			ahora: inicio.Add(-time.Minute), id: "doorbuster-tv", precio: 199, descuento: 50.25, anticipado: true},
+		{nombre: "anticipado sin identidad", venta: Venta{ProductoID: "tv-55", Precio: 400, CantidadVendida: 1},
+			ahora: inicio.Add(-time.Minute), error: "campana doorbuster-tv"},
+		{nombre: "segunda ventana del doorbuster", venta: Venta{ProductoID: "TV 55", Precio: 400, CantidadVendida: 5},
+			ahora: inicio.Add(12 * time.Hour), id: "doorbuster-tv-2", precio: 249, descuento: 37.75},
+		{nombre: "exclusiva fuera de ventana", venta: Venta{ProductoID: "tv-55", Precio: 400, CantidadVendida: 1},
+			ahora: inicio.Add(2 * time.Hour), error: "solo disponible"},
+		{nombre: "descuento por categoria", venta: Venta{Categoria: 1, ProductoID: "audifonos", Precio: 100, CantidadVendida: 1},
+			ahora: inicio.Add(2 * time.Hour), id: "electronica", precio: 85, descuento: 15},
+		{nombre: "el fin de la ventana queda fuera", venta: Venta{Categoria: 1, Precio: 100},
+			ahora: inicio.Add(24 * time.Hour)},
+		{nombre: "otra categoria", venta: Venta{Categoria: 2, ProductoID: "camisa", Precio: 100},
+			ahora: inicio.Add(time.Minute)},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			info, err := aplicarCampana(campanas, c.venta, c.identidad, c.ahora)
+			if c.error != "" {
+				if err == nil || !strings.Contains(err.Error(), c.error) {
+					t.Fatalf("se esperaba un error con %q, se obtuvo %v (%+v)", c.error, err, info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.id == "" {
+				if info != nil {
+					t.Fatalf("no deberia aplicar ninguna campana, aplico %s", info.Id)
+				}
+				return
+			}
+			if info == nil || info.Id != c.id {
+				t.Fatalf("campana %+v, se esperaba %s", info, c.id)
+			}
+			if info.PrecioEfectivo != c.precio || info.DescuentoPct != c.descuento || info.AccesoAnticipado != c.anticipado {
+				t.Errorf("precio %v descuento %v anticipado %v", info.PrecioEfectivo, info.DescuentoPct, info.AccesoAnticipado)
+			}
+		})
+	}
+}
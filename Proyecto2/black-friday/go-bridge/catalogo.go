@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	pb "go-bridge/pb"
+	"go-common/config"
+)
+
+// Contadores de incidencias del catalogo en Valkey, compartidos por todas las
+// replicas del bridge.
+const (
+	claveIncidenciasCatalogo = "{catalogo}:incidencias"
+	claveDistintasCatalogo   = "{catalogo}:categoria_distinta"
+)
+
+type Producto struct {
+	ID          string   `json:"id"`
+	Nombre      string   `json:"nombre"`
+	Categoria   int32    `json:"categoria"`
+	PrecioLista float64  `json:"precio_lista"`
+	Alias       []string `json:"alias"`
+}
+
+// normalizarProducto es la forma con la que se comparan ids y alias:
+// "iPhone 15", "iphone_15" e "IPHONE-15" quedan como "iphone-15".
+func normalizarProducto(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == '_' || r == '-' || r == '\t'
+	}), "-")
+}
+
+type indiceCatalogo struct {
+	productos map[string]Producto // por id canonico
+	nombres   map[string]string   // id o alias normalizado -> id canonico
+}
+
+func nuevoIndice(ps []Producto) (*indiceCatalogo, error) {
+	idx := &indiceCatalogo{productos: map[string]Producto{}, nombres: map[string]string{}}
+	for _, p := range ps {
+		if p.ID == "" {
+			return nil, errors.New("producto sin id")
+		}
+		if _, dup := idx.productos[p.ID]; dup {
+			return nil, fmt.Errorf("producto %q repetido", p.ID)
+		}
+		idx.productos[p.ID] = p
+		for _, n := range append([]string{p.ID}, p.Alias...) {
+			k := normalizarProducto(n)
+			if otro, dup := idx.nombres[k]; dup && otro != p.ID {
+				return nil, fmt.Errorf("%q es alias de %q y de %q", n, otro, p.ID)
+			}
+			idx.nombres[k] = p.ID
+		}
+	}
+	return idx, nil
+}
+
+// leerProductos acepta JSON (lista de Producto) o CSV con encabezado
+// id,nombre,categoria,precio_lista,alias donde los alias van separados por
+// "|".
+func leerProductos(ruta string) ([]Producto, error) {
+	b, err := os.ReadFile(ruta)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(ruta), ".json") {
+		var ps []Producto
+		if err := json.Unmarshal(b, &ps); err != nil {
+			return nil, err
+		}
+		return ps, nil
+	}
+	r := csv.NewReader(strings.NewReader(string(b)))
+	r.FieldsPerRecord = -1
+	encabezado, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv sin encabezado: %w", err)
+	}
+	col := map[string]int{}
+	for i, c := range encabezado {
+		col[strings.ToLower(strings.TrimSpace(c))] = i
+	}
+	for _, c := range []string{"id", "nombre", "categoria", "precio_lista"} {
+		if _, ok := col[c]; !ok {
+			return nil, fmt.Errorf("csv sin columna %s", c)
+		}
+	}
+	var ps []Producto
+	for linea := 2; ; linea++ {
+		fila, err := r.Read()
+		if err == io.EOF {
+			return ps, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		campo := func(c string) string {
+			if i, ok := col[c]; ok && i < len(fila) {
+				return strings.TrimSpace(fila[i])
+			}
+			return ""
+		}
+		cat, err := strconv.ParseInt(campo("categoria"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("linea %d: categoria invalida", linea)
+		}
+		precio, err := strconv.ParseFloat(campo("precio_lista"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("linea %d: precio_lista invalido", linea)
+		}
+		p := Producto{ID: campo("id"), Nombre: campo("nombre"), Categoria: int32(cat), PrecioLista: precio}
+		for _, a := range strings.Split(campo("alias"), "|") {
+			if a = strings.TrimSpace(a); a != "" {
+				p.Alias = append(p.Alias, a)
+			}
+		}
+		ps = append(ps, p)
+	}
+}
+
+// Catalogo es el catalogo de productos vigente. Se recarga cuando cambia el
+// archivo; si la carga falla se mantiene el anterior.
+type Catalogo struct {
+	ruta string
+	rdb  redis.UniversalClient
+
+	mu      sync.RWMutex
+	idx     *indiceCatalogo
+	mtime   time.Time
+	cargado time.Time
+}
+
+func NewCatalogo(ruta string, rdb redis.UniversalClient) (*Catalogo, error) {
+	c := &Catalogo{ruta: ruta, rdb: rdb}
+	if err := c.cargar(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Catalogo) cargar() error {
+	info, err := os.Stat(c.ruta)
+	if err != nil {
+		return fmt.Errorf("catalogo: %w", err)
+	}
+	c.mu.RLock()
+	igual := info.ModTime().Equal(c.mtime)
+	c.mu.RUnlock()
+	if igual {
+		return nil
+	}
+	ps, err := leerProductos(c.ruta)
+	if err != nil {
+		return fmt.Errorf("catalogo %s: %w", c.ruta, err)
+	}
+	idx, err := nuevoIndice(ps)
+	if err != nil {
+		return fmt.Errorf("catalogo %s: %w", c.ruta, err)
+	}
+	c.mu.Lock()
+	c.idx, c.mtime, c.cargado = idx, info.ModTime(), time.Now()
+	c.mu.Unlock()
+	log.Printf("Catalogo cargado: %d productos, %d nombres", len(idx.productos), len(idx.nombres))
+	return nil
+}
+
+// Recargar revisa el archivo periodicamente, p.ej. cuando se actualiza el
+// ConfigMap o el artefacto que lo contiene.
+func (c *Catalogo) Recargar(ctx context.Context, intervalo time.Duration) {
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.cargar(); err != nil {
+				log.Printf("Error recargando catalogo, se mantiene el anterior: %v", err)
+			}
+		}
+	}
+}
+
+func (c *Catalogo) Buscar(id string) (Producto, bool) {
+	c.mu.RLock()
+	idx := c.idx
+	c.mu.RUnlock()
+	canonico, ok := idx.nombres[normalizarProducto(id)]
+	if !ok {
+		return Producto{}, false
+	}
+	return idx.productos[canonico], true
+}
+
+// Aplicar normaliza el producto de la venta y arma la informacion de
+// catalogo del evento segun catalog.unknown y catalog.mismatch. Devuelve un
+// error si la venta se debe rechazar.
+func (c *Catalogo) Aplicar(ctx context.Context, v *Venta, cfg config.Catalog) (*pb.ProductoCatalogo, error) {
+	p, ok := c.Buscar(v.ProductoID)
+	if !ok {
+		if cfg.Unknown == "reject" {
+			return nil, fmt.Errorf("producto %q no esta en el catalogo", v.ProductoID)
+		}
+		if cfg.Unknown == "accept" {
+			return nil, nil
+		}
+		c.contar(ctx, "desconocido", "")
+		return &pb.ProductoCatalogo{Desconocido: true}, nil
+	}
+	info := &pb.ProductoCatalogo{Nombre: p.Nombre, Categoria: p.Categoria, PrecioLista: p.PrecioLista}
+	if v.ProductoID != p.ID {
+		info.Alias = v.ProductoID
+		v.ProductoID = p.ID
+		c.contar(ctx, "alias", "")
+	}
+	if v.Categoria != p.Categoria {
+		if cfg.Mismatch == "reject" {
+			return nil, fmt.Errorf("producto %q es de la categoria %d, no %d", p.ID, p.Categoria, v.Categoria)
+		}
+		info.CategoriaDistinta, info.CategoriaPayload = true, v.Categoria
+		if cfg.Mismatch == "fix" {
+			v.Categoria = p.Categoria
+		}
+		c.contar(ctx, "categoria_distinta", p.ID)
+	}
+	return info, nil
+}
+
+// contar suma la incidencia en Valkey sin bloquear la venta si falla.
+func (c *Catalogo) contar(ctx context.Context, tipo, producto string) {
+	if c.rdb == nil {
+		return
+	}
+	_, err := c.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, claveIncidenciasCatalogo, tipo, 1)
+		if producto != "" {
+			pipe.HIncrBy(ctx, claveDistintasCatalogo, producto, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Catalogo: no se pudo contar %s: %v", tipo, err)
+	}
+}
+
+type estadoCatalogo struct {
+	Ruta              string           `json:"ruta"`
+	Cargado           time.Time        `json:"cargado"`
+	Productos         int              `json:"productos"`
+	Incidencias       map[string]int64 `json:"incidencias"`
+	CategoriaDistinta map[string]int64 `json:"categoria_distinta"`
+}
+
+// handleEstado atiende GET /admin/catalogo con los contadores de todas las
+// replicas.
+func (c *Catalogo) handleEstado(ctx *gin.Context) {
+	c.mu.RLock()
+	e := estadoCatalogo{Ruta: c.ruta, Cargado: c.cargado, Productos: len(c.idx.productos)}
+	c.mu.RUnlock()
+	var err error
+	if e.Incidencias, err = hashEnteros(ctx, c.rdb, claveIncidenciasCatalogo); err == nil {
+		e.CategoriaDistinta, err = hashEnteros(ctx, c.rdb, claveDistintasCatalogo)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, e)
+}
+
+func hashEnteros(ctx context.Context, rdb redis.UniversalClient, clave string) (map[string]int64, error) {
+	crudo, err := rdb.HGetAll(ctx, clave).Result()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]int64, len(crudo))
+	for k, v := range crudo {
+		m[k], _ = strconv.ParseInt(v, 10, 64)
+	}
+	return m, nil
+}
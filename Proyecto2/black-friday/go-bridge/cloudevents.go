@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go-common/eventid"
+)
+
+// eventoCE es un CloudEvent 1.0 recibido por HTTP en modo structured; en modo
+// binary los mismos atributos llegan en headers ce-*.
+type eventoCE struct {
+	SpecVersion string          `json:"specversion"`
+	ID          string          `json:"id"`
+	Source      string          `json:"source"`
+	Type        string          `json:"type"`
+	Time        string          `json:"time"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// leerVenta acepta en /forward el JSON de Venta de siempre o un CloudEvent
+// de tipo eventid.TipoVenta. Los atributos id y time del evento pasan al
+// sobre; source es obligatorio en CloudEvents pero el sobre usa el del bridge.
+func leerVenta(c *gin.Context) (Venta, error) {
+	var v Venta
+	var ce eventoCE
+	switch {
+	case strings.HasPrefix(c.ContentType(), "application/cloudevents+json"):
+		if err := c.ShouldBindJSON(&ce); err != nil {
+			return v, err
+		}
+		if len(ce.Data) == 0 {
+			return v, errors.New("CloudEvent sin data")
+		}
+		if err := json.Unmarshal(ce.Data, &v); err != nil {
+			return v, fmt.Errorf("data: %w", err)
+		}
+	case c.GetHeader("ce-specversion") != "":
+		if err := c.ShouldBindJSON(&v); err != nil {
+			return v, err
+		}
+		ce = eventoCE{
+			SpecVersion: c.GetHeader("ce-specversion"),
+			ID:          c.GetHeader("ce-id"),
+			Source:      c.GetHeader("ce-source"),
+			Type:        c.GetHeader("ce-type"),
+			Time:        c.GetHeader("ce-time"),
+		}
+	default:
+		err := c.ShouldBindJSON(&v)
+		return v, err
+	}
+
+	if ce.SpecVersion != "1.0" {
+		return v, fmt.Errorf("CloudEvents specversion %q no soportada", ce.SpecVersion)
+	}
+	if ce.ID == "" || ce.Source == "" {
+		return v, errors.New("CloudEvent sin id o source")
+	}
+	if ce.Type != eventid.TipoVenta {
+		return v, fmt.Errorf("CloudEvent de tipo %q, se espera %q", ce.Type, eventid.TipoVenta)
+	}
+	v.EventID = ce.ID
+	if ce.Time != "" {
+		t, err := time.Parse(time.RFC3339Nano, ce.Time)
+		if err != nil {
+			return v, fmt.Errorf("time: %w", err)
+		}
+		v.EventTime = t
+	}
+	return v, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	pb "go-bridge/pb"
+	"go-common/config"
+	"go-common/eventid"
+)
+
+// armarSobre completa el sobre de la venta. El cliente puede enviar su propio
+// event_id (para reintentos idempotentes) y la hora del evento; si no, se usan
+// un id nuevo y la hora de llegada. Source y Tenant salen siempre de la
+// configuracion: el consumer los usa para identificar el origen de la venta y
+// no pueden venir del cliente.
+func armarSobre(v Venta, recibido time.Time, cfg config.Evento) (*pb.Envelope, error) {
+	s := &pb.Envelope{
+		Version:    eventid.Version,
+		EventId:    v.EventID,
+		EventTime:  recibido.UnixNano(),
+		IngestTime: recibido.UnixNano(),
+		Source:     cfg.Source,
+		Tenant:     cfg.Tenant,
+	}
+	if s.EventId == "" {
+		s.EventId = eventid.Nuevo(recibido)
+	} else if err := eventid.Validar(s.EventId); err != nil {
+		return nil, err
+	}
+	if !v.EventTime.IsZero() {
+		if cfg.MaxSkew > 0 && v.EventTime.Sub(recibido) > cfg.MaxSkew {
+			return nil, fmt.Errorf("event_time %s esta mas de %s en el futuro", v.EventTime.Format(time.RFC3339), cfg.MaxSkew)
+		}
+		s.EventTime = v.EventTime.UnixNano()
+	}
+	return s, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go-common/config"
+	"go-common/eventid"
+)
+
+func TestArmarSobre(t *testing.T) {
+	recibido := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	cfg := config.Evento{Source: "go-bridge", Tenant: "default", MaxSkew: 5 * time.Minute}
+	propio := eventid.Nuevo(recibido.Add(-time.Minute))
+
+	casos := []struct {
+		nombre    string
+		venta     Venta
+		eventID   string
+		eventTime time.Time
+		error     bool
+	}{
+		{nombre: "sin campos del sobre", venta: Venta{}, eventTime: recibido},
+		{nombre: "event_id del cliente", venta: Venta{EventID: propio}, eventID: propio, eventTime: recibido},
+		{nombre: "event_id invalido", venta: Venta{EventID: "123"}, error: true},
+		{nombre: "event_time en el pasado", venta: Venta{EventTime: recibido.Add(-time.Hour)}, eventTime: recibido.Add(-time.Hour)},
+		{nombre: "event_time dentro del margen", venta: Venta{EventTime: recibido.Add(time.Minute)}, eventTime: recibido.Add(time.Minute)},
+		{nombre: "event_time muy adelantado", venta: Venta{EventTime: recibido.Add(time.Hour)}, error: true},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			s, err := armarSobre(c.venta, recibido, cfg)
+			if c.error {
+				if err == nil {
+					t.Fatalf("se esperaba un error, se obtuvo %v", s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.eventID != "" && s.EventId != c.eventID {
+				t.Errorf("event_id %q, se esperaba %q", s.EventId, c.eventID)
+			}
+			if err := eventid.Validar(s.EventId); err != nil {
+				t.Errorf("event_id generado invalido: %v", err)
+			}
+			if s.EventTime != c.eventTime.UnixNano() || s.IngestTime != recibido.UnixNano() {
+				t.Errorf("event_time %d ingest_time %d", s.EventTime, s.IngestTime)
+			}
+			// El origen siempre es el del bridge.
+			if s.Source != cfg.Source || s.Tenant != cfg.Tenant || s.Version != eventid.Version {
+				t.Errorf("sobre %+v", s)
+			}
+		})
+	}
+}
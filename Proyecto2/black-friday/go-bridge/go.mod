@@ -3,7 +3,15 @@ module go-bridge
 go 1.24.0
 
 require (
+	github.com/alicebob/miniredis/v2 v2.37.0
 	github.com/gin-gonic/gin v1.9.1
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/redis/go-redis/v9 v9.5.1
 	google.golang.org/grpc v1.65.0
 	google.golang.org/protobuf v1.34.2
-)
\ No newline at end of file
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require go-common v0.0.0
+
+replace go-common => ../go-common
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	pb "go-bridge/pb"
+	"go-common/config"
+	"go-common/eventid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// El stock de cada producto es un hash con disponible, total (todo lo
+// recibido), vendido y, opcionalmente, umbral de stock bajo. El hash tag
+// deja cada producto en su propio slot.
+const claveProductosInventario = "inventario:productos"
+
+func claveInventario(producto string) string {
+	return "inventario:{" + producto + "}"
+}
+
+var errSinStock = errors.New("stock insuficiente")
+
+// scriptReservar descuenta ARGV[1] unidades si alcanzan. Devuelve
+// {estado, disponible, total, umbral, disponible_antes}; estado 0 es
+// reservado, -1 producto sin inventario y -2 stock insuficiente. umbral es -1
+// si el producto no tiene uno propio.
+var scriptReservar = redis.NewScript(`
+local disp = redis.call('HGET', KEYS[1], 'disponible')
+if not disp then
+  return {-1, 0, 0, -1, 0}
+end
+disp = tonumber(disp)
+local cant = tonumber(ARGV[1])
+local total = tonumber(redis.call('HGET', KEYS[1], 'total') or '0')
+local umbral = tonumber(redis.call('HGET', KEYS[1], 'umbral') or '-1')
+if disp < cant then
+  return {-2, disp, total, umbral, disp}
+end
+local resto = redis.call('HINCRBY', KEYS[1], 'disponible', -cant)
+redis.call('HINCRBY', KEYS[1], 'vendido', cant)
+return {0, resto, total, umbral, disp}
+`)
+
+// scriptReabastecer suma ARGV[1] unidades a disponible y total y, si ARGV[2]
+// no esta vacio, fija el umbral.
+var scriptReabastecer = redis.NewScript(`
+local resto = redis.call('HINCRBY', KEYS[1], 'disponible', ARGV[1])
+local total = redis.call('HINCRBY', KEYS[1], 'total', ARGV[1])
+if ARGV[2] ~= '' then
+  redis.call('HSET', KEYS[1], 'umbral', ARGV[2])
+end
+return {resto, total}
+`)
+
+// Inventario reserva stock para las ventas y publica los cambios de estado
+// por medio del writer. Los eventos se publican en segundo plano; publicados
+// y fallidos los cuentan y se consultan en /admin/inventario/_eventos.
+type Inventario struct {
+	rdb    redis.UniversalClient
+	client pb.ProductSaleServiceClient
+	cfg    *config.Loader
+
+	publicados atomic.Int64
+	fallidos   atomic.Int64
+}
+
+func NewInventario(rdb redis.UniversalClient, client pb.ProductSaleServiceClient, loader *config.Loader) *Inventario {
+	return &Inventario{rdb: rdb, client: client, cfg: loader}
+}
+
+// Reserva es el stock descontado para una venta. El aviso de agotado o stock
+// bajo que provoco se publica recien al confirmarla, asi una venta que no
+// llega a Kafka no deja un aviso sin compensar.
+type Reserva struct {
+	Info *pb.InventarioVenta
+
+	inv      *Inventario
+	producto string
+	cantidad int32
+	aviso    string
+	umbral   int64
+}
+
+// Confirmar publica el aviso pendiente, si lo hay, una vez que la venta llego
+// a Kafka.
+func (r *Reserva) Confirmar() {
+	if r.aviso != "" {
+		r.inv.publicar(r.aviso, r.producto, r.Info.Disponible, r.Info.Total, r.umbral)
+	}
+}
+
+// Devolver compensa la reserva cuando la venta no llego a Kafka; el aviso
+// pendiente se descarta.
+func (r *Reserva) Devolver(ctx context.Context) {
+	r.inv.Devolver(ctx, r.producto, r.cantidad)
+}
+
+// ventaNoEscrita indica si la respuesta de ProcesarVenta garantiza que la
+// venta no llego a Kafka: el writer no estaba disponible o la rechazo antes
+// de producirla. Un timeout o un "Error Kafka" son ambiguos, porque el broker
+// pudo haber escrito el registro; devolver el stock en ese caso permitiria
+// vender de mas, asi que la reserva se retiene.
+func ventaNoEscrita(res *pb.ProductSaleResponse, err error) bool {
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Unavailable, codes.Unauthenticated, codes.PermissionDenied,
+			codes.InvalidArgument, codes.ResourceExhausted:
+			return true
+		}
+		return false
+	}
+	return res.Estado == "Error marshaling"
+}
+
+// Reservar descuenta la cantidad de la venta. Devuelve nil sin error si el
+// producto no tiene inventario y inventory.untracked es allow, y errSinStock
+// (con lo disponible en Info) si no alcanza.
+func (inv *Inventario) Reservar(ctx context.Context, producto string, cantidad int32) (*Reserva, error) {
+	if cantidad <= 0 {
+		return nil, fmt.Errorf("cantidad_vendida debe ser mayor que 0")
+	}
+	cfg := inv.cfg.Get().Inventory
+	res, err := scriptReservar.Run(ctx, inv.rdb, []string{claveInventario(producto)}, cantidad).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	estado, disp, total, umbral, antes := res[0], res[1], res[2], res[3], res[4]
+	switch estado {
+	case -1:
+		if cfg.Untracked == "reject" {
+			return nil, fmt.Errorf("producto %q sin inventario", producto)
+		}
+		return nil, nil
+	case -2:
+		return &Reserva{Info: &pb.InventarioVenta{Disponible: disp, Total: total}}, errSinStock
+	}
+	if umbral < 0 {
+		umbral = cfg.LowStock
+	}
+	r := &Reserva{
+		Info:     &pb.InventarioVenta{Disponible: disp, Total: total},
+		inv:      inv,
+		producto: producto,
+		cantidad: cantidad,
+		umbral:   umbral,
+	}
+	// Solo se avisa al cruzar el umbral, no en cada venta posterior.
+	switch {
+	case disp == 0:
+		r.aviso = "agotado"
+	case disp <= umbral && antes > umbral:
+		r.aviso = "stock_bajo"
+	}
+	return r, nil
+}
+
+// Devolver compensa una reserva cuando la venta no llego a Kafka.
+func (inv *Inventario) Devolver(ctx context.Context, producto string, cantidad int32) {
+	clave := claveInventario(producto)
+	_, err := inv.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, clave, "disponible", int64(cantidad))
+		pipe.HIncrBy(ctx, clave, "vendido", -int64(cantidad))
+		return nil
+	})
+	if err != nil {
+		log.Printf("Inventario: no se pudieron devolver %d de %s: %v", cantidad, producto, err)
+	}
+}
+
+// publicar envia el evento al writer sin demorar la venta.
+func (inv *Inventario) publicar(tipo, producto string, disp, total, umbral int64) {
+	ahora := time.Now()
+	ev := &pb.EventoInventario{
+		Tipo:       tipo,
+		ProductoId: producto,
+		Disponible: disp,
+		Total:      total,
+		Umbral:     umbral,
+		Envelope: &pb.Envelope{
+			Version:    eventid.Version,
+			EventId:    eventid.Nuevo(ahora),
+			EventTime:  ahora.UnixNano(),
+			IngestTime: ahora.UnixNano(),
+			Source:     inv.cfg.Get().Bridge.Evento.Source,
+		},
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		res, err := inv.client.PublicarInventario(ctx, ev)
+		if err == nil && res.Estado != "Procesado" {
+			err = errors.New(res.Estado)
+		}
+		if err != nil {
+			inv.fallidos.Add(1)
+			log.Printf("Inventario: no se pudo publicar %s de %s: %v", tipo, producto, err)
+			return
+		}
+		inv.publicados.Add(1)
+	}()
+}
+
+type stockProducto struct {
+	ProductoID string `json:"producto_id"`
+	Disponible int64  `json:"disponible"`
+	Total      int64  `json:"total"`
+	Vendido    int64  `json:"vendido"`
+	Umbral     int64  `json:"umbral"`
+}
+
+func (inv *Inventario) consultar(ctx context.Context, producto string) (stockProducto, bool, error) {
+	crudo, err := inv.rdb.HGetAll(ctx, claveInventario(producto)).Result()
+	if err != nil || len(crudo) == 0 {
+		return stockProducto{}, false, err
+	}
+	s := stockProducto{ProductoID: producto, Umbral: inv.cfg.Get().Inventory.LowStock}
+	s.Disponible, _ = strconv.ParseInt(crudo["disponible"], 10, 64)
+	s.Total, _ = strconv.ParseInt(crudo["total"], 10, 64)
+	s.Vendido, _ = strconv.ParseInt(crudo["vendido"], 10, 64)
+	if u, ok := crudo["umbral"]; ok {
+		s.Umbral, _ = strconv.ParseInt(u, 10, 64)
+	}
+	return s, true, nil
+}
+
+type cuerpoReabastecer struct {
+	Cantidad int64  `json:"cantidad"`
+	Umbral   *int64 `json:"umbral"`
+}
+
+// rutas agrega la administracion del stock en /admin/inventario.
+func (inv *Inventario) rutas(r *gin.Engine, auth, admin gin.HandlerFunc) {
+	g := r.Group("/admin/inventario", auth, admin)
+	g.GET("", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		productos, err := inv.rdb.SMembers(ctx, claveProductosInventario).Result()
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+		sort.Strings(productos)
+		lista := []stockProducto{}
+		for _, p := range productos {
+			s, ok, err := inv.consultar(ctx, p)
+			if err != nil {
+				c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+				return
+			}
+			if ok {
+				lista = append(lista, s)
+			}
+		}
+		c.JSON(http.StatusOK, lista)
+	})
+	g.GET("/_eventos", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"publicados": inv.publicados.Load(), "fallidos": inv.fallidos.Load()})
+	})
+	g.GET("/:producto", func(c *gin.Context) {
+		s, ok, err := inv.consultar(c.Request.Context(), c.Param("producto"))
+		switch {
+		case err != nil:
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		case !ok:
+			c.JSON(http.StatusNotFound, gin.H{"error": "producto sin inventario"})
+		default:
+			c.JSON(http.StatusOK, s)
+		}
+	})
+	g.POST("/:producto/reabastecer", func(c *gin.Context) {
+		var body cuerpoReabastecer
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if body.Cantidad <= 0 || (body.Umbral != nil && *body.Umbral < 0) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cantidad debe ser mayor que 0 y umbral >= 0"})
+			return
+		}
+		producto := c.Param("producto")
+		umbral := ""
+		if body.Umbral != nil {
+			umbral = strconv.FormatInt(*body.Umbral, 10)
+		}
+		ctx := c.Request.Context()
+		res, err := scriptReabastecer.Run(ctx, inv.rdb, []string{claveInventario(producto)}, body.Cantidad, umbral).Int64Slice()
+		if err == nil {
+			err = inv.rdb.SAdd(ctx, claveProductosInventario, producto).Err()
+		}
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+		s, _, err := inv.consultar(ctx, producto)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+		inv.publicar("reabastecido", producto, res[0], res[1], s.Umbral)
+		c.JSON(http.StatusOK, s)
+	})
+}
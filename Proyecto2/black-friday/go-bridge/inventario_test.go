@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pb "go-bridge/pb"
+	"go-common/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writerFalso responde PublicarInventario con err; el resto de los metodos
+// no se usan en estas pruebas.
+type writerFalso struct {
+	pb.ProductSaleServiceClient
+	err error
+}
+
+func (w writerFalso) PublicarInventario(ctx context.Context, ev *pb.EventoInventario, opts ...grpc.CallOption) (*pb.ProductSaleResponse, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return &pb.ProductSaleResponse{Estado: "Procesado"}, nil
+}
+
+func nuevoInventario(t *testing.T, untracked string, err error) (*Inventario, func(producto string) map[string]string) {
+	t.Helper()
+	m, rdb := nuevoValkey(t)
+	cfg := config.Defaults()
+	cfg.Inventory.Untracked = untracked
+	cfg.Inventory.LowStock = 3
+	inv := NewInventario(rdb, writerFalso{err: err}, config.NewLoader(cfg))
+	m.HSet(claveInventario("P-1"), "disponible", "5", "total", "10", "vendido", "5")
+	return inv, func(producto string) map[string]string {
+		campos := map[string]string{}
+		for _, k := range []string{"disponible", "vendido"} {
+			campos[k] = m.HGet(claveInventario(producto), k)
+		}
+		return campos
+	}
+}
+
+func TestReservar(t *testing.T) {
+	casos := []struct {
+		nombre     string
+		untracked  string
+		producto   string
+		cantidad   int32
+		error      bool
+		sinReserva bool
+		disponible int64
+		aviso      string
+	}{
+		{nombre: "reserva sin aviso", producto: "P-1", cantidad: 1, disponible: 4},
+		{nombre: "cruza el umbral", producto: "P-1", cantidad: 2, disponible: 3, aviso: "stock_bajo"},
+		{nombre: "agota el stock", producto: "P-1", cantidad: 5, disponible: 0, aviso: "agotado"},
+		{nombre: "sin stock", producto: "P-1", cantidad: 6, error: true, disponible: 5},
+		{nombre: "cantidad invalida", producto: "P-1", cantidad: 0, error: true, sinReserva: true},
+		{nombre: "sin inventario permitido", untracked: "allow", producto: "P-2", cantidad: 1, sinReserva: true},
+		{nombre: "sin inventario rechazado", untracked: "reject", producto: "P-2", cantidad: 1, error: true, sinReserva: true},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			inv, stock := nuevoInventario(t, c.untracked, nil)
+			r, err := inv.Reservar(context.Background(), c.producto, c.cantidad)
+			if (err != nil) != c.error {
+				t.Fatalf("error %v", err)
+			}
+			if c.sinReserva {
+				if r != nil {
+					t.Fatalf("reserva inesperada %+v", r)
+				}
+				return
+			}
+			if r.Info.Disponible != c.disponible || r.aviso != c.aviso {
+				t.Errorf("disponible %d aviso %q", r.Info.Disponible, r.aviso)
+			}
+			if c.error {
+				if !errors.Is(err, errSinStock) || stock(c.producto)["disponible"] != "5" {
+					t.Errorf("sin stock no deberia descontar: %v %v", err, stock(c.producto))
+				}
+				return
+			}
+			r.Devolver(context.Background())
+			if s := stock(c.producto); s["disponible"] != "5" || s["vendido"] != "5" {
+				t.Errorf("despues de devolver %v", s)
+			}
+		})
+	}
+}
+
+func TestVentaNoEscrita(t *testing.T) {
+	casos := []struct {
+		nombre    string
+		res       *pb.ProductSaleResponse
+		err       error
+		noEscrita bool
+	}{
+		{"writer no disponible", nil, status.Error(codes.Unavailable, "conexion rechazada"), true},
+		{"identidad rechazada", nil, status.Error(codes.Unauthenticated, "identidad requerida"), true},
+		{"venta invalida", nil, status.Error(codes.InvalidArgument, "event_id"), true},
+		{"timeout", nil, status.Error(codes.DeadlineExceeded, "deadline"), false},
+		{"error desconocido", nil, errors.New("eof"), false},
+		{"error de marshaling", &pb.ProductSaleResponse{Estado: "Error marshaling"}, nil, true},
+		{"error de Kafka", &pb.ProductSaleResponse{Estado: "Error Kafka"}, nil, false},
+	}
+	for _, c := range casos {
+		if got := ventaNoEscrita(c.res, c.err); got != c.noEscrita {
+			t.Errorf("%s: ventaNoEscrita = %v", c.nombre, got)
+		}
+	}
+}
+
+func TestPublicarCuenta(t *testing.T) {
+	casos := []struct {
+		nombre               string
+		err                  error
+		publicados, fallidos int64
+	}{
+		{"publicado", nil, 1, 0},
+		{"fallido", status.Error(codes.Unavailable, "sin writer"), 0, 1},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			inv, _ := nuevoInventario(t, "allow", c.err)
+			inv.publicar("agotado", "P-1", 0, 10, 3)
+			limite := time.Now().Add(2 * time.Second)
+			for inv.publicados.Load()+inv.fallidos.Load() == 0 && time.Now().Before(limite) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if inv.publicados.Load() != c.publicados || inv.fallidos.Load() != c.fallidos {
+				t.Errorf("publicados %d fallidos %d", inv.publicados.Load(), inv.fallidos.Load())
+			}
+		})
+	}
+}
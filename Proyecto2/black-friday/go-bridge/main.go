@@ -2,15 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go-common/categorias"
+	"go-common/config"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	pb "go-bridge/pb"
+	"go-common/tlsutil"
+	"go-common/valkeyconf"
 )
 
 type Venta struct {
@@ -18,46 +24,239 @@ type Venta struct {
 	ProductoID      string  `json:"producto_id"`
 	Precio          float64 `json:"precio"`
 	CantidadVendida int32   `json:"cantidad_vendida"`
+	Canary          bool    `json:"canary"`
+
+	// Campos opcionales del sobre; ver armarSobre.
+	EventID   string    `json:"event_id"`
+	EventTime time.Time `json:"event_time"`
+}
+
+const ctxRecibido = "t-recibido"
+
+// selloRecibido marca la llegada de la solicitud antes de autenticacion y
+// rate limit, para que el primer salto incluya todo el tiempo en el bridge.
+func selloRecibido(c *gin.Context) {
+	c.Set(ctxRecibido, time.Now().UnixNano())
 }
 
 func main() {
-	grpcHost := os.Getenv("GRPC_HOST")
-	if grpcHost == "" {
-		grpcHost = "localhost:50051"
+	loader := config.MustLoad("go-bridge")
+	cfg := loader.Get()
+
+	creds := insecure.NewCredentials()
+	tlsOpts := tlsutil.Options{
+		Mode:        cfg.GRPCTLS.Mode,
+		CertFile:    cfg.GRPCTLS.Cert,
+		KeyFile:     cfg.GRPCTLS.Key,
+		CAFile:      cfg.GRPCTLS.CA,
+		ServerName:  cfg.GRPCTLS.ServerName,
+		AllowedSANs: cfg.GRPCTLS.AllowedSANs,
+		DevDir:      cfg.GRPCTLS.DevDir,
+	}
+	if tlsOpts.Enabled() {
+		tlsCfg, err := tlsutil.ClientConfig(tlsOpts)
+		if err != nil {
+			log.Fatalf("Fatal TLS: %v", err)
+		}
+		creds = credentials.NewTLS(tlsCfg)
 	}
 
-	conn, err := grpc.NewClient(grpcHost, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(cfg.Bridge.GRPCHost, grpc.WithTransportCredentials(creds))
 	if err != nil {
 		log.Fatalf("Fatal: %v", err)
 	}
 	defer conn.Close()
 	client := pb.NewProductSaleServiceClient(conn)
 
+	rdb, err := valkeyconf.New(valkeyconf.Options{
+		Mode:             cfg.Valkey.Mode,
+		Addr:             cfg.Valkey.Addr,
+		Addrs:            cfg.Valkey.Addrs,
+		MasterName:       cfg.Valkey.MasterName,
+		Username:         cfg.Valkey.Username,
+		Password:         cfg.Valkey.Password,
+		PasswordFile:     cfg.Valkey.PasswordFile,
+		SentinelPassword: cfg.Valkey.SentinelPassword,
+		DB:               cfg.Valkey.DB,
+		TLS:              valkeyconf.TLS(cfg.Valkey.TLS),
+	})
+	if err != nil {
+		log.Fatalf("Fatal Valkey: %v", err)
+	}
+	limiter, err := NewRateLimiter(rdb, cfg.Bridge.RateLimit)
+	if err != nil {
+		log.Fatalf("Fatal: %v", err)
+	}
+	go limiter.Sincronizar(context.Background(), cfg.Bridge.RateLimit.SyncInterval)
+
+	shedder := NewLoadShedder(cfg.Bridge.Shed)
+
+	auths, err := NewAutenticadores(context.Background(), cfg.Auth)
+	if err != nil {
+		log.Fatalf("Fatal: %v", err)
+	}
+	secretoIdentidad := []byte(cfg.Auth.IdentitySecret)
+
+	// Con source valkey el registro se lee de Valkey y se vigila su version;
+	// con source config se reemplaza en cada recarga del archivo.
+	var rdbCategorias redis.UniversalClient
+	if cfg.Registry.Source == "valkey" {
+		rdbCategorias = rdb
+	}
+	registro := categorias.NewRegistro(rdbCategorias, categoriasDeConfig(cfg))
+	if err := registro.Cargar(context.Background()); err != nil {
+		log.Printf("Registro de categorias: se usa el de config hasta poder leer Valkey: %v", err)
+	}
+	go registro.Vigilar(context.Background(), cfg.Registry.Refresh)
+	go vigilarArtefactoCategorias(context.Background(), cfg, registro)
+
+	var catalogo *Catalogo
+	if cfg.Catalog.Path != "" || cfg.Catalog.Artifact.Ref != "" {
+		ruta, err := rutaCatalogo(context.Background(), cfg.Catalog)
+		if err != nil {
+			log.Fatalf("Fatal catalogo: %v", err)
+		}
+		catalogo, err = NewCatalogo(ruta, rdb)
+		if err != nil {
+			log.Fatalf("Fatal: %v", err)
+		}
+		go catalogo.Recargar(context.Background(), cfg.Catalog.Refresh)
+	}
+
+	var inventario *Inventario
+	if cfg.Inventory.Enabled {
+		inventario = NewInventario(rdb, client, loader)
+	}
+
+	loader.OnReload(func(nuevo *config.Config) {
+		if nuevo.Registry.Source == "config" {
+			registro.Reemplazar(categoriasDeConfig(nuevo))
+		}
+		if err := limiter.Actualizar(context.Background(), nuevo.Bridge.RateLimit); err != nil {
+			log.Printf("config: rate limit invalido, se mantiene el anterior: %v", err)
+		}
+		shedder.Actualizar(nuevo.Bridge.Shed)
+	})
+	go loader.Watch(context.Background(), 5*time.Second)
+
 	r := gin.Default()
-	r.POST("/forward", func(c *gin.Context) {
-		var v Venta
-		if err := c.ShouldBindJSON(&v); err != nil {
+	rutasCategorias(r, registro, AuthMiddleware(auths), AdminMiddleware(loader))
+	if catalogo != nil {
+		r.GET("/admin/catalogo", AuthMiddleware(auths), AdminMiddleware(loader), catalogo.handleEstado)
+	}
+	if inventario != nil {
+		inventario.rutas(r, AuthMiddleware(auths), AdminMiddleware(loader))
+	}
+	rutasAjustes(r, client, loader, secretoIdentidad, selloRecibido, AuthMiddleware(auths), limiter.Middleware(), shedder.Middleware())
+	r.POST("/forward", selloRecibido, AuthMiddleware(auths), limiter.Middleware(), shedder.Middleware(), func(c *gin.Context) {
+		v, err := leerVenta(c)
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		sobre, err := armarSobre(v, time.Unix(0, c.GetInt64(ctxRecibido)), loader.Get().Bridge.Evento)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Las ventas del canary usan productos sinteticos que no estan en el
+		// catalogo.
+		var infoCatalogo *pb.ProductoCatalogo
+		if catalogo != nil && !v.Canary {
+			infoCatalogo, err = catalogo.Aplicar(c.Request.Context(), &v, loader.Get().Catalog)
+			if err != nil {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		if err := validarCategoria(registro, v.Categoria, loader.Get().Registry.Unknown); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+
+		var infoCampana *pb.CampanaVenta
+		if !v.Canary {
+			infoCampana, err = aplicarCampana(loader.Get().Campaigns, v, c.GetString(ctxIdentidad), time.Unix(0, c.GetInt64(ctxRecibido)))
+			if err != nil {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		if !limiter.PermitirCategoria(c, v.Categoria) {
+			return
+		}
+
+		// El stock se reserva antes de enviar la venta y se devuelve solo si
+		// es seguro que no llego a Kafka; ver ventaNoEscrita.
+		var infoInventario *pb.InventarioVenta
+		var reserva *Reserva
+		if inventario != nil && !v.Canary {
+			reserva, err = inventario.Reservar(c.Request.Context(), v.ProductoID, v.CantidadVendida)
+			if errors.Is(err, errSinStock) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "disponible": reserva.Info.Disponible})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+				return
+			}
+			if reserva != nil {
+				infoInventario = reserva.Info
+			}
+		}
+		liberar := func(res *pb.ProductSaleResponse, err error) {
+			switch {
+			case reserva == nil:
+			case ventaNoEscrita(res, err):
+				reserva.Devolver(context.Background())
+			default:
+				log.Printf("Inventario: se retienen %d de %s, el evento %s pudo llegar a Kafka", v.CantidadVendida, v.ProductoID, sobre.EventId)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), loader.Get().Bridge.RequestTimeout)
 		defer cancel()
+		ctx = contextoConIdentidad(ctx, c, secretoIdentidad)
 
+		inicio := time.Now()
 		res, err := client.ProcesarVenta(ctx, &pb.ProductSaleRequest{
-			Categoria:       pb.CategoriaProducto(v.Categoria),
+			Categoria:       v.Categoria,
 			ProductoId:      v.ProductoID,
 			Precio:          v.Precio,
 			CantidadVendida: v.CantidadVendida,
+			Canary:          v.Canary,
+			Envelope:        sobre,
+			Catalogo:        infoCatalogo,
+			Inventario:      infoInventario,
+			Campana:         infoCampana,
+			Sellos: &pb.SellosLatencia{
+				BridgeRecibido: c.GetInt64(ctxRecibido),
+				BridgeEnviado:  inicio.UnixNano(),
+			},
 		})
+		shedder.Observar(time.Since(inicio))
 
 		if err != nil {
+			liberar(nil, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		// El writer informa los errores de Kafka en el estado, no como error.
+		if res.Estado != "Procesado" {
+			liberar(res, nil)
+			c.JSON(http.StatusBadGateway, gin.H{"error": res.Estado, "event_id": sobre.EventId})
+			return
+		}
+		if reserva != nil {
+			reserva.Confirmar()
+		}
 
-		c.JSON(http.StatusOK, gin.H{"estado": res.Estado})
+		c.JSON(http.StatusOK, gin.H{"estado": res.Estado, "event_id": sobre.EventId, "sellos": res.Sellos})
 	})
 
-	r.Run(":8080")
+	r.Run(cfg.Bridge.Listen)
 }
\ No newline at end of file
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go-common/config"
+)
+
+// Limite define un token bucket: Rate tokens por segundo con capacidad Burst.
+type Limite struct {
+	Rate  float64
+	Burst float64
+}
+
+func (l Limite) activo() bool { return l.Rate > 0 && l.Burst > 0 }
+
+// parseLimite acepta "rate:burst" o solo "rate" (burst = rate).
+func parseLimite(s string) (Limite, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Limite{}, nil
+	}
+	partes := strings.SplitN(s, ":", 2)
+	rate, err := strconv.ParseFloat(partes[0], 64)
+	if err != nil || rate < 0 {
+		return Limite{}, fmt.Errorf("limite invalido %q", s)
+	}
+	burst := rate
+	if len(partes) == 2 {
+		burst, err = strconv.ParseFloat(partes[1], 64)
+		if err != nil || burst < 0 {
+			return Limite{}, fmt.Errorf("limite invalido %q", s)
+		}
+	}
+	return Limite{Rate: rate, Burst: burst}, nil
+}
+
+// parseLimitesCategoria acepta "rate:burst" (todas las categorias) y/o
+// "id=rate:burst" separados por coma.
+func parseLimitesCategoria(s string) (Limite, map[int32]Limite, error) {
+	var defecto Limite
+	porCat := map[int32]Limite{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, valor, tieneID := strings.Cut(item, "=")
+		if !tieneID {
+			l, err := parseLimite(item)
+			if err != nil {
+				return defecto, nil, err
+			}
+			defecto = l
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(id), 10, 32)
+		if err != nil {
+			return defecto, nil, fmt.Errorf("categoria invalida %q", id)
+		}
+		l, err := parseLimite(valor)
+		if err != nil {
+			return defecto, nil, err
+		}
+		porCat[int32(n)] = l
+	}
+	return defecto, porCat, nil
+}
+
+type limites struct {
+	global       Limite
+	cliente      Limite
+	categoria    Limite
+	porCategoria map[int32]Limite
+}
+
+func (l *limites) deCategoria(cat int32) Limite {
+	if lc, ok := l.porCategoria[cat]; ok {
+		return lc
+	}
+	return l.categoria
+}
+
+// tokenBucketScript descuenta un token de forma atomica y devuelve
+// {permitido, milisegundos hasta el siguiente token}.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1]) or burst
+local ts = tonumber(data[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local permitido = 0
+local espera = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  permitido = 1
+else
+  espera = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {permitido, espera}
+`)
+
+type bucketLocal struct {
+	tokens float64
+	ts     time.Time
+	// lleno es cuando el bucket vuelve a estar completo si no se usa; desde
+	// ahi es igual a uno nuevo y se puede descartar.
+	lleno time.Time
+}
+
+const (
+	// barridoLocales es cada cuanto se descartan los buckets locales que ya
+	// se llenaron.
+	barridoLocales = time.Minute
+	// maxLocales acota los buckets locales: con mas, se descartan los que
+	// llevan mas tiempo sin usarse aunque no esten llenos.
+	maxLocales = 10000
+)
+
+// RateLimiter aplica los limites global, por cliente y por categoria. El
+// estado de los buckets vive en Valkey para que todas las replicas del bridge
+// compartan la cuota; si Valkey no responde se usa un bucket local.
+type RateLimiter struct {
+	rdb redis.UniversalClient
+
+	mu      sync.RWMutex
+	lims    limites
+	base    limites
+	locales map[string]*bucketLocal
+	localMu sync.Mutex
+	barrido time.Time
+}
+
+const keyRateLimitConfig = "ratelimit:config"
+
+func parseLimites(cfg config.RateLimit) (limites, error) {
+	global, err := parseLimite(cfg.Global)
+	if err != nil {
+		return limites{}, fmt.Errorf("bridge.rate_limit.global: %w", err)
+	}
+	cliente, err := parseLimite(cfg.Client)
+	if err != nil {
+		return limites{}, fmt.Errorf("bridge.rate_limit.client: %w", err)
+	}
+	cat, porCat, err := parseLimitesCategoria(cfg.Categoria)
+	if err != nil {
+		return limites{}, fmt.Errorf("bridge.rate_limit.categoria: %w", err)
+	}
+	return limites{global: global, cliente: cliente, categoria: cat, porCategoria: porCat}, nil
+}
+
+func NewRateLimiter(rdb redis.UniversalClient, cfg config.RateLimit) (*RateLimiter, error) {
+	base, err := parseLimites(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &RateLimiter{rdb: rdb, lims: base, base: base, locales: map[string]*bucketLocal{}}, nil
+}
+
+// Actualizar reemplaza los limites base despues de recargar la configuracion.
+// Los valores de ratelimit:config en Valkey siguen teniendo prioridad.
+func (rl *RateLimiter) Actualizar(ctx context.Context, cfg config.RateLimit) error {
+	base, err := parseLimites(cfg)
+	if err != nil {
+		return err
+	}
+	rl.mu.Lock()
+	rl.base = base
+	rl.lims = base
+	rl.mu.Unlock()
+	if rl.rdb != nil {
+		rl.cargarConfig(ctx)
+	}
+	return nil
+}
+
+// Sincronizar lee periodicamente el hash ratelimit:config de Valkey
+// (campos global, cliente, categoria) para que un cambio aplique a todas las
+// replicas sin redeploy.
+func (rl *RateLimiter) Sincronizar(ctx context.Context, intervalo time.Duration) {
+	if rl.rdb == nil {
+		return
+	}
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for {
+		rl.cargarConfig(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (rl *RateLimiter) cargarConfig(ctx context.Context) {
+	campos, err := rl.rdb.HGetAll(ctx, keyRateLimitConfig).Result()
+	if err != nil {
+		return
+	}
+	rl.mu.RLock()
+	nuevos := rl.base
+	rl.mu.RUnlock()
+	if s, ok := campos["global"]; ok {
+		if l, err := parseLimite(s); err == nil {
+			nuevos.global = l
+		}
+	}
+	if s, ok := campos["cliente"]; ok {
+		if l, err := parseLimite(s); err == nil {
+			nuevos.cliente = l
+		}
+	}
+	if s, ok := campos["categoria"]; ok {
+		if def, porCat, err := parseLimitesCategoria(s); err == nil {
+			nuevos.categoria = def
+			nuevos.porCategoria = porCat
+		}
+	}
+	rl.mu.Lock()
+	rl.lims = nuevos
+	rl.mu.Unlock()
+}
+
+func (rl *RateLimiter) limites() limites {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.lims
+}
+
+func (rl *RateLimiter) tomar(ctx context.Context, key string, l Limite) (bool, time.Duration) {
+	if !l.activo() {
+		return true, 0
+	}
+	if rl.rdb != nil {
+		res, err := tokenBucketScript.Run(ctx, rl.rdb, []string{key}, l.Rate, l.Burst).Int64Slice()
+		if err == nil && len(res) == 2 {
+			return res[0] == 1, time.Duration(res[1]) * time.Millisecond
+		}
+	}
+	return rl.tomarLocal(key, l)
+}
+
+func (rl *RateLimiter) tomarLocal(key string, l Limite) (bool, time.Duration) {
+	rl.localMu.Lock()
+	defer rl.localMu.Unlock()
+	now := time.Now()
+	b, ok := rl.locales[key]
+	if !ok {
+		if now.Sub(rl.barrido) > barridoLocales || len(rl.locales) >= maxLocales {
+			rl.barrerLocales(now)
+		}
+		b = &bucketLocal{tokens: l.Burst, ts: now}
+		rl.locales[key] = b
+	}
+	b.tokens = math.Min(l.Burst, b.tokens+now.Sub(b.ts).Seconds()*l.Rate)
+	b.ts = now
+	b.lleno = now.Add(time.Duration((l.Burst - b.tokens) / l.Rate * float64(time.Second)))
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// barrerLocales descarta los buckets locales que ya se llenaron y, si aun
+// quedan demasiados, los que llevan mas tiempo sin usarse hasta dejar tres
+// cuartos de maxLocales. Se llama con localMu tomado.
+func (rl *RateLimiter) barrerLocales(now time.Time) {
+	rl.barrido = now
+	for k, b := range rl.locales {
+		if !now.Before(b.lleno) {
+			delete(rl.locales, k)
+		}
+	}
+	if len(rl.locales) < maxLocales {
+		return
+	}
+	claves := make([]string, 0, len(rl.locales))
+	for k := range rl.locales {
+		claves = append(claves, k)
+	}
+	sort.Slice(claves, func(i, j int) bool { return rl.locales[claves[i]].ts.Before(rl.locales[claves[j]].ts) })
+	for _, k := range claves[:len(claves)-maxLocales*3/4] {
+		delete(rl.locales, k)
+	}
+}
+
+// Middleware aplica los limites global y por cliente antes de leer el body.
+func (rl *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lims := rl.limites()
+		ctx := c.Request.Context()
+		if ok, espera := rl.tomar(ctx, "ratelimit:global", lims.global); !ok {
+			rechazarPorLimite(c, espera)
+			return
+		}
+		if ok, espera := rl.tomar(ctx, "ratelimit:cliente:"+identidadCliente(c), lims.cliente); !ok {
+			rechazarPorLimite(c, espera)
+			return
+		}
+		c.Next()
+	}
+}
+
+// PermitirCategoria aplica el limite de la categoria de la venta.
+func (rl *RateLimiter) PermitirCategoria(c *gin.Context, categoria int32) bool {
+	lims := rl.limites()
+	key := fmt.Sprintf("ratelimit:categoria:%d", categoria)
+	if ok, espera := rl.tomar(c.Request.Context(), key, lims.deCategoria(categoria)); !ok {
+		rechazarPorLimite(c, espera)
+		return false
+	}
+	return true
+}
+
+// identidadCliente es la clave del bucket por cliente: la identidad
+// autenticada, que no cambia al rotar la API key. Sin auth se usa un hash
+// truncado de la API key, nunca la key misma, porque termina en el nombre de
+// una clave de Valkey.
+func identidadCliente(c *gin.Context) string {
+	if id := c.GetString(ctxIdentidad); id != "" && id != identidadAnonima {
+		return "id:" + id
+	}
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		suma := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(suma[:8])
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func retryAfter(espera time.Duration) string {
+	seg := int(math.Ceil(espera.Seconds()))
+	if seg < 1 {
+		seg = 1
+	}
+	return strconv.Itoa(seg)
+}
+
+func rechazarPorLimite(c *gin.Context, espera time.Duration) {
+	c.Header("Retry-After", retryAfter(espera))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "limite de solicitudes excedido"})
+}
+
+// LoadShedder rechaza solicitudes de forma temprana cuando hay demasiadas en
+// vuelo o la latencia del writer (EWMA) supera el umbral.
+type LoadShedder struct {
+	maxInflight atomic.Int64
+	maxLatencia atomic.Int64
+
+	inflight    atomic.Int64
+	ewma        atomic.Int64
+	ultimaMuest atomic.Int64
+}
+
+func NewLoadShedder(cfg config.Shed) *LoadShedder {
+	s := &LoadShedder{}
+	s.Actualizar(cfg)
+	return s
+}
+
+// Actualizar cambia los umbrales; se usa al recargar la configuracion.
+func (s *LoadShedder) Actualizar(cfg config.Shed) {
+	s.maxInflight.Store(cfg.MaxInflight)
+	s.maxLatencia.Store(int64(cfg.MaxLatency))
+}
+
+func (s *LoadShedder) lento() bool {
+	if max := s.maxLatencia.Load(); max > 0 && s.ewma.Load() > max {
+		// Sin muestras recientes la EWMA no se actualiza; se deja pasar trafico
+		// para poder observar si el writer se recupero.
+		return time.Since(time.Unix(0, s.ultimaMuest.Load())) < time.Second
+	}
+	return false
+}
+
+// Middleware rechaza la solicitud antes de leer el cuerpo o reservar stock si
+// el bridge esta sobrecargado. La solicitud cuenta como en vuelo hasta que
+// termina el handler.
+func (s *LoadShedder) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.Entrar(c) {
+			return
+		}
+		defer s.Salir()
+		c.Next()
+	}
+}
+
+// Entrar registra una solicitud en vuelo; devuelve false si debe rechazarse.
+// El lugar se reserva antes de comparar con el maximo, asi dos solicitudes
+// concurrentes no pueden pasar las dos con el ultimo lugar.
+func (s *LoadShedder) Entrar(c *gin.Context) bool {
+	n := s.inflight.Add(1)
+	if max := s.maxInflight.Load(); (max > 0 && n > max) || s.lento() {
+		s.inflight.Add(-1)
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "servicio sobrecargado"})
+		return false
+	}
+	return true
+}
+
+// Salir libera la solicitud en vuelo.
+func (s *LoadShedder) Salir() {
+	s.inflight.Add(-1)
+}
+
+// Observar actualiza la EWMA con la latencia de una llamada al writer.
+func (s *LoadShedder) Observar(latencia time.Duration) {
+	prev := s.ewma.Load()
+	next := int64(latencia)
+	if prev != 0 {
+		next = int64(0.8*float64(prev) + 0.2*float64(latencia))
+	}
+	s.ewma.Store(next)
+	s.ultimaMuest.Store(time.Now().UnixNano())
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// nuevoValkey levanta un Valkey en memoria con el reloj fijo, para que TIME
+// dentro de los scripts sea predecible.
+func nuevoValkey(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	m.SetTime(time.Unix(1700000000, 0))
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return m, rdb
+}
+
+func TestParseLimite(t *testing.T) {
+	casos := []struct {
+		entrada string
+		limite  Limite
+		error   bool
+	}{
+		{"", Limite{}, false},
+		{"10", Limite{Rate: 10, Burst: 10}, false},
+		{"10:50", Limite{Rate: 10, Burst: 50}, false},
+		{" 0.5:1 ", Limite{Rate: 0.5, Burst: 1}, false},
+		{"-1", Limite{}, true},
+		{"10:-1", Limite{}, true},
+		{"diez", Limite{}, true},
+	}
+	for _, c := range casos {
+		l, err := parseLimite(c.entrada)
+		if (err != nil) != c.error || l != c.limite {
+			t.Errorf("parseLimite(%q) = %+v, %v", c.entrada, l, err)
+		}
+	}
+
+	def, porCat, err := parseLimitesCategoria("5:10, 2=1:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def != (Limite{Rate: 5, Burst: 10}) || porCat[2] != (Limite{Rate: 1, Burst: 2}) {
+		t.Errorf("parseLimitesCategoria = %+v, %+v", def, porCat)
+	}
+	if _, _, err := parseLimitesCategoria("x=1:2"); err == nil {
+		t.Error("se esperaba un error con una categoria invalida")
+	}
+}
+
+func TestTokenBucketScript(t *testing.T) {
+	m, rdb := nuevoValkey(t)
+	ctx := context.Background()
+	tomar := func() (int64, int64) {
+		t.Helper()
+		res, err := tokenBucketScript.Run(ctx, rdb, []string{"ratelimit:prueba"}, 1, 2).Int64Slice()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res[0], res[1]
+	}
+	pasos := []struct {
+		avanzar   time.Duration
+		permitido int64
+		espera    int64
+	}{
+		{0, 1, 0},
+		{0, 1, 0},
+		{0, 0, 1000},
+		{400 * time.Millisecond, 0, 600},
+		{600 * time.Millisecond, 1, 0},
+		// Mucho tiempo sin uso no acumula mas que burst.
+		{time.Hour, 1, 0},
+		{0, 1, 0},
+		{0, 0, 1000},
+	}
+	ahora := time.Unix(1700000000, 0)
+	for i, p := range pasos {
+		ahora = ahora.Add(p.avanzar)
+		m.SetTime(ahora)
+		permitido, espera := tomar()
+		if permitido != p.permitido || espera != p.espera {
+			t.Errorf("paso %d: permitido=%d espera=%dms, se esperaba %d y %dms", i, permitido, espera, p.permitido, p.espera)
+		}
+	}
+	if ttl := m.TTL("ratelimit:prueba"); ttl <= 0 || ttl > 3*time.Second {
+		t.Errorf("TTL del bucket %s, se esperaba burst/rate + 1s", ttl)
+	}
+}
+
+func TestTomarSinValkey(t *testing.T) {
+	rl := &RateLimiter{locales: map[string]*bucketLocal{}}
+	l := Limite{Rate: 1, Burst: 2}
+	for i, esperado := range []bool{true, true, false} {
+		if ok, _ := rl.tomar(context.Background(), "ratelimit:cliente:x", l); ok != esperado {
+			t.Errorf("solicitud %d: permitido=%v", i, ok)
+		}
+	}
+	if ok, _ := rl.tomar(context.Background(), "ratelimit:cliente:x", Limite{}); !ok {
+		t.Error("un limite sin configurar no deberia rechazar")
+	}
+}
+
+func TestTomarConValkeyCaido(t *testing.T) {
+	m, rdb := nuevoValkey(t)
+	m.Close()
+	rl := &RateLimiter{rdb: rdb, locales: map[string]*bucketLocal{}}
+	l := Limite{Rate: 1, Burst: 1}
+	if ok, _ := rl.tomar(context.Background(), "ratelimit:global", l); !ok {
+		t.Error("la primera solicitud deberia pasar con el bucket local")
+	}
+	if ok, espera := rl.tomar(context.Background(), "ratelimit:global", l); ok || espera <= 0 {
+		t.Errorf("la segunda solicitud deberia rechazarse: ok=%v espera=%s", ok, espera)
+	}
+}
+
+func TestLoadShedderMiddleware(t *testing.T) {
+	s := &LoadShedder{}
+	s.maxInflight.Store(1)
+
+	dentro, soltar := make(chan struct{}), make(chan struct{})
+	r := gin.New()
+	r.POST("/forward", s.Middleware(), func(c *gin.Context) {
+		close(dentro)
+		<-soltar
+		c.Status(http.StatusOK)
+	})
+
+	primera := httptest.NewRecorder()
+	listo := make(chan struct{})
+	go func() {
+		r.ServeHTTP(primera, httptest.NewRequest(http.MethodPost, "/forward", nil))
+		close(listo)
+	}()
+	<-dentro
+
+	segunda := httptest.NewRecorder()
+	r.ServeHTTP(segunda, httptest.NewRequest(http.MethodPost, "/forward", nil))
+	if segunda.Code != http.StatusServiceUnavailable || segunda.Header().Get("Retry-After") == "" {
+		t.Errorf("con el maximo en vuelo: status %d, Retry-After %q", segunda.Code, segunda.Header().Get("Retry-After"))
+	}
+
+	close(soltar)
+	<-listo
+	if primera.Code != http.StatusOK {
+		t.Errorf("la primera solicitud termino con %d", primera.Code)
+	}
+	if n := s.inflight.Load(); n != 0 {
+		t.Errorf("quedaron %d solicitudes en vuelo", n)
+	}
+}
+
+func TestLoadShedderLatencia(t *testing.T) {
+	s := &LoadShedder{}
+	s.maxLatencia.Store(int64(100 * time.Millisecond))
+	s.Observar(time.Second)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if s.Entrar(c) {
+		t.Error("con la EWMA sobre el umbral deberia rechazar")
+	}
+	// Sin muestras recientes se deja pasar trafico para volver a medir.
+	s.ultimaMuest.Store(time.Now().Add(-2 * time.Second).UnixNano())
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	if !s.Entrar(c) {
+		t.Error("sin muestras recientes deberia dejar pasar")
+	}
+	s.Salir()
+}
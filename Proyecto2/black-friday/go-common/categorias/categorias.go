@@ -0,0 +1,258 @@
+// Package categorias mantiene el registro de categorias que usan el bridge
+// (para validar ventas) y el consumer (para nombrar claves). El registro se
+// arma desde config o vive en Valkey y se recarga en caliente.
+package categorias
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Claves del registro en Valkey. Comparten hash tag para que la transaccion
+// que las modifica funcione en cluster; no llevan el namespace del consumer
+// porque las categorias son las mismas en todas las corridas.
+const (
+	ClaveRegistro = "{categorias}:registro"
+	ClaveVersion  = "{categorias}:version"
+)
+
+var slugValido = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+var (
+	ErrSoloLectura = errors.New("el registro de categorias se define en config; use category_registry.source valkey")
+	ErrSlugFijo    = errors.New("el slug de una categoria no se puede cambiar")
+)
+
+type Categoria struct {
+	ID     int32  `json:"id"`
+	Slug   string `json:"slug"`
+	Nombre string `json:"nombre"`
+	Activa bool   `json:"activa"`
+}
+
+func (c Categoria) Validar() error {
+	if c.ID <= 0 {
+		return fmt.Errorf("id %d: debe ser mayor que 0", c.ID)
+	}
+	if !slugValido.MatchString(c.Slug) {
+		return fmt.Errorf("slug %q: solo letras, digitos, - y _", c.Slug)
+	}
+	if c.Nombre == "" {
+		return fmt.Errorf("id %d: falta el nombre", c.ID)
+	}
+	return nil
+}
+
+// LeerArchivo lee un JSON con la lista de categorias, p.ej. el que se publica
+// como artefacto OCI.
+func LeerArchivo(ruta string) ([]Categoria, error) {
+	b, err := os.ReadFile(ruta)
+	if err != nil {
+		return nil, err
+	}
+	var cs []Categoria
+	if err := json.Unmarshal(b, &cs); err != nil {
+		return nil, fmt.Errorf("%s: %w", ruta, err)
+	}
+	ids, slugs := map[int32]bool{}, map[string]bool{}
+	for _, c := range cs {
+		if err := c.Validar(); err != nil {
+			return nil, fmt.Errorf("%s: %w", ruta, err)
+		}
+		if ids[c.ID] || slugs[c.Slug] {
+			return nil, fmt.Errorf("%s: categoria %d (%s) repetida", ruta, c.ID, c.Slug)
+		}
+		ids[c.ID], slugs[c.Slug] = true, true
+	}
+	if len(cs) == 0 {
+		return nil, fmt.Errorf("%s: no hay categorias", ruta)
+	}
+	return cs, nil
+}
+
+// Registro es la copia local de las categorias. Sin cliente de Valkey solo se
+// actualiza con Reemplazar (p.ej. desde un OnReload de config).
+type Registro struct {
+	rdb redis.UniversalClient
+
+	mu      sync.RWMutex
+	porID   map[int32]Categoria
+	version int64
+}
+
+func NewRegistro(rdb redis.UniversalClient, cs []Categoria) *Registro {
+	r := &Registro{rdb: rdb}
+	r.Reemplazar(cs)
+	return r
+}
+
+// Reemplazar cambia todo el registro local.
+func (r *Registro) Reemplazar(cs []Categoria) {
+	porID := make(map[int32]Categoria, len(cs))
+	for _, c := range cs {
+		porID[c.ID] = c
+	}
+	r.mu.Lock()
+	r.porID = porID
+	r.mu.Unlock()
+}
+
+func (r *Registro) Buscar(id int32) (Categoria, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.porID[id]
+	return c, ok
+}
+
+// Lista devuelve las categorias ordenadas por id, activas o no.
+func (r *Registro) Lista() []Categoria {
+	r.mu.RLock()
+	cs := make([]Categoria, 0, len(r.porID))
+	for _, c := range r.porID {
+		cs = append(cs, c)
+	}
+	r.mu.RUnlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].ID < cs[j].ID })
+	return cs
+}
+
+// Cargar lee el registro de Valkey. Si todavia no existe lo siembra con las
+// categorias locales (las de config), sin pisar las que otra instancia haya
+// escrito antes.
+func (r *Registro) Cargar(ctx context.Context) error {
+	if r.rdb == nil {
+		return nil
+	}
+	crudo, err := r.rdb.HGetAll(ctx, ClaveRegistro).Result()
+	if err != nil {
+		return err
+	}
+	if len(crudo) == 0 {
+		if len(r.Lista()) == 0 {
+			return errors.New("el registro de categorias esta vacio")
+		}
+		_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, c := range r.Lista() {
+				b, _ := json.Marshal(c)
+				pipe.HSetNX(ctx, ClaveRegistro, strconv.Itoa(int(c.ID)), b)
+			}
+			pipe.Incr(ctx, ClaveVersion)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("sembrando el registro de categorias: %w", err)
+		}
+		log.Printf("Registro de categorias sembrado en Valkey con %d categorias", len(r.Lista()))
+		return r.Cargar(ctx)
+	}
+	version, err := r.rdb.Get(ctx, ClaveVersion).Int64()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	cs, err := decodificar(crudo)
+	if err != nil {
+		return err
+	}
+	r.Reemplazar(cs)
+	r.mu.Lock()
+	r.version = version
+	r.mu.Unlock()
+	return nil
+}
+
+func decodificar(crudo map[string]string) ([]Categoria, error) {
+	cs := make([]Categoria, 0, len(crudo))
+	for id, v := range crudo {
+		var c Categoria
+		if err := json.Unmarshal([]byte(v), &c); err != nil {
+			return nil, fmt.Errorf("categoria %s: %w", id, err)
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+// Vigilar consulta la version del registro cada intervalo y lo recarga cuando
+// cambia. Bloquea hasta que ctx termina.
+func (r *Registro) Vigilar(ctx context.Context, intervalo time.Duration) {
+	if r.rdb == nil {
+		return
+	}
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		version, err := r.rdb.Get(ctx, ClaveVersion).Int64()
+		if err != nil && err != redis.Nil {
+			log.Printf("Registro de categorias: %v", err)
+			continue
+		}
+		r.mu.RLock()
+		igual := version == r.version
+		r.mu.RUnlock()
+		if igual {
+			continue
+		}
+		if err := r.Cargar(ctx); err != nil {
+			log.Printf("Registro de categorias: se mantiene el anterior: %v", err)
+			continue
+		}
+		log.Printf("Registro de categorias recargado (version %d, %d categorias)", version, len(r.Lista()))
+	}
+}
+
+// Guardar crea o actualiza una categoria en Valkey. El slug es fijo y unico
+// porque forma las claves de los agregados. WATCH evita que dos cambios
+// concurrentes dejen slugs repetidos.
+func (r *Registro) Guardar(ctx context.Context, c Categoria) error {
+	if r.rdb == nil {
+		return ErrSoloLectura
+	}
+	if err := c.Validar(); err != nil {
+		return err
+	}
+	err := r.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		crudo, err := tx.HGetAll(ctx, ClaveRegistro).Result()
+		if err != nil {
+			return err
+		}
+		actuales, err := decodificar(crudo)
+		if err != nil {
+			return err
+		}
+		for _, a := range actuales {
+			if a.ID == c.ID && a.Slug != c.Slug {
+				return ErrSlugFijo
+			}
+			if a.ID != c.ID && a.Slug == c.Slug {
+				return fmt.Errorf("el slug %q ya es de la categoria %d", c.Slug, a.ID)
+			}
+		}
+		b, _ := json.Marshal(c)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, ClaveRegistro, strconv.Itoa(int(c.ID)), b)
+			pipe.Incr(ctx, ClaveVersion)
+			return nil
+		})
+		return err
+	}, ClaveRegistro)
+	if err != nil {
+		return err
+	}
+	return r.Cargar(ctx)
+}
@@ -0,0 +1,1194 @@
+// Package config carga la configuracion comun de los servicios Go: valores por
+// defecto, archivo YAML, variables de entorno y flags -set, en ese orden.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultPath = "/etc/black-friday/config.yaml"
+
+type Config struct {
+	Kafka      Kafka            `yaml:"kafka"`
+	Valkey     Valkey           `yaml:"valkey"`
+	Bridge     Bridge           `yaml:"bridge"`
+	Writer     Writer           `yaml:"writer"`
+	Consumer   Consumer         `yaml:"consumer"`
+	Auth       Auth             `yaml:"auth"`
+	GRPCTLS    TLS              `yaml:"grpc_tls"`
+	Retention  Retention        `yaml:"retention"`
+	Canary     Canary           `yaml:"canary"`
+	Registry   CategoryRegistry `yaml:"category_registry"`
+	Catalog    Catalog          `yaml:"catalog"`
+	Inventory  Inventory        `yaml:"inventory"`
+	Campaigns  []Campaign       `yaml:"campaigns" reload:"safe"`
+	Anomalies  Anomalies        `yaml:"anomalies"`
+	Alerting   Alerting         `yaml:"alerting"`
+	Fraud      Fraud            `yaml:"fraud"`
+	Categorias map[int32]string `yaml:"categorias" env:"CATEGORIAS" reload:"safe"`
+}
+
+type Kafka struct {
+	Brokers []string  `yaml:"brokers" env:"KAFKA_BROKERS"`
+	Topic   string    `yaml:"topic" env:"KAFKA_TOPIC"`
+	Group   string    `yaml:"group" env:"KAFKA_GROUP"`
+	Version string    `yaml:"version" env:"KAFKA_VERSION"`
+	SASL    KafkaSASL `yaml:"sasl"`
+	TLS     KafkaTLS  `yaml:"tls"`
+}
+
+// KafkaSASL admite PLAIN, SCRAM-SHA-256 y SCRAM-SHA-512. Las credenciales se
+// pueden leer de archivos montados desde un Secret (username_file,
+// password_file), que tienen prioridad sobre los valores directos.
+type KafkaSASL struct {
+	Mechanism    string `yaml:"mechanism" env:"KAFKA_SASL_MECHANISM"`
+	Username     string `yaml:"username" env:"KAFKA_SASL_USERNAME"`
+	UsernameFile string `yaml:"username_file" env:"KAFKA_SASL_USERNAME_FILE"`
+	Password     string `yaml:"password" env:"KAFKA_SASL_PASSWORD" secret:"true"`
+	PasswordFile string `yaml:"password_file" env:"KAFKA_SASL_PASSWORD_FILE"`
+}
+
+type KafkaTLS struct {
+	Enabled            bool   `yaml:"enabled" env:"KAFKA_TLS_ENABLED"`
+	CA                 string `yaml:"ca" env:"KAFKA_TLS_CA"`
+	Cert               string `yaml:"cert" env:"KAFKA_TLS_CERT"`
+	Key                string `yaml:"key" env:"KAFKA_TLS_KEY"`
+	ServerName         string `yaml:"server_name" env:"KAFKA_TLS_SERVER_NAME"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" env:"KAFKA_TLS_INSECURE_SKIP_VERIFY"`
+}
+
+// Valkey admite los modos standalone (addr), sentinel (addrs de los
+// sentinels y master_name) y cluster (addrs semilla). Namespace antepone un
+// prefijo, p.ej. "env:run-42:", a todas las claves del consumer.
+type Valkey struct {
+	Mode             string        `yaml:"mode" env:"VALKEY_MODE"`
+	Addr             string        `yaml:"addr" env:"VALKEY_ADDR"`
+	Addrs            []string      `yaml:"addrs" env:"VALKEY_ADDRS"`
+	MasterName       string        `yaml:"master_name" env:"VALKEY_MASTER_NAME"`
+	Username         string        `yaml:"username" env:"VALKEY_USERNAME"`
+	Password         string        `yaml:"password" env:"VALKEY_PASSWORD" secret:"true"`
+	PasswordFile     string        `yaml:"password_file" env:"VALKEY_PASSWORD_FILE"`
+	SentinelPassword string        `yaml:"sentinel_password" env:"VALKEY_SENTINEL_PASSWORD" secret:"true"`
+	DB               int           `yaml:"db" env:"VALKEY_DB"`
+	Namespace        string        `yaml:"namespace" env:"VALKEY_NAMESPACE"`
+	TLS              ValkeyTLS     `yaml:"tls"`
+	StreamMaxLen     int64         `yaml:"stream_max_len" env:"VALKEY_STREAM_MAXLEN" reload:"safe"`
+	ReadReplicas     []string      `yaml:"read_replicas" env:"VALKEY_READ_REPLICAS"`
+	MaxReplicaLag    time.Duration `yaml:"max_replica_lag" env:"VALKEY_MAX_REPLICA_LAG" reload:"safe"`
+	LagCheckInterval time.Duration `yaml:"lag_check_interval" env:"VALKEY_LAG_CHECK_INTERVAL"`
+}
+
+type ValkeyTLS struct {
+	Enabled            bool   `yaml:"enabled" env:"VALKEY_TLS_ENABLED"`
+	CA                 string `yaml:"ca" env:"VALKEY_TLS_CA"`
+	Cert               string `yaml:"cert" env:"VALKEY_TLS_CERT"`
+	Key                string `yaml:"key" env:"VALKEY_TLS_KEY"`
+	ServerName         string `yaml:"server_name" env:"VALKEY_TLS_SERVER_NAME"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" env:"VALKEY_TLS_INSECURE_SKIP_VERIFY"`
+}
+
+type Bridge struct {
+	Listen         string        `yaml:"listen" env:"BRIDGE_LISTEN"`
+	GRPCHost       string        `yaml:"grpc_host" env:"GRPC_HOST"`
+	RequestTimeout time.Duration `yaml:"request_timeout" env:"BRIDGE_REQUEST_TIMEOUT" reload:"safe"`
+	RateLimit      RateLimit     `yaml:"rate_limit"`
+	Shed           Shed          `yaml:"shed"`
+	Evento         Evento        `yaml:"evento"`
+}
+
+// Evento configura el sobre que el bridge agrega a cada venta. Source y
+// Tenant van en todas las ventas, sin importar lo que envie el cliente;
+// MaxSkew limita cuanto puede adelantarse event_time al reloj del bridge.
+type Evento struct {
+	Source  string        `yaml:"source" env:"EVENT_SOURCE"`
+	Tenant  string        `yaml:"tenant" env:"EVENT_TENANT" reload:"safe"`
+	MaxSkew time.Duration `yaml:"max_skew" env:"EVENT_MAX_SKEW" reload:"safe"`
+}
+
+// RateLimit usa el formato "rate:burst"; Categoria acepta ademas "id=rate:burst".
+type RateLimit struct {
+	Global       string        `yaml:"global" env:"RATE_LIMIT_GLOBAL" reload:"safe"`
+	Client       string        `yaml:"client" env:"RATE_LIMIT_CLIENT" reload:"safe"`
+	Categoria    string        `yaml:"categoria" env:"RATE_LIMIT_CATEGORIA" reload:"safe"`
+	SyncInterval time.Duration `yaml:"sync_interval" env:"RATE_LIMIT_SYNC_INTERVAL"`
+}
+
+type Shed struct {
+	MaxInflight int64         `yaml:"max_inflight" env:"SHED_MAX_INFLIGHT" reload:"safe"`
+	MaxLatency  time.Duration `yaml:"max_latency" env:"SHED_MAX_LATENCY" reload:"safe"`
+}
+
+// Writer.CloudEvents elige el formato de los registros en Kafka: "off" (JSON
+// plano), "binary" (headers ce_*) o "structured" (application/cloudevents+json).
+type Writer struct {
+	Listen      string `yaml:"listen" env:"WRITER_LISTEN"`
+	CloudEvents string `yaml:"cloudevents" env:"WRITER_CLOUDEVENTS" reload:"safe"`
+}
+
+// Consumer.AuditTTL activa el libro de auditoria que usa el comando
+// reconcile para encontrar registros faltantes o duplicados; 0 lo desactiva.
+// RefundWindow es cuanto se guarda cada venta para poder revertirla con un
+// reembolso o cancelacion; 0 (el default) desactiva los ajustes. Cada venta
+// ocupa unos 300 bytes de Valkey durante la ventana: a 1000 ventas/s, una
+// hora son alrededor de 1 GB. TrendingHalfLife es la
+// vida media del puntaje de tendencia: una venta pesa la mitad despues de ese
+// tiempo; 0 desactiva las tendencias.
+type Consumer struct {
+	StatsListen      string        `yaml:"stats_listen" env:"CONSUMER_STATS_LISTEN"`
+	AuditTTL         time.Duration `yaml:"audit_ttl" env:"CONSUMER_AUDIT_TTL" reload:"safe"`
+	RefundWindow     time.Duration `yaml:"refund_window" env:"CONSUMER_REFUND_WINDOW" reload:"safe"`
+	TrendingHalfLife time.Duration `yaml:"trending_half_life" env:"CONSUMER_TRENDING_HALF_LIFE" reload:"safe"`
+}
+
+// Retention asigna a cada familia de claves del consumer una politica con el
+// formato "ttl:<duracion>", "reset:<frontera>", "archive:<duracion>" o "none".
+// Interval en 0 desactiva el janitor.
+type Retention struct {
+	Interval      time.Duration     `yaml:"interval" env:"RETENTION_INTERVAL"`
+	DryRun        bool              `yaml:"dry_run" env:"RETENTION_DRY_RUN" reload:"safe"`
+	Timezone      string            `yaml:"timezone" env:"RETENTION_TIMEZONE" reload:"safe"`
+	ArchiveMaxLen int64             `yaml:"archive_max_len" env:"RETENTION_ARCHIVE_MAXLEN" reload:"safe"`
+	Policies      map[string]string `yaml:"policies" env:"RETENTION_POLICIES" reload:"safe"`
+}
+
+// Canary configura "go-consumer canary": cada Interval envia una venta
+// sintetica al bridge y espera verla confirmada en Valkey antes del SLO.
+type Canary struct {
+	Listen    string        `yaml:"listen" env:"CANARY_LISTEN"`
+	BridgeURL string        `yaml:"bridge_url" env:"CANARY_BRIDGE_URL"`
+	APIKey    string        `yaml:"api_key" env:"CANARY_API_KEY" secret:"true"`
+	Interval  time.Duration `yaml:"interval" env:"CANARY_INTERVAL" reload:"safe"`
+	SLO       time.Duration `yaml:"slo" env:"CANARY_SLO" reload:"safe"`
+	Categoria int32         `yaml:"categoria" env:"CANARY_CATEGORIA" reload:"safe"`
+}
+
+type Auth struct {
+	Mode              string   `yaml:"mode" env:"AUTH_MODE"`
+	APIKeysPath       string   `yaml:"api_keys_path" env:"AUTH_API_KEYS_PATH"`
+	JWKSPath          string   `yaml:"jwks_path" env:"AUTH_JWKS_PATH"`
+	JWTIssuer         string   `yaml:"jwt_issuer" env:"AUTH_JWT_ISSUER"`
+	JWTAudience       string   `yaml:"jwt_audience" env:"AUTH_JWT_AUDIENCE"`
+	IdentitySecret    string   `yaml:"identity_secret" env:"AUTH_IDENTITY_SECRET" secret:"true"`
+	RequireIdentity   bool     `yaml:"require_identity" env:"WRITER_REQUIRE_IDENTITY"`
+	AllowedIdentities []string `yaml:"allowed_identities" env:"WRITER_ALLOWED_IDENTITIES" reload:"safe"`
+	// AdminIdentities pueden usar /admin/* del bridge. Vacio con auth.mode
+	// none deja la API abierta, como el resto del bridge.
+	AdminIdentities []string `yaml:"admin_identities" env:"AUTH_ADMIN_IDENTITIES" reload:"safe"`
+}
+
+// CategoryRegistry configura el registro de categorias. Con source "config"
+// sale de Entries (o, si esta vacio, del mapa categorias) y se recarga con el
+// archivo; con "valkey" vive en Valkey, se siembra desde config la primera vez
+// y se administra con /admin/categorias del bridge. Unknown decide que pasa con
+// un id que no esta registrado: "reject" lo rechaza en el bridge y lo descarta
+// en el consumer, "other" lo acepta y lo agrega en "Otros".
+type CategoryRegistry struct {
+	Source  string          `yaml:"source" env:"CATEGORY_REGISTRY_SOURCE"`
+	Refresh time.Duration   `yaml:"refresh" env:"CATEGORY_REGISTRY_REFRESH"`
+	Unknown string          `yaml:"unknown" env:"CATEGORY_REGISTRY_UNKNOWN" reload:"safe"`
+	Entries []CategoryEntry `yaml:"entries" reload:"safe"`
+	// Artifact, si tiene ref, reemplaza a Entries con un JSON de categorias
+	// ([{id, slug, nombre, activa}]) publicado en el registry.
+	Artifact Artifact `yaml:"artifact"`
+}
+
+// CategoryEntry es una categoria del registro. El slug forma los nombres de
+// las claves en Valkey y no cambia; Active sin valor cuenta como true.
+type CategoryEntry struct {
+	ID     int32  `yaml:"id"`
+	Slug   string `yaml:"slug"`
+	Name   string `yaml:"name"`
+	Active *bool  `yaml:"active,omitempty"`
+}
+
+// Semilla devuelve las categorias configuradas. Las que vienen del mapa
+// categorias usan el nombre como slug, asi conservan las claves que ya
+// existen en Valkey.
+func (r CategoryRegistry) Semilla(mapa map[int32]string) []CategoryEntry {
+	if len(r.Entries) > 0 {
+		return r.Entries
+	}
+	var es []CategoryEntry
+	for id, nombre := range mapa {
+		es = append(es, CategoryEntry{ID: id, Slug: nombre, Name: nombre})
+	}
+	sort.Slice(es, func(i, j int) bool { return es[i].ID < es[j].ID })
+	return es
+}
+
+// Catalog configura el catalogo de productos que aplica el bridge. Sin Path no
+// se valida nada. Unknown decide que pasa con un producto que no esta en el
+// catalogo: "accept", "flag" (pasa marcado) o "reject". Mismatch decide que
+// pasa si la categoria del payload no es la del catalogo: "keep", "fix" (usa
+// la del catalogo) o "reject".
+type Catalog struct {
+	Path     string        `yaml:"path" env:"CATALOG_PATH"`
+	Refresh  time.Duration `yaml:"refresh" env:"CATALOG_REFRESH"`
+	Unknown  string        `yaml:"unknown" env:"CATALOG_UNKNOWN" reload:"safe"`
+	Mismatch string        `yaml:"mismatch" env:"CATALOG_MISMATCH" reload:"safe"`
+	// Artifact, si tiene ref, descarga el catalogo del registry; Path queda
+	// vacio.
+	Artifact Artifact `yaml:"artifact"`
+}
+
+// Inventory configura el control de stock del bridge. El writer publica los
+// eventos de agotado, stock bajo y reabastecido en Topic. Untracked decide que
+// pasa con un producto sin inventario cargado: "allow" o "reject". LowStock es
+// el umbral de stock bajo para los productos que no tienen uno propio.
+type Inventory struct {
+	Enabled   bool   `yaml:"enabled" env:"INVENTORY_ENABLED"`
+	Topic     string `yaml:"topic" env:"INVENTORY_TOPIC"`
+	Untracked string `yaml:"untracked" env:"INVENTORY_UNTRACKED" reload:"safe"`
+	LowStock  int64  `yaml:"low_stock" env:"INVENTORY_LOW_STOCK" reload:"safe"`
+}
+
+// Anomalies configura el detector de anomalias del consumer. Cada serie
+// (precio de cada producto, unidades por Interval de cada producto y de cada
+// categoria) lleva una media y una varianza EWMA con factor Alpha; una
+// observacion es anomala si se aleja mas de Sensitivity desviaciones, una vez
+// que la serie tiene MinSamples observaciones. Una caida de ventas solo se
+// avisa si la media es al menos MinRate unidades por intervalo. Cada anomalia
+// se publica en Topic y en el stream alertas de Valkey con las ultimas Window
+// observaciones; la misma anomalia no se repite antes de Cooldown.
+type Anomalies struct {
+	Enabled     bool          `yaml:"enabled" env:"ANOMALIES_ENABLED"`
+	Topic       string        `yaml:"topic" env:"ANOMALIES_TOPIC"`
+	Interval    time.Duration `yaml:"interval" env:"ANOMALIES_INTERVAL"`
+	Alpha       float64       `yaml:"alpha" env:"ANOMALIES_ALPHA" reload:"safe"`
+	Sensitivity float64       `yaml:"sensitivity" env:"ANOMALIES_SENSITIVITY" reload:"safe"`
+	MinSamples  int           `yaml:"min_samples" env:"ANOMALIES_MIN_SAMPLES" reload:"safe"`
+	MinRate     float64       `yaml:"min_rate" env:"ANOMALIES_MIN_RATE" reload:"safe"`
+	Window      int           `yaml:"window" env:"ANOMALIES_WINDOW" reload:"safe"`
+	Cooldown    time.Duration `yaml:"cooldown" env:"ANOMALIES_COOLDOWN" reload:"safe"`
+}
+
+// Alerting configura las reglas de umbral que el consumer evalua sobre sus
+// agregados cada Interval. Una regla dispara cuando la condicion se cumple
+// durante For y se resuelve cuando deja de cumplirse; ambos cambios se avisan
+// a los webhooks, agrupados por el Group de cada regla. Una regla no vuelve a
+// disparar antes de Cooldown (o del cooldown propio de la regla). Cada envio
+// tiene Timeout y hasta Retries reintentos.
+type Alerting struct {
+	Enabled  bool          `yaml:"enabled" env:"ALERTING_ENABLED"`
+	Interval time.Duration `yaml:"interval" env:"ALERTING_INTERVAL"`
+	Cooldown time.Duration `yaml:"cooldown" env:"ALERTING_COOLDOWN" reload:"safe"`
+	Timeout  time.Duration `yaml:"timeout" env:"ALERTING_TIMEOUT" reload:"safe"`
+	Retries  int           `yaml:"retries" env:"ALERTING_RETRIES" reload:"safe"`
+	Webhooks []Webhook     `yaml:"webhooks" reload:"safe"`
+	Rules    []AlertRule   `yaml:"rules" reload:"safe"`
+}
+
+type Webhook struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+}
+
+// AlertRule compara Metric (de Category si la metrica es por categoria) con
+// Threshold. Sin Webhooks se avisa a todos; sin Group se agrupa por Severity.
+type AlertRule struct {
+	Name       string        `yaml:"name"`
+	Metric     string        `yaml:"metric"`
+	Category   string        `yaml:"category,omitempty"`
+	Comparator string        `yaml:"comparator"`
+	Threshold  float64       `yaml:"threshold"`
+	For        time.Duration `yaml:"for,omitempty"`
+	Severity   string        `yaml:"severity,omitempty"`
+	Group      string        `yaml:"group,omitempty"`
+	Cooldown   time.Duration `yaml:"cooldown,omitempty"`
+	Webhooks   []string      `yaml:"webhooks,omitempty"`
+}
+
+// MetricasAlerta son las metricas que aceptan las reglas y su ambito:
+// "categoria" requiere category, "global" no la admite y "ambos" la acepta
+// opcionalmente (sin ella es el total).
+var MetricasAlerta = map[string]string{
+	"contador":            "categoria",
+	"suma_cantidad":       "categoria",
+	"suma_precio":         "categoria",
+	"promedio_productos":  "categoria",
+	"promedio_precio_tag": "categoria",
+	"unidades_por_minuto": "categoria",
+	"ventas_por_minuto":   "ambos",
+	"total_ventas":        "global",
+	"precio_max_global":   "global",
+	"precio_min_global":   "global",
+}
+
+// Fraud configura el puntaje de riesgo que el consumer calcula para cada venta
+// antes de sumarla. Cada heuristica aporta hasta su Weight segun cuanto se
+// pasa de su limite: la mitad al pasarlo y todo al doblarlo. Con Threshold
+// puntos o mas (de 100) la venta queda en cuarentena: va a ReviewTopic y no
+// cuenta en los agregados hasta que se libere con "go-consumer fraude
+// liberar". Un Weight de 0 desactiva la heuristica.
+//
+// Al decidirse, la venta sale de la cuarentena y la decision queda en un
+// stream de DecisionsMaxLen entradas, sin el registro original. La
+// particion y el offset de cada venta retenida se guardan RecordRetention
+// para que reconcile y replay no la cuenten; debe cubrir la retencion del
+// topic de ventas.
+type Fraud struct {
+	Enabled         bool            `yaml:"enabled" env:"FRAUD_ENABLED"`
+	ReviewTopic     string          `yaml:"review_topic" env:"FRAUD_REVIEW_TOPIC"`
+	Threshold       float64         `yaml:"threshold" env:"FRAUD_THRESHOLD" reload:"safe"`
+	Velocity        FraudVelocity   `yaml:"velocity"`
+	Quantity        FraudQuantity   `yaml:"quantity"`
+	Price           FraudPrice      `yaml:"price"`
+	Duplicates      FraudDuplicates `yaml:"duplicates"`
+	DecisionsMaxLen int64           `yaml:"decisions_max_len" reload:"safe"`
+	RecordRetention time.Duration   `yaml:"record_retention" reload:"safe"`
+}
+
+// FraudVelocity limita las ventas de un cliente en Window, en total y por
+// producto. El cliente es la identidad autenticada; las ventas sin identidad
+// no se evaluan aqui.
+type FraudVelocity struct {
+	Window        time.Duration `yaml:"window" reload:"safe"`
+	MaxPerClient  int64         `yaml:"max_per_client" reload:"safe"`
+	MaxPerProduct int64         `yaml:"max_per_product" reload:"safe"`
+	Weight        float64       `yaml:"weight" reload:"safe"`
+}
+
+// FraudQuantity marca cantidades por encima de Factor veces el promedio de
+// unidades por venta de la categoria, con un limite minimo de Min.
+type FraudQuantity struct {
+	Factor float64 `yaml:"factor" reload:"safe"`
+	Min    int32   `yaml:"min" reload:"safe"`
+	Weight float64 `yaml:"weight" reload:"safe"`
+}
+
+// FraudPrice marca precios por debajo de Floor veces el precio de lista del
+// catalogo. Se compara el precio que envio el cliente, no el de la campana.
+type FraudPrice struct {
+	Floor  float64 `yaml:"floor" reload:"safe"`
+	Weight float64 `yaml:"weight" reload:"safe"`
+}
+
+// FraudDuplicates marca un payload identico (cliente, producto, categoria,
+// precio y cantidad) que se repite mas de Max veces en Window.
+type FraudDuplicates struct {
+	Window time.Duration `yaml:"window" reload:"safe"`
+	Max    int64         `yaml:"max" reload:"safe"`
+	Weight float64       `yaml:"weight" reload:"safe"`
+}
+
+// Campaign es una campana de Black Friday que el bridge aplica entre Start y
+// End a los productos de Products o a las categorias de Categories (sin
+// ninguno de los dos, a todo). El precio efectivo es Price si es mayor que 0 o
+// el precio de la venta con DiscountPct de descuento. MaxPerOrder limita las
+// unidades por venta (doorbusters). Con Exclusive los productos de la campana
+// solo se venden dentro de la ventana. Si varias campanas aplican gana la
+// primera de la lista.
+type Campaign struct {
+	ID          string      `yaml:"id"`
+	Name        string      `yaml:"name"`
+	Start       time.Time   `yaml:"start"`
+	End         time.Time   `yaml:"end"`
+	Products    []string    `yaml:"products,omitempty"`
+	Categories  []int32     `yaml:"categories,omitempty"`
+	DiscountPct float64     `yaml:"discount_pct,omitempty"`
+	Price       float64     `yaml:"price,omitempty"`
+	MaxPerOrder int32       `yaml:"max_per_order,omitempty"`
+	Exclusive   bool        `yaml:"exclusive,omitempty"`
+	EarlyAccess EarlyAccess `yaml:"early_access,omitempty"`
+}
+
+// EarlyAccess abre la campana desde Start solo para las identidades de
+// Identities (ver auth).
+type EarlyAccess struct {
+	Start      time.Time `yaml:"start,omitempty"`
+	Identities []string  `yaml:"identities,omitempty"`
+}
+
+// Artifact es un archivo publicado como artefacto OCI, p.ej. con
+//
+//	oras push 172.31.32.68:5000/black-friday/catalogo:v1 productos.csv
+//
+// Ref es "registry/repo:tag" o "registry/repo@sha256:..."; Digest fija el
+// manifiesto esperado. File elige la capa por su titulo y da el nombre en
+// CacheDir. Con Interval > 0 y sin digest fijo se revisa si el tag cambio.
+type Artifact struct {
+	Ref          string        `yaml:"ref"`
+	Digest       string        `yaml:"digest"`
+	File         string        `yaml:"file"`
+	CacheDir     string        `yaml:"cache_dir"`
+	Username     string        `yaml:"username"`
+	Password     string        `yaml:"password" secret:"true"`
+	PasswordFile string        `yaml:"password_file"`
+	Insecure     bool          `yaml:"insecure"`
+	Interval     time.Duration `yaml:"interval"`
+}
+
+type TLS struct {
+	Mode        string   `yaml:"mode" env:"GRPC_TLS_MODE"`
+	Cert        string   `yaml:"cert" env:"GRPC_TLS_CERT"`
+	Key         string   `yaml:"key" env:"GRPC_TLS_KEY"`
+	CA          string   `yaml:"ca" env:"GRPC_TLS_CA"`
+	ServerName  string   `yaml:"server_name" env:"GRPC_TLS_SERVER_NAME"`
+	AllowedSANs []string `yaml:"allowed_sans" env:"GRPC_TLS_ALLOWED_SANS"`
+	DevDir      string   `yaml:"dev_dir" env:"GRPC_TLS_DEV_DIR"`
+}
+
+func Defaults() *Config {
+	return &Config{
+		Kafka: Kafka{
+			Brokers: []string{"localhost:9092"},
+			Topic:   "sales-topic",
+			Group:   "black-friday-group",
+		},
+		Valkey: Valkey{
+			Mode:             "standalone",
+			Addr:             "localhost:6379",
+			StreamMaxLen:     1000,
+			MaxReplicaLag:    2 * time.Second,
+			LagCheckInterval: time.Second,
+		},
+		Bridge: Bridge{
+			Listen:         ":8080",
+			GRPCHost:       "localhost:50051",
+			RequestTimeout: time.Second,
+			RateLimit:      RateLimit{SyncInterval: 10 * time.Second},
+			Evento:         Evento{Source: "go-bridge", Tenant: "default", MaxSkew: 5 * time.Minute},
+		},
+		Writer:   Writer{Listen: ":50051", CloudEvents: "off"},
+		Consumer: Consumer{StatsListen: ":8090", TrendingHalfLife: time.Hour},
+		Auth:     Auth{Mode: "none"},
+		GRPCTLS:  TLS{Mode: "off"},
+		Retention: Retention{
+			Interval:      time.Minute,
+			Timezone:      "UTC",
+			ArchiveMaxLen: 10000,
+		},
+		Canary: Canary{
+			Listen:    ":8091",
+			BridgeURL: "http://localhost:8080/forward",
+			Interval:  10 * time.Second,
+			SLO:       5 * time.Second,
+			Categoria: 1,
+		},
+		Registry: CategoryRegistry{
+			Source:   "config",
+			Refresh:  5 * time.Second,
+			Unknown:  "reject",
+			Artifact: Artifact{CacheDir: "/tmp/black-friday/oci/categorias", Interval: 5 * time.Minute},
+		},
+		Catalog: Catalog{
+			Refresh:  30 * time.Second,
+			Unknown:  "flag",
+			Mismatch: "fix",
+			Artifact: Artifact{CacheDir: "/tmp/black-friday/oci/catalogo", Interval: 5 * time.Minute},
+		},
+		Inventory: Inventory{
+			Topic:     "inventory-topic",
+			Untracked: "allow",
+			LowStock:  10,
+		},
+		Anomalies: Anomalies{
+			Topic:       "alerts",
+			Interval:    time.Minute,
+			Alpha:       0.1,
+			Sensitivity: 3,
+			MinSamples:  20,
+			MinRate:     1,
+			Window:      30,
+			Cooldown:    5 * time.Minute,
+		},
+		Alerting: Alerting{
+			Interval: 15 * time.Second,
+			Cooldown: 10 * time.Minute,
+			Timeout:  5 * time.Second,
+			Retries:  3,
+		},
+		Fraud: Fraud{
+			ReviewTopic: "sales-review",
+			Threshold:   70,
+			Velocity:    FraudVelocity{Window: time.Minute, MaxPerClient: 120, MaxPerProduct: 30, Weight: 80},
+			Quantity:    FraudQuantity{Factor: 10, Min: 20, Weight: 50},
+			Price:       FraudPrice{Floor: 0.5, Weight: 60},
+			Duplicates:  FraudDuplicates{Window: 30 * time.Second, Max: 2, Weight: 80},
+
+			DecisionsMaxLen: 10000,
+			RecordRetention: 7 * 24 * time.Hour,
+		},
+		Categorias: map[int32]string{
+			1: "Electronica", 2: "Ropa", 3: "Hogar", 4: "Belleza",
+		},
+	}
+}
+
+// slugValido es el mismo formato que exige el paquete categorias.
+var slugValido = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// Validate devuelve todos los problemas encontrados, uno por linea.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(campo, format string, a ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", campo, fmt.Sprintf(format, a...)))
+	}
+	if len(c.Kafka.Brokers) == 0 {
+		fail("kafka.brokers", "se requiere al menos un broker")
+	}
+	if c.Kafka.Topic == "" {
+		fail("kafka.topic", "vacio")
+	}
+	if c.Kafka.Group == "" {
+		fail("kafka.group", "vacio")
+	}
+	switch c.Kafka.SASL.Mechanism {
+	case "", "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+	default:
+		fail("kafka.sasl.mechanism", "mecanismo desconocido %q (PLAIN, SCRAM-SHA-256, SCRAM-SHA-512)", c.Kafka.SASL.Mechanism)
+	}
+	if c.Kafka.SASL.Mechanism != "" {
+		if c.Kafka.SASL.Username == "" && c.Kafka.SASL.UsernameFile == "" {
+			fail("kafka.sasl.username", "requerido con kafka.sasl.mechanism=%s", c.Kafka.SASL.Mechanism)
+		}
+		if c.Kafka.SASL.Password == "" && c.Kafka.SASL.PasswordFile == "" {
+			fail("kafka.sasl.password", "requerido con kafka.sasl.mechanism=%s", c.Kafka.SASL.Mechanism)
+		}
+	}
+	if (c.Kafka.TLS.Cert == "") != (c.Kafka.TLS.Key == "") {
+		fail("kafka.tls", "cert y key deben definirse juntos")
+	}
+	switch c.Valkey.Mode {
+	case "", "standalone":
+		if c.Valkey.Addr == "" {
+			fail("valkey.addr", "vacio")
+		}
+	case "sentinel":
+		if len(c.Valkey.Addrs) == 0 {
+			fail("valkey.addrs", "se requieren las direcciones de los sentinels")
+		}
+		if c.Valkey.MasterName == "" {
+			fail("valkey.master_name", "requerido con valkey.mode=sentinel")
+		}
+	case "cluster":
+		if len(c.Valkey.Addrs) == 0 && c.Valkey.Addr == "" {
+			fail("valkey.addrs", "se requiere al menos un nodo semilla")
+		}
+		if c.Valkey.DB != 0 {
+			fail("valkey.db", "cluster solo admite la base 0")
+		}
+	default:
+		fail("valkey.mode", "modo desconocido %q (standalone, sentinel, cluster)", c.Valkey.Mode)
+	}
+	if c.Valkey.Mode == "cluster" && len(c.Valkey.ReadReplicas) > 0 {
+		fail("valkey.read_replicas", "no aplica en modo cluster")
+	}
+	if strings.ContainsAny(c.Valkey.Namespace, "*?[]{} ") {
+		fail("valkey.namespace", "no puede contener espacios, comodines ni llaves: %q", c.Valkey.Namespace)
+	}
+	if c.Valkey.MaxReplicaLag < 0 {
+		fail("valkey.max_replica_lag", "debe ser >= 0")
+	}
+	if c.Valkey.LagCheckInterval <= 0 {
+		fail("valkey.lag_check_interval", "debe ser mayor que 0")
+	}
+	if (c.Valkey.TLS.Cert == "") != (c.Valkey.TLS.Key == "") {
+		fail("valkey.tls", "cert y key deben definirse juntos")
+	}
+	if c.Valkey.StreamMaxLen < 0 {
+		fail("valkey.stream_max_len", "debe ser >= 0, es %d", c.Valkey.StreamMaxLen)
+	}
+	if c.Bridge.RequestTimeout <= 0 {
+		fail("bridge.request_timeout", "debe ser mayor que 0")
+	}
+	if c.Bridge.RateLimit.SyncInterval <= 0 {
+		fail("bridge.rate_limit.sync_interval", "debe ser mayor que 0")
+	}
+	if c.Bridge.Shed.MaxInflight < 0 {
+		fail("bridge.shed.max_inflight", "debe ser >= 0")
+	}
+	if c.Bridge.Shed.MaxLatency < 0 {
+		fail("bridge.shed.max_latency", "debe ser >= 0")
+	}
+	if c.Bridge.Evento.Source == "" {
+		fail("bridge.evento.source", "no puede estar vacio")
+	}
+	if c.Bridge.Evento.MaxSkew < 0 {
+		fail("bridge.evento.max_skew", "debe ser >= 0")
+	}
+	switch c.Writer.CloudEvents {
+	case "off", "binary", "structured":
+	default:
+		fail("writer.cloudevents", "debe ser off, binary o structured")
+	}
+	for _, m := range strings.Split(c.Auth.Mode, ",") {
+		switch strings.TrimSpace(m) {
+		case "", "none", "apikey", "jwt":
+		default:
+			fail("auth.mode", "modo desconocido %q (none, apikey, jwt)", m)
+		}
+	}
+	if strings.Contains(c.Auth.Mode, "apikey") && c.Auth.APIKeysPath == "" {
+		fail("auth.api_keys_path", "requerido con auth.mode=apikey")
+	}
+	if strings.Contains(c.Auth.Mode, "jwt") && c.Auth.JWKSPath == "" {
+		fail("auth.jwks_path", "requerido con auth.mode=jwt")
+	}
+	// Sin secreto el writer no puede comprobar la firma y cualquier cliente
+	// gRPC podria declarar la identidad que quiera.
+	if c.Auth.IdentitySecret == "" {
+		if c.Auth.RequireIdentity {
+			fail("auth.identity_secret", "requerido con auth.require_identity")
+		}
+		if len(c.Auth.AllowedIdentities) > 0 {
+			fail("auth.identity_secret", "requerido con auth.allowed_identities")
+		}
+	}
+	switch c.GRPCTLS.Mode {
+	case "", "off", "tls", "mtls", "dev":
+	default:
+		fail("grpc_tls.mode", "modo desconocido %q (off, tls, mtls, dev)", c.GRPCTLS.Mode)
+	}
+	if c.Consumer.AuditTTL < 0 {
+		fail("consumer.audit_ttl", "debe ser >= 0")
+	}
+	if c.Consumer.RefundWindow < 0 {
+		fail("consumer.refund_window", "debe ser >= 0")
+	}
+	if c.Consumer.TrendingHalfLife < 0 {
+		fail("consumer.trending_half_life", "debe ser >= 0")
+	}
+	if c.Retention.Interval < 0 {
+		fail("retention.interval", "debe ser >= 0")
+	}
+	if c.Retention.ArchiveMaxLen < 0 {
+		fail("retention.archive_max_len", "debe ser >= 0")
+	}
+	if c.Canary.Interval <= 0 {
+		fail("canary.interval", "debe ser mayor que 0")
+	}
+	if c.Canary.SLO <= 0 {
+		fail("canary.slo", "debe ser mayor que 0")
+	}
+	switch c.Registry.Source {
+	case "config", "valkey":
+	default:
+		fail("category_registry.source", "debe ser config o valkey")
+	}
+	if c.Registry.Refresh <= 0 {
+		fail("category_registry.refresh", "debe ser mayor que 0")
+	}
+	switch c.Registry.Unknown {
+	case "reject", "other":
+	default:
+		fail("category_registry.unknown", "debe ser reject u other")
+	}
+	if c.Catalog.Refresh <= 0 {
+		fail("catalog.refresh", "debe ser mayor que 0")
+	}
+	if c.Catalog.Path != "" && c.Catalog.Artifact.Ref != "" {
+		fail("catalog", "path y artifact.ref son excluyentes")
+	}
+	for _, art := range []struct {
+		campo string
+		a     Artifact
+	}{{"catalog.artifact", c.Catalog.Artifact}, {"category_registry.artifact", c.Registry.Artifact}} {
+		campo, a := art.campo, art.a
+		if a.Ref == "" {
+			continue
+		}
+		if a.CacheDir == "" {
+			fail(campo+".cache_dir", "requerido con ref")
+		}
+		if a.Interval < 0 {
+			fail(campo+".interval", "debe ser >= 0")
+		}
+		if a.Digest != "" && !strings.HasPrefix(a.Digest, "sha256:") {
+			fail(campo+".digest", "se espera sha256:<hex>")
+		}
+	}
+	switch c.Catalog.Unknown {
+	case "accept", "flag", "reject":
+	default:
+		fail("catalog.unknown", "debe ser accept, flag o reject")
+	}
+	switch c.Catalog.Mismatch {
+	case "keep", "fix", "reject":
+	default:
+		fail("catalog.mismatch", "debe ser keep, fix o reject")
+	}
+	if c.Inventory.Topic == "" {
+		fail("inventory.topic", "no puede estar vacio")
+	}
+	switch c.Inventory.Untracked {
+	case "allow", "reject":
+	default:
+		fail("inventory.untracked", "debe ser allow o reject")
+	}
+	if c.Inventory.LowStock < 0 {
+		fail("inventory.low_stock", "debe ser >= 0")
+	}
+	if c.Anomalies.Topic == "" {
+		fail("anomalies.topic", "no puede estar vacio")
+	}
+	if c.Anomalies.Interval <= 0 {
+		fail("anomalies.interval", "debe ser mayor que 0")
+	}
+	if c.Anomalies.Alpha <= 0 || c.Anomalies.Alpha >= 1 {
+		fail("anomalies.alpha", "debe estar entre 0 y 1")
+	}
+	if c.Anomalies.Sensitivity <= 0 {
+		fail("anomalies.sensitivity", "debe ser mayor que 0")
+	}
+	if c.Anomalies.MinSamples < 1 {
+		fail("anomalies.min_samples", "debe ser al menos 1")
+	}
+	if c.Anomalies.MinRate < 0 {
+		fail("anomalies.min_rate", "debe ser >= 0")
+	}
+	if c.Anomalies.Window < 1 {
+		fail("anomalies.window", "debe ser al menos 1")
+	}
+	if c.Anomalies.Cooldown < 0 {
+		fail("anomalies.cooldown", "debe ser >= 0")
+	}
+	if c.Alerting.Interval <= 0 {
+		fail("alerting.interval", "debe ser mayor que 0")
+	}
+	if c.Alerting.Cooldown < 0 {
+		fail("alerting.cooldown", "debe ser >= 0")
+	}
+	if c.Alerting.Timeout <= 0 {
+		fail("alerting.timeout", "debe ser mayor que 0")
+	}
+	if c.Alerting.Retries < 0 {
+		fail("alerting.retries", "debe ser >= 0")
+	}
+	webhooks := map[string]bool{}
+	for i, wh := range c.Alerting.Webhooks {
+		campo := fmt.Sprintf("alerting.webhooks[%d]", i)
+		switch {
+		case !slugValido.MatchString(wh.Name):
+			fail(campo+".name", "%q invalido", wh.Name)
+		case webhooks[wh.Name]:
+			fail(campo+".name", "%q repetido", wh.Name)
+		}
+		webhooks[wh.Name] = true
+		if u, err := url.Parse(wh.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail(campo+".url", "%q no es una URL http(s)", wh.URL)
+		}
+	}
+	if len(c.Alerting.Rules) > 0 && len(c.Alerting.Webhooks) == 0 {
+		fail("alerting.webhooks", "las reglas requieren al menos un webhook")
+	}
+	reglas := map[string]bool{}
+	for i, r := range c.Alerting.Rules {
+		campo := fmt.Sprintf("alerting.rules[%d]", i)
+		switch {
+		case !slugValido.MatchString(r.Name):
+			fail(campo+".name", "%q invalido", r.Name)
+		case reglas[r.Name]:
+			fail(campo+".name", "%q repetido", r.Name)
+		}
+		reglas[r.Name] = true
+		ambito, ok := MetricasAlerta[r.Metric]
+		switch {
+		case !ok:
+			fail(campo+".metric", "%q desconocida", r.Metric)
+		case ambito == "categoria" && r.Category == "":
+			fail(campo+".category", "%s es por categoria y requiere category", r.Metric)
+		case ambito == "global" && r.Category != "":
+			fail(campo+".category", "%s es global y no admite category", r.Metric)
+		}
+		switch r.Comparator {
+		case ">", ">=", "<", "<=", "==", "!=":
+		default:
+			fail(campo+".comparator", "debe ser >, >=, <, <=, == o !=")
+		}
+		if r.For < 0 || r.Cooldown < 0 {
+			fail(campo, "for y cooldown deben ser >= 0")
+		}
+		switch r.Severity {
+		case "", "info", "warning", "critical":
+		default:
+			fail(campo+".severity", "debe ser info, warning o critical")
+		}
+		for _, wh := range r.Webhooks {
+			if !webhooks[wh] {
+				fail(campo+".webhooks", "webhook %q no definido", wh)
+			}
+		}
+	}
+	f := c.Fraud
+	if f.ReviewTopic == "" {
+		fail("fraud.review_topic", "no puede estar vacio")
+	}
+	if f.Threshold <= 0 || f.Threshold > 100 {
+		fail("fraud.threshold", "debe estar entre 0 y 100")
+	}
+	for _, p := range []struct {
+		campo string
+		peso  float64
+	}{
+		{"velocity", f.Velocity.Weight}, {"quantity", f.Quantity.Weight},
+		{"price", f.Price.Weight}, {"duplicates", f.Duplicates.Weight},
+	} {
+		if p.peso < 0 || p.peso > 100 {
+			fail("fraud."+p.campo+".weight", "debe estar entre 0 y 100")
+		}
+	}
+	if f.Velocity.Window <= 0 || f.Velocity.MaxPerClient < 1 || f.Velocity.MaxPerProduct < 1 {
+		fail("fraud.velocity", "window debe ser mayor que 0 y los maximos al menos 1")
+	}
+	if f.Quantity.Factor <= 0 || f.Quantity.Min < 1 {
+		fail("fraud.quantity", "factor debe ser mayor que 0 y min al menos 1")
+	}
+	if f.Price.Floor <= 0 || f.Price.Floor > 1 {
+		fail("fraud.price.floor", "debe estar entre 0 y 1")
+	}
+	if f.Duplicates.Window <= 0 || f.Duplicates.Max < 1 {
+		fail("fraud.duplicates", "window debe ser mayor que 0 y max al menos 1")
+	}
+	if f.DecisionsMaxLen < 1 {
+		fail("fraud.decisions_max_len", "debe ser al menos 1")
+	}
+	if f.RecordRetention <= 0 {
+		fail("fraud.record_retention", "debe ser mayor que 0")
+	}
+	campanas := map[string]bool{}
+	for i, cp := range c.Campaigns {
+		campo := fmt.Sprintf("campaigns[%d]", i)
+		switch {
+		case !slugValido.MatchString(cp.ID):
+			fail(campo+".id", "%q invalido", cp.ID)
+		case campanas[cp.ID]:
+			fail(campo+".id", "%q repetido", cp.ID)
+		}
+		campanas[cp.ID] = true
+		if cp.Start.IsZero() || cp.End.IsZero() || !cp.End.After(cp.Start) {
+			fail(campo, "se requieren start y end, con end posterior a start")
+		}
+		if cp.DiscountPct < 0 || cp.DiscountPct >= 100 {
+			fail(campo+".discount_pct", "debe estar entre 0 y 100")
+		}
+		if cp.Price < 0 {
+			fail(campo+".price", "debe ser >= 0")
+		}
+		if cp.MaxPerOrder < 0 {
+			fail(campo+".max_per_order", "debe ser >= 0")
+		}
+		if ea := cp.EarlyAccess; !ea.Start.IsZero() {
+			if !ea.Start.Before(cp.Start) {
+				fail(campo+".early_access.start", "debe ser anterior a start")
+			}
+			if len(ea.Identities) == 0 {
+				fail(campo+".early_access.identities", "se requiere al menos una identidad")
+			}
+		}
+	}
+	ids, slugs := map[int32]bool{}, map[string]bool{}
+	for _, e := range c.Registry.Entries {
+		switch {
+		case e.ID <= 0:
+			fail("category_registry.entries", "id %d: debe ser mayor que 0", e.ID)
+		case ids[e.ID]:
+			fail("category_registry.entries", "id %d repetido", e.ID)
+		case !slugValido.MatchString(e.Slug):
+			fail("category_registry.entries", "id %d: slug %q invalido", e.ID, e.Slug)
+		case slugs[e.Slug]:
+			fail("category_registry.entries", "slug %q repetido", e.Slug)
+		case strings.TrimSpace(e.Name) == "":
+			fail("category_registry.entries", "id %d: falta name", e.ID)
+		}
+		ids[e.ID], slugs[e.Slug] = true, true
+	}
+	if len(c.Categorias) == 0 && len(c.Registry.Entries) == 0 {
+		fail("categorias", "se requiere al menos una categoria")
+	}
+	for id, nombre := range c.Categorias {
+		if strings.TrimSpace(nombre) == "" {
+			fail("categorias", "la categoria %d no tiene nombre", id)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("configuracion invalida:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+type setFlags []string
+
+func (s *setFlags) String() string     { return strings.Join(*s, ",") }
+func (s *setFlags) Set(v string) error { *s = append(*s, v); return nil }
+
+// Options son los argumentos de linea de comandos que afectan la carga.
+type Options struct {
+	Path        string
+	PathGiven   bool
+	Sets        []string
+	PrintConfig bool
+	Args        []string
+}
+
+// ParseFlags registra -config, -set y -print-config en fs y parsea args. Los
+// argumentos posicionales que sobran quedan en Options.Args.
+func ParseFlags(fs *flag.FlagSet, args []string) (Options, error) {
+	var o Options
+	var sets setFlags
+	path := os.Getenv("CONFIG_FILE")
+	fs.StringVar(&o.Path, "config", path, "archivo YAML de configuracion")
+	fs.Var(&sets, "set", "sobrescribe un valor, p.ej. -set kafka.topic=otro (repetible)")
+	fs.BoolVar(&o.PrintConfig, "print-config", false, "imprime la configuracion efectiva y termina")
+	if err := fs.Parse(args); err != nil {
+		return o, err
+	}
+	o.Sets = sets
+	o.Args = fs.Args()
+	o.PathGiven = o.Path != ""
+	if o.Path == "" {
+		o.Path = DefaultPath
+	}
+	return o, nil
+}
+
+// Build aplica defaults, archivo, entorno y -set, y valida el resultado.
+func Build(o Options) (*Config, error) {
+	cfg, err := construir(o)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// construir arma la configuracion sin validarla; Reload valida despues de
+// restaurar los campos que requieren reinicio.
+func construir(o Options) (*Config, error) {
+	cfg := Defaults()
+	b, err := os.ReadFile(o.Path)
+	switch {
+	case err == nil:
+		// Un mapa en el archivo reemplaza al de los defaults en lugar de
+		// mezclarse con el.
+		var top map[string]any
+		if err := yaml.Unmarshal(b, &top); err != nil {
+			return nil, fmt.Errorf("%s: %w", o.Path, err)
+		}
+		if _, ok := top["categorias"]; ok {
+			cfg.Categorias = nil
+		}
+		dec := yaml.NewDecoder(strings.NewReader(string(b)))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", o.Path, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && !o.PathGiven:
+	default:
+		return nil, fmt.Errorf("leyendo configuracion: %w", err)
+	}
+	if err := applyEnv(reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+	for _, s := range o.Sets {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("-set %q: se espera clave=valor", s)
+		}
+		if err := setPath(reflect.ValueOf(cfg).Elem(), strings.Split(k, "."), v); err != nil {
+			return nil, fmt.Errorf("-set %s: %w", k, err)
+		}
+	}
+	return cfg, nil
+}
+
+// MustLoad parsea los flags del proceso y carga la configuracion. Termina el
+// proceso con un error claro si es invalida, o despues de imprimirla si se
+// paso -print-config.
+func MustLoad(servicio string) *Loader {
+	fs := flag.NewFlagSet(servicio, flag.ExitOnError)
+	o, err := ParseFlags(fs, os.Args[1:])
+	if err != nil {
+		log.Fatalf("Fatal config: %v", err)
+	}
+	cfg, err := Build(o)
+	if err != nil {
+		log.Fatalf("Fatal config: %v", err)
+	}
+	if o.PrintConfig {
+		if err := Print(os.Stdout, cfg); err != nil {
+			log.Fatalf("Fatal config: %v", err)
+		}
+		os.Exit(0)
+	}
+	l := &Loader{opts: o}
+	l.cur.Store(cfg)
+	return l
+}
+
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if f.Type.Kind() == reflect.Struct && f.Type != durationType {
+			if err := applyEnv(fv); err != nil {
+				return err
+			}
+			continue
+		}
+		name := f.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		if raw, ok := os.LookupEnv(name); ok && raw != "" {
+			if err := setString(fv, raw); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func setPath(v reflect.Value, path []string, raw string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if yamlName(t.Field(i)) != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return setString(v.Field(i), raw)
+		}
+		if v.Field(i).Kind() != reflect.Struct {
+			return fmt.Errorf("%s no es una seccion", path[0])
+		}
+		return setPath(v.Field(i), path[1:], raw)
+	}
+	return fmt.Errorf("clave desconocida %q", path[0])
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setString(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(n) {
+			return fmt.Errorf("%d fuera de rango para %s", n, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		var items []string
+		for _, p := range strings.Split(raw, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				items = append(items, p)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		// Formato "1=Electronica,2=Ropa" o "contador=ttl:24h,...".
+		m := reflect.MakeMap(v.Type())
+		for _, p := range strings.Split(raw, ",") {
+			k, val, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if !ok {
+				return fmt.Errorf("%q: se espera id=valor", p)
+			}
+			k = strings.TrimSpace(k)
+			key := reflect.ValueOf(k)
+			if v.Type().Key().Kind() != reflect.String {
+				n, err := strconv.ParseInt(k, 10, 32)
+				if err != nil {
+					return fmt.Errorf("%q: id invalido", k)
+				}
+				key = reflect.ValueOf(n).Convert(v.Type().Key())
+			}
+			m.SetMapIndex(key, reflect.ValueOf(strings.TrimSpace(val)))
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("tipo no soportado %s", v.Type())
+	}
+	return nil
+}
+
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+// Print escribe la configuracion efectiva en YAML con los secretos ocultos.
+func Print(w io.Writer, cfg *Config) error {
+	node, err := toNode(reflect.ValueOf(cfg).Elem(), false)
+	if err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func toNode(v reflect.Value, secret bool) (*yaml.Node, error) {
+	if secret {
+		s := ""
+		if !v.IsZero() {
+			s = "***"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: s}, nil
+	}
+	if v.Type() == durationType {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: time.Duration(v.Int()).String()}, nil
+	}
+	if v.Kind() != reflect.Struct {
+		n := &yaml.Node{}
+		if err := n.Encode(v.Interface()); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		val, err := toNode(v.Field(i), t.Field(i).Tag.Get("secret") == "true")
+		if err != nil {
+			return nil, err
+		}
+		n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: yamlName(t.Field(i))}, val)
+	}
+	return n, nil
+}
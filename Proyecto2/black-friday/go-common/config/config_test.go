@@ -0,0 +1,219 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func escribir(t *testing.T, ruta, contenido string) {
+	t.Helper()
+	if err := os.WriteFile(ruta, []byte(contenido), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildPrecedencia(t *testing.T) {
+	ruta := filepath.Join(t.TempDir(), "config.yaml")
+	escribir(t, ruta, "kafka:\n  topic: archivo\n  group: archivo\nvalkey:\n  stream_max_len: 10\n")
+	t.Setenv("KAFKA_GROUP", "entorno")
+
+	cfg, err := Build(Options{Path: ruta, PathGiven: true, Sets: []string{"valkey.stream_max_len=20"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Kafka.Topic != "archivo" {
+		t.Errorf("kafka.topic = %q, se esperaba el del archivo", cfg.Kafka.Topic)
+	}
+	if cfg.Kafka.Group != "entorno" {
+		t.Errorf("kafka.group = %q, el entorno deberia ganarle al archivo", cfg.Kafka.Group)
+	}
+	if cfg.Valkey.StreamMaxLen != 20 {
+		t.Errorf("valkey.stream_max_len = %d, -set deberia ganarle al archivo", cfg.Valkey.StreamMaxLen)
+	}
+	if cfg.Bridge.RequestTimeout != time.Second {
+		t.Errorf("bridge.request_timeout = %s, se esperaba el default", cfg.Bridge.RequestTimeout)
+	}
+}
+
+func TestBuildArchivo(t *testing.T) {
+	casos := []struct {
+		nombre    string
+		contenido string
+		error     string
+	}{
+		{"clave desconocida", "kafka:\n  topico: x\n", "topico"},
+		{"categorias reemplazan los defaults", "categorias:\n  9: Juguetes\n", ""},
+		{"varios errores juntos", "kafka:\n  topic: ''\nvalkey:\n  addr: ''\n", "valkey.addr"},
+		{"modo de auth desconocido", "auth:\n  mode: basic\n", "auth.mode"},
+		{"apikey sin archivo", "auth:\n  mode: apikey\n", "auth.api_keys_path"},
+		{"sentinel sin master", "valkey:\n  mode: sentinel\n  addrs: [s1:26379]\n", "valkey.master_name"},
+		{"cluster con db", "valkey:\n  mode: cluster\n  addrs: [n1:6379]\n  db: 1\n", "valkey.db"},
+		{"tls sin key", "valkey:\n  tls:\n    enabled: true\n    cert: /tmp/c.pem\n", "valkey.tls"},
+		{"tls desconocido", "grpc_tls:\n  mode: raro\n", "grpc_tls.mode"},
+		{"identidad requerida sin secreto", "auth:\n  require_identity: true\n", "auth.identity_secret"},
+		{"identidades permitidas sin secreto", "auth:\n  allowed_identities: [bridge]\n", "auth.identity_secret"},
+		{"fraude sin retencion de registros", "fraud:\n  record_retention: 0s\n", "fraud.record_retention"},
+		{"campana sin ventana", "campaigns:\n  - id: tv\n    start: 2026-11-27T00:00:00Z\n", "campaigns[0]"},
+		{"campana con descuento invalido", "campaigns:\n  - id: tv\n    start: 2026-11-27T00:00:00Z\n    end: 2026-11-28T00:00:00Z\n    discount_pct: 100\n", "campaigns[0].discount_pct"},
+		{"acceso anticipado sin identidades", "campaigns:\n  - id: tv\n    start: 2026-11-27T00:00:00Z\n    end: 2026-11-28T00:00:00Z\n    early_access:\n      start: 2026-11-26T00:00:00Z\n", "early_access.identities"},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			ruta := filepath.Join(t.TempDir(), "config.yaml")
+			escribir(t, ruta, c.contenido)
+			cfg, err := Build(Options{Path: ruta, PathGiven: true})
+			if c.error == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(cfg.Categorias) != 1 || cfg.Categorias[9] != "Juguetes" {
+					t.Errorf("categorias = %v", cfg.Categorias)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.error) {
+				t.Fatalf("se esperaba un error con %q, se obtuvo %v", c.error, err)
+			}
+		})
+	}
+}
+
+func TestBuildSinArchivo(t *testing.T) {
+	ruta := filepath.Join(t.TempDir(), "no-existe.yaml")
+	if _, err := Build(Options{Path: ruta}); err != nil {
+		t.Errorf("sin -config deberia usar los defaults: %v", err)
+	}
+	if _, err := Build(Options{Path: ruta, PathGiven: true}); err == nil {
+		t.Error("con -config explicito el archivo deberia ser obligatorio")
+	}
+}
+
+func TestSetString(t *testing.T) {
+	var (
+		i32 int32
+		i64 int64
+		d   time.Duration
+		b   bool
+		s   []string
+		m   map[int32]string
+	)
+	casos := []struct {
+		nombre string
+		dest   any
+		raw    string
+		valor  any
+		error  bool
+	}{
+		{"int32", &i32, "123", int32(123), false},
+		{"int32 desbordado", &i32, "4294967296", nil, true},
+		{"int32 negativo desbordado", &i32, "-2147483649", nil, true},
+		{"int64", &i64, "4294967296", int64(4294967296), false},
+		{"no numerico", &i64, "diez", nil, true},
+		{"duracion", &d, "90s", 90 * time.Second, false},
+		{"bool", &b, "true", true, false},
+		{"lista", &s, "a, b,,c", []string{"a", "b", "c"}, false},
+		{"mapa", &m, "1=Electronica, 2=Ropa", map[int32]string{1: "Electronica", 2: "Ropa"}, false},
+		{"mapa con id invalido", &m, "x=Ropa", nil, true},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			v := reflect.ValueOf(c.dest).Elem()
+			antes := reflect.ValueOf(v.Interface())
+			err := setString(v, c.raw)
+			if c.error {
+				if err == nil {
+					t.Fatalf("se esperaba un error, quedo %v", v.Interface())
+				}
+				if !reflect.DeepEqual(v.Interface(), antes.Interface()) {
+					t.Errorf("un valor invalido no deberia modificar el campo: %v", v.Interface())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(v.Interface(), c.valor) {
+				t.Errorf("valor %v, se esperaba %v", v.Interface(), c.valor)
+			}
+		})
+	}
+}
+
+func nuevoLoader(t *testing.T, contenido string) (*Loader, string) {
+	t.Helper()
+	ruta := filepath.Join(t.TempDir(), "config.yaml")
+	escribir(t, ruta, contenido)
+	o := Options{Path: ruta, PathGiven: true}
+	cfg, err := Build(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &Loader{opts: o}
+	l.cur.Store(cfg)
+	return l, ruta
+}
+
+func TestReload(t *testing.T) {
+	casos := []struct {
+		nombre    string
+		nuevo     string
+		error     bool
+		maxLen    int64
+		topic     string
+		avisoSubs bool
+	}{
+		{
+			nombre: "campo seguro cambia",
+			nuevo:  "kafka:\n  topic: ventas\nvalkey:\n  stream_max_len: 50\n",
+			maxLen: 50, topic: "ventas", avisoSubs: true,
+		},
+		{
+			nombre: "campo con reinicio conserva el valor",
+			nuevo:  "kafka:\n  topic: otro\nvalkey:\n  stream_max_len: 50\n",
+			maxLen: 50, topic: "ventas", avisoSubs: true,
+		},
+		{
+			// El topic vacio se descarta al mezclar, asi que no invalida la
+			// recarga del resto.
+			nombre: "campo con reinicio invalido se descarta",
+			nuevo:  "kafka:\n  topic: ''\nvalkey:\n  stream_max_len: 50\n",
+			maxLen: 50, topic: "ventas", avisoSubs: true,
+		},
+		{
+			nombre: "campo seguro invalido",
+			nuevo:  "kafka:\n  topic: ventas\nvalkey:\n  stream_max_len: -1\n",
+			error:  true, maxLen: 10, topic: "ventas",
+		},
+		{
+			nombre: "yaml invalido",
+			nuevo:  "kafka: [",
+			error:  true, maxLen: 10, topic: "ventas",
+		},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			l, ruta := nuevoLoader(t, "kafka:\n  topic: ventas\nvalkey:\n  stream_max_len: 10\n")
+			avisos := 0
+			l.OnReload(func(*Config) { avisos++ })
+
+			escribir(t, ruta, c.nuevo)
+			err := l.Reload()
+			if c.error != (err != nil) {
+				t.Fatalf("error = %v", err)
+			}
+			if got := l.Get().Valkey.StreamMaxLen; got != c.maxLen {
+				t.Errorf("valkey.stream_max_len = %d, se esperaba %d", got, c.maxLen)
+			}
+			if got := l.Get().Kafka.Topic; got != c.topic {
+				t.Errorf("kafka.topic = %q, se esperaba %q", got, c.topic)
+			}
+			if (avisos > 0) != c.avisoSubs {
+				t.Errorf("OnReload se llamo %d veces", avisos)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Loader guarda la configuracion vigente y la recarga con SIGHUP o cuando
+// cambia el archivo. Solo los campos con reload:"safe" toman el valor nuevo;
+// el resto conserva el valor de arranque y se avisa que requiere reinicio.
+type Loader struct {
+	opts Options
+	cur  atomic.Pointer[Config]
+
+	mu   sync.Mutex
+	subs []func(*Config)
+}
+
+// NewLoader devuelve un Loader con cfg vigente y sin archivo que vigilar,
+// para quien necesita una configuracion armada a mano, como las pruebas.
+func NewLoader(cfg *Config) *Loader {
+	l := &Loader{}
+	l.cur.Store(cfg)
+	return l
+}
+
+// Get devuelve la configuracion vigente. No se debe modificar.
+func (l *Loader) Get() *Config { return l.cur.Load() }
+
+// Args devuelve los argumentos posicionales que quedaron despues de los flags.
+func (l *Loader) Args() []string { return l.opts.Args }
+
+// OnReload registra fn para ejecutarse despues de cada recarga exitosa.
+func (l *Loader) OnReload(fn func(*Config)) {
+	l.mu.Lock()
+	l.subs = append(l.subs, fn)
+	l.mu.Unlock()
+}
+
+// Reload vuelve a construir la configuracion y aplica los cambios seguros. Se
+// valida el resultado ya mezclado, que es el que queda vigente.
+func (l *Loader) Reload() error {
+	nuevo, err := construir(l.opts)
+	if err != nil {
+		return err
+	}
+	viejo := l.Get()
+	mergeUnsafe(reflect.ValueOf(nuevo).Elem(), reflect.ValueOf(viejo).Elem(), "")
+	if err := nuevo.Validate(); err != nil {
+		return err
+	}
+	l.cur.Store(nuevo)
+	l.mu.Lock()
+	subs := append([]func(*Config){}, l.subs...)
+	l.mu.Unlock()
+	for _, fn := range subs {
+		fn(nuevo)
+	}
+	return nil
+}
+
+func mergeUnsafe(nuevo, viejo reflect.Value, prefijo string) {
+	t := nuevo.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		nombre := prefijo + yamlName(f)
+		if f.Type.Kind() == reflect.Struct && f.Type != durationType {
+			mergeUnsafe(nuevo.Field(i), viejo.Field(i), nombre+".")
+			continue
+		}
+		if f.Tag.Get("reload") == "safe" {
+			continue
+		}
+		if !reflect.DeepEqual(nuevo.Field(i).Interface(), viejo.Field(i).Interface()) {
+			log.Printf("config: %s cambio pero requiere reinicio, se mantiene el valor actual", nombre)
+			nuevo.Field(i).Set(viejo.Field(i))
+		}
+	}
+}
+
+// Watch recarga con SIGHUP o cuando cambia el mtime del archivo. Bloquea
+// hasta que ctx termina.
+func (l *Loader) Watch(ctx context.Context, intervalo time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ultimo := l.mtime()
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for {
+		motivo := ""
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			motivo = "SIGHUP"
+		case <-ticker.C:
+			m := l.mtime()
+			if m.Equal(ultimo) {
+				continue
+			}
+			ultimo = m
+			motivo = fmt.Sprintf("cambio en %s", l.opts.Path)
+		}
+		if err := l.Reload(); err != nil {
+			log.Printf("config: recarga (%s) fallida, se mantiene la anterior: %v", motivo, err)
+			continue
+		}
+		log.Printf("config: recargada (%s)", motivo)
+	}
+}
+
+func (l *Loader) mtime() time.Time {
+	info, err := os.Stat(l.opts.Path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,69 @@
+// Package eventid genera y valida los ids de evento (UUIDv7, RFC 9562) del
+// sobre de cada venta.
+package eventid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Version es la version actual del sobre; el consumer avisa si recibe una
+// mayor.
+const Version = 1
+
+// Atributo type de CloudEvents de cada evento del topic de ventas. Los
+// reembolsos y cancelaciones revierten una venta anterior.
+const (
+	TipoVenta       = "blackfriday.venta.v1"
+	TipoReembolso   = "blackfriday.reembolso.v1"
+	TipoCancelacion = "blackfriday.cancelacion.v1"
+)
+
+// Nuevo arma un UUIDv7: 48 bits con los milisegundos Unix de t y el resto
+// aleatorio, asi los ids ordenan por tiempo de ingreso.
+func Nuevo(t time.Time) string {
+	var u [16]byte
+	rand.Read(u[6:])
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(t.UnixMilli()))
+	copy(u[:6], ms[2:])
+	u[6] = u[6]&0x0f | 0x70
+	u[8] = u[8]&0x3f | 0x80
+	return formatear(u)
+}
+
+func formatear(u [16]byte) string {
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+// Validar acepta solo UUIDv7 en forma canonica (minusculas o mayusculas).
+func Validar(s string) error {
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return fmt.Errorf("event_id %q: no es un UUID canonico", s)
+	}
+	var u [16]byte
+	crudo := s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	if _, err := hex.Decode(u[:], []byte(crudo)); err != nil {
+		return fmt.Errorf("event_id %q: no es un UUID canonico", s)
+	}
+	if u[6]>>4 != 7 {
+		return fmt.Errorf("event_id %q: se espera UUID version 7, es version %d", s, u[6]>>4)
+	}
+	if u[8]>>6 != 2 {
+		return fmt.Errorf("event_id %q: variante invalida", s)
+	}
+	return nil
+}
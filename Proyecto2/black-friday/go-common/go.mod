@@ -0,0 +1,11 @@
+module go-common
+
+go 1.24.0
+
+require (
+	github.com/IBM/sarama v1.43.0
+	github.com/alicebob/miniredis/v2 v2.37.0
+	github.com/redis/go-redis/v9 v9.5.1
+	github.com/xdg-go/scram v1.1.2
+	gopkg.in/yaml.v3 v3.0.1
+)
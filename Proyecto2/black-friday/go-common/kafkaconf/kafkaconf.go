@@ -0,0 +1,188 @@
+// Package kafkaconf aplica la seccion kafka de la configuracion (version,
+// SASL y TLS) a un sarama.Config. go-grpc-writer y go-consumer lo usan
+// para conectarse al mismo listener con los mismos ajustes.
+package kafkaconf
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// New devuelve un sarama.Config con la conexion configurada. El llamante
+// completa los ajustes propios del productor o consumidor.
+func New(k Kafka) (*sarama.Config, error) {
+	sc := sarama.NewConfig()
+	if k.Version != "" {
+		v, err := sarama.ParseKafkaVersion(k.Version)
+		if err != nil {
+			return nil, fmt.Errorf("kafka.version: %w", err)
+		}
+		sc.Version = v
+	}
+	if k.SASL.Mechanism != "" {
+		if err := applySASL(sc, k.SASL); err != nil {
+			return nil, err
+		}
+	}
+	if k.TLS.Enabled {
+		tlsCfg, err := tlsConfig(k.TLS)
+		if err != nil {
+			return nil, err
+		}
+		sc.Net.TLS.Enable = true
+		sc.Net.TLS.Config = tlsCfg
+	}
+	if err := sc.Validate(); err != nil {
+		return nil, fmt.Errorf("kafka: %w", err)
+	}
+	return sc, nil
+}
+
+// Kafka, SASL y TLS reflejan config.Kafka; se definen aqui para que el paquete
+// no dependa de config.
+type Kafka struct {
+	Version string
+	SASL    SASL
+	TLS     TLS
+}
+
+type SASL struct {
+	Mechanism    string
+	Username     string
+	UsernameFile string
+	Password     string
+	PasswordFile string
+}
+
+type TLS struct {
+	Enabled            bool
+	CA                 string
+	Cert               string
+	Key                string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+func leerSecreto(valor, archivo, campo string) (string, error) {
+	if archivo == "" {
+		return valor, nil
+	}
+	b, err := os.ReadFile(archivo)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", campo, err)
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return "", fmt.Errorf("%s: %s esta vacio", campo, archivo)
+	}
+	return s, nil
+}
+
+func applySASL(sc *sarama.Config, s SASL) error {
+	user, err := leerSecreto(s.Username, s.UsernameFile, "kafka.sasl.username_file")
+	if err != nil {
+		return err
+	}
+	pass, err := leerSecreto(s.Password, s.PasswordFile, "kafka.sasl.password_file")
+	if err != nil {
+		return err
+	}
+	sc.Net.SASL.Enable = true
+	sc.Net.SASL.Handshake = true
+	sc.Net.SASL.User = user
+	sc.Net.SASL.Password = pass
+	switch s.Mechanism {
+	case sarama.SASLTypePlaintext:
+		sc.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case sarama.SASLTypeSCRAMSHA256:
+		sc.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		sc.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.HashGeneratorFcn(sha256.New)}
+		}
+	case sarama.SASLTypeSCRAMSHA512:
+		sc.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		sc.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.HashGeneratorFcn(sha512.New)}
+		}
+	default:
+		return fmt.Errorf("kafka.sasl.mechanism: mecanismo desconocido %q", s.Mechanism)
+	}
+	return nil
+}
+
+func tlsConfig(t TLS) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CA != "" {
+		b, err := os.ReadFile(t.CA)
+		if err != nil {
+			return nil, fmt.Errorf("kafka.tls.ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("kafka.tls.ca: %s no contiene certificados PEM", t.CA)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, fmt.Errorf("kafka.tls.cert/key: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+type scramClient struct {
+	hash scram.HashGeneratorFcn
+	conv *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(user, pass, authzID string) error {
+	client, err := c.hash.NewClient(user, pass, authzID)
+	if err != nil {
+		return err
+	}
+	c.conv = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) { return c.conv.Step(challenge) }
+
+func (c *scramClient) Done() bool { return c.conv.Done() }
+
+// Explicar traduce los errores de conexion mas comunes a un mensaje que indica
+// que revisar. Conserva el error original al final.
+func Explicar(err error) error {
+	var certErr *tls.CertificateVerificationError
+	var unknownAuth x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sarama.ErrSASLAuthenticationFailed):
+		return fmt.Errorf("credenciales SASL rechazadas por el broker, revise kafka.sasl.username/password: %w", err)
+	case errors.Is(err, sarama.ErrUnsupportedSASLMechanism):
+		return fmt.Errorf("el listener no acepta el mecanismo de kafka.sasl.mechanism: %w", err)
+	case errors.As(err, &unknownAuth), errors.As(err, &certErr):
+		return fmt.Errorf("no se pudo verificar el certificado del broker, revise kafka.tls.ca: %w", err)
+	case errors.As(err, &hostErr):
+		return fmt.Errorf("el certificado del broker no coincide con el host, revise kafka.tls.server_name: %w", err)
+	case errors.Is(err, sarama.ErrOutOfBrokers):
+		return fmt.Errorf("ningun broker respondio, revise kafka.brokers y si el listener requiere TLS/SASL: %w", err)
+	}
+	return err
+}
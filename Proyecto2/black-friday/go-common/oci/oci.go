@@ -0,0 +1,466 @@
+// Package oci descarga un archivo publicado como artefacto OCI (p.ej. con
+// "oras push" al registry Zot) usando la API de distribucion: resuelve el
+// manifiesto, verifica digests, guarda el archivo en un cache local y revisa
+// periodicamente si el tag apunta a otro manifiesto.
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mediaManifestOCI    = "application/vnd.oci.image.manifest.v1+json"
+	mediaManifestDocker = "application/vnd.docker.distribution.manifest.v2+json"
+	anotacionTitulo     = "org.opencontainers.image.title"
+)
+
+// Options refleja config.Artifact; se define aqui para que el paquete no
+// dependa de config.
+type Options struct {
+	// Ref es "registry/repo:tag" o "registry/repo@sha256:...".
+	Ref string
+	// Digest fija el manifiesto esperado; si no coincide la descarga falla.
+	Digest string
+	// Archivo elige la capa por su titulo; vacio exige una sola capa.
+	Archivo      string
+	CacheDir     string
+	Username     string
+	Password     string
+	PasswordFile string
+	// Insecure usa http en lugar de https, como el Zot del proyecto.
+	Insecure bool
+}
+
+type referencia struct {
+	registry, repo, tag, digest string
+}
+
+func parsearRef(ref string) (referencia, error) {
+	var r referencia
+	host, resto, ok := strings.Cut(ref, "/")
+	if !ok || resto == "" {
+		return r, fmt.Errorf("ref %q: se espera registry/repo:tag", ref)
+	}
+	r.registry = host
+	if repo, d, ok := strings.Cut(resto, "@"); ok {
+		r.repo, r.digest = repo, d
+	} else if i := strings.LastIndex(resto, ":"); i > strings.LastIndex(resto, "/") {
+		r.repo, r.tag = resto[:i], resto[i+1:]
+	} else {
+		r.repo, r.tag = resto, "latest"
+	}
+	if r.repo == "" {
+		return r, fmt.Errorf("ref %q: falta el repositorio", ref)
+	}
+	return r, nil
+}
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type manifiesto struct {
+	MediaType string       `json:"mediaType"`
+	Layers    []descriptor `json:"layers"`
+}
+
+// Fetcher descarga y mantiene al dia un archivo de un artefacto.
+type Fetcher struct {
+	opts Options
+	ref  referencia
+	http *http.Client
+
+	mu       sync.Mutex
+	token    string
+	manifest string // digest del ultimo manifiesto descargado
+}
+
+func New(o Options) (*Fetcher, error) {
+	ref, err := parsearRef(o.Ref)
+	if err != nil {
+		return nil, err
+	}
+	if o.Digest != "" && ref.digest != "" && o.Digest != ref.digest {
+		return nil, fmt.Errorf("ref %q y digest %q no coinciden", o.Ref, o.Digest)
+	}
+	if o.Digest == "" {
+		o.Digest = ref.digest
+	}
+	if o.Digest != "" && !strings.HasPrefix(o.Digest, "sha256:") {
+		return nil, fmt.Errorf("digest %q: solo se admite sha256", o.Digest)
+	}
+	if o.PasswordFile != "" {
+		b, err := os.ReadFile(o.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("password_file: %w", err)
+		}
+		o.Password = strings.TrimSpace(string(b))
+	}
+	if o.CacheDir == "" {
+		return nil, errors.New("falta el directorio de cache")
+	}
+	if err := os.MkdirAll(o.CacheDir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Fetcher{opts: o, ref: ref, http: &http.Client{Timeout: time.Minute}}, nil
+}
+
+// Ruta es donde queda el archivo descargado. No cambia entre versiones para
+// que el lector solo tenga que vigilar el mtime.
+func (f *Fetcher) Ruta() string {
+	nombre := f.opts.Archivo
+	if nombre == "" {
+		nombre = filepath.Base(f.ref.repo)
+	}
+	return filepath.Join(f.opts.CacheDir, filepath.Base(nombre))
+}
+
+// rutaDigest guarda el digest del manifiesto y el de la capa que esta en
+// Ruta, una por linea, para no volver a bajarla al reiniciar.
+func (f *Fetcher) rutaDigest() string {
+	return f.Ruta() + ".digest"
+}
+
+// leerCache devuelve los digests del manifiesto y de la capa de la copia en
+// cache. Los archivos de versiones anteriores solo tienen el de la capa.
+func (f *Fetcher) leerCache() (manifest, capa string, err error) {
+	b, err := os.ReadFile(f.rutaDigest())
+	if err != nil {
+		return "", "", err
+	}
+	lineas := strings.Fields(string(b))
+	switch len(lineas) {
+	case 1:
+		return "", lineas[0], nil
+	case 2:
+		return lineas[0], lineas[1], nil
+	}
+	return "", "", fmt.Errorf("%s: formato invalido", f.rutaDigest())
+}
+
+func (f *Fetcher) guardarCache(manifest, capa string) error {
+	return os.WriteFile(f.rutaDigest(), []byte(manifest+"\n"+capa+"\n"), 0o644)
+}
+
+// digestArchivo calcula el sha256 del archivo en ruta.
+func digestArchivo(ruta string) (string, error) {
+	a, err := os.Open(ruta)
+	if err != nil {
+		return "", err
+	}
+	defer a.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, a); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// validarCache comprueba que la copia en cache sea la capa que dice el
+// archivo .digest y, con un digest fijo, que venga de ese manifiesto.
+func (f *Fetcher) validarCache() error {
+	manifest, capa, err := f.leerCache()
+	if err != nil {
+		return err
+	}
+	if f.opts.Digest != "" && manifest != f.opts.Digest {
+		if manifest == "" {
+			manifest = "desconocido"
+		}
+		return fmt.Errorf("la copia en cache es del manifiesto %s, se espera %s", manifest, f.opts.Digest)
+	}
+	got, err := digestArchivo(f.Ruta())
+	if err != nil {
+		return err
+	}
+	if got != capa {
+		return fmt.Errorf("la copia en cache tiene digest %s, se espera %s", got, capa)
+	}
+	return nil
+}
+
+// Descargar trae el archivo si la capa cambio. Si el registry no responde
+// pero hay una copia en cache que coincide con su digest (y con el digest
+// fijo, si lo hay), la usa y solo avisa. Devuelve true si el archivo en Ruta
+// cambio.
+func (f *Fetcher) Descargar(ctx context.Context) (bool, error) {
+	cambio, err := f.descargar(ctx)
+	if err == nil {
+		return cambio, nil
+	}
+	errCache := f.validarCache()
+	if errCache == nil {
+		log.Printf("OCI %s: %v; se usa la copia en cache", f.opts.Ref, err)
+		return false, nil
+	}
+	if errors.Is(errCache, os.ErrNotExist) {
+		return false, err
+	}
+	return false, fmt.Errorf("%w; no se usa la copia en cache: %v", err, errCache)
+}
+
+func (f *Fetcher) descargar(ctx context.Context) (bool, error) {
+	referencia := f.ref.tag
+	if f.opts.Digest != "" {
+		referencia = f.opts.Digest
+	}
+	resp, err := f.pedir(ctx, "/manifests/"+referencia, mediaManifestOCI+", "+mediaManifestDocker)
+	if err != nil {
+		return false, err
+	}
+	cuerpo, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	resp.Body.Close()
+	if err != nil {
+		return false, err
+	}
+	suma := sha256.Sum256(cuerpo)
+	digest := "sha256:" + hex.EncodeToString(suma[:])
+	if f.opts.Digest != "" && digest != f.opts.Digest {
+		return false, fmt.Errorf("el manifiesto tiene digest %s, se espera %s", digest, f.opts.Digest)
+	}
+	if h := resp.Header.Get("Docker-Content-Digest"); h != "" && h != digest {
+		return false, fmt.Errorf("el registry anuncia %s pero el manifiesto tiene %s", h, digest)
+	}
+	f.mu.Lock()
+	igual := digest == f.manifest
+	f.mu.Unlock()
+	if igual {
+		return false, nil
+	}
+
+	var m manifiesto
+	if err := json.Unmarshal(cuerpo, &m); err != nil {
+		return false, fmt.Errorf("manifiesto: %w", err)
+	}
+	capa, err := f.elegirCapa(m.Layers)
+	if err != nil {
+		return false, err
+	}
+	if manifest, actual, err := f.leerCache(); err == nil && actual == capa.Digest {
+		// El .digest dice que ya esta; se comprueba el archivo por si se
+		// modifico o quedo a medias.
+		if got, err := digestArchivo(f.Ruta()); err == nil && got == capa.Digest {
+			if manifest != digest {
+				if err := f.guardarCache(digest, capa.Digest); err != nil {
+					return false, err
+				}
+			}
+			f.mu.Lock()
+			f.manifest = digest
+			f.mu.Unlock()
+			return false, nil
+		}
+		log.Printf("OCI %s: la copia en cache no coincide con %s, se descarga de nuevo", f.opts.Ref, capa.Digest)
+	}
+	if err := f.bajarCapa(ctx, digest, capa); err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	f.manifest = digest
+	f.mu.Unlock()
+	log.Printf("OCI %s: descargado %s (%s, %d bytes)", f.opts.Ref, f.Ruta(), capa.Digest, capa.Size)
+	return true, nil
+}
+
+func (f *Fetcher) elegirCapa(capas []descriptor) (descriptor, error) {
+	if f.opts.Archivo == "" {
+		if len(capas) != 1 {
+			return descriptor{}, fmt.Errorf("el artefacto tiene %d capas; indique el archivo", len(capas))
+		}
+		return capas[0], nil
+	}
+	for _, c := range capas {
+		if c.Annotations[anotacionTitulo] == f.opts.Archivo {
+			return c, nil
+		}
+	}
+	return descriptor{}, fmt.Errorf("el artefacto no tiene el archivo %q", f.opts.Archivo)
+}
+
+// bajarCapa descarga el blob a un temporal, verifica tamano y digest y lo
+// renombra sobre Ruta, asi un lector nunca ve un archivo a medias. manifest
+// es el digest del manifiesto de donde sale la capa.
+func (f *Fetcher) bajarCapa(ctx context.Context, manifest string, capa descriptor) error {
+	if !strings.HasPrefix(capa.Digest, "sha256:") {
+		return fmt.Errorf("capa con digest %q no soportado", capa.Digest)
+	}
+	resp, err := f.pedir(ctx, "/blobs/"+capa.Digest, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	tmp, err := os.CreateTemp(f.opts.CacheDir, ".descarga-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if capa.Size > 0 && n != capa.Size {
+		return fmt.Errorf("blob %s: %d bytes, se esperaban %d", capa.Digest, n, capa.Size)
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != capa.Digest {
+		return fmt.Errorf("blob %s: el contenido tiene digest %s", capa.Digest, got)
+	}
+	if err := os.Rename(tmp.Name(), f.Ruta()); err != nil {
+		return err
+	}
+	return f.guardarCache(manifest, capa.Digest)
+}
+
+// pedir hace un GET a /v2/<repo><ruta>. Ante un 401 sigue el desafio del
+// registry: Basic con las credenciales o Bearer pidiendo un token al realm.
+func (f *Fetcher) pedir(ctx context.Context, ruta, accept string) (*http.Response, error) {
+	esquema := "https"
+	if f.opts.Insecure {
+		esquema = "http"
+	}
+	u := fmt.Sprintf("%s://%s/v2/%s%s", esquema, f.ref.registry, f.ref.repo, ruta)
+	for intento := 0; ; intento++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		f.mu.Lock()
+		token := f.token
+		f.mu.Unlock()
+		switch {
+		case token != "":
+			req.Header.Set("Authorization", "Bearer "+token)
+		case f.opts.Username != "":
+			req.SetBasicAuth(f.opts.Username, f.opts.Password)
+		}
+		resp, err := f.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized && intento == 0 {
+			if err := f.autenticar(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+}
+
+// autenticar obtiene un token Bearer segun el desafio. Con un desafio Basic
+// basta con reintentar con las credenciales.
+func (f *Fetcher) autenticar(ctx context.Context, desafio string) error {
+	esquema, params, _ := strings.Cut(desafio, " ")
+	if strings.EqualFold(esquema, "Basic") {
+		if f.opts.Username == "" {
+			return errors.New("el registry pide credenciales y no hay username")
+		}
+		return nil
+	}
+	if !strings.EqualFold(esquema, "Bearer") {
+		return fmt.Errorf("desafio de autenticacion no soportado: %q", desafio)
+	}
+	p := map[string]string{}
+	for _, par := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(par), "=")
+		p[k] = strings.Trim(v, `"`)
+	}
+	if p["realm"] == "" {
+		return fmt.Errorf("desafio Bearer sin realm: %q", desafio)
+	}
+	q := url.Values{}
+	if p["service"] != "" {
+		q.Set("service", p["service"])
+	}
+	scope := p["scope"]
+	if scope == "" {
+		scope = "repository:" + f.ref.repo + ":pull"
+	}
+	q.Set("scope", scope)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p["realm"]+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if f.opts.Username != "" {
+		req.SetBasicAuth(f.opts.Username, f.opts.Password)
+	}
+	resp, err := f.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token de %s: %s", p["realm"], resp.Status)
+	}
+	var t struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return err
+	}
+	if t.Token == "" {
+		t.Token = t.AccessToken
+	}
+	f.mu.Lock()
+	f.token = t.Token
+	f.mu.Unlock()
+	return nil
+}
+
+// Vigilar revisa el tag cada intervalo y llama a alCambiar cuando se
+// descarga una version nueva. Con el digest fijo no hay nada que revisar.
+// Bloquea hasta que ctx termina.
+func (f *Fetcher) Vigilar(ctx context.Context, intervalo time.Duration, alCambiar func(ruta string)) {
+	if f.opts.Digest != "" || intervalo <= 0 {
+		return
+	}
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// Los tokens Bearer vencen; se pide uno nuevo en cada revision.
+		f.mu.Lock()
+		f.token = ""
+		f.mu.Unlock()
+		cambio, err := f.descargar(ctx)
+		if err != nil {
+			log.Printf("OCI %s: %v", f.opts.Ref, err)
+			continue
+		}
+		if cambio && alCambiar != nil {
+			alCambiar(f.Ruta())
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// registry es un registry OCI minimo en proceso: sirve un manifiesto con una
+// capa y exige un token Bearer que entrega el realm a quien tenga las
+// credenciales.
+type registry struct {
+	srv       *httptest.Server
+	manifest  []byte
+	digest    string
+	blob      []byte
+	blobSuma  string
+	tokens    int
+	corromper bool
+}
+
+const (
+	repoPrueba    = "config/categorias"
+	usuarioPrueba = "ci"
+	clavePrueba   = "secreta"
+	tokenPrueba   = "token-de-prueba"
+)
+
+func digestDe(b []byte) string {
+	s := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(s[:])
+}
+
+func nuevoRegistry(t *testing.T, contenido string) *registry {
+	t.Helper()
+	r := &registry{blob: []byte(contenido)}
+	r.blobSuma = digestDe(r.blob)
+	r.manifest, _ = json.Marshal(manifiesto{
+		MediaType: mediaManifestOCI,
+		Layers: []descriptor{{
+			MediaType:   "application/yaml",
+			Digest:      r.blobSuma,
+			Size:        int64(len(r.blob)),
+			Annotations: map[string]string{anotacionTitulo: "categorias.yaml"},
+		}},
+	})
+	r.digest = digestDe(r.manifest)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		u, p, ok := req.BasicAuth()
+		if !ok || u != usuarioPrueba || p != clavePrueba {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Query().Get("scope") != "repository:"+repoPrueba+":pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		r.tokens++
+		json.NewEncoder(w).Encode(map[string]string{"token": tokenPrueba})
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer "+tokenPrueba {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="prueba",scope="repository:%s:pull"`, r.srv.URL, repoPrueba))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ruta := strings.TrimPrefix(req.URL.Path, "/v2/"+repoPrueba)
+		switch {
+		case ruta == "/manifests/v1" || ruta == "/manifests/"+r.digest:
+			w.Header().Set("Content-Type", mediaManifestOCI)
+			w.Header().Set("Docker-Content-Digest", r.digest)
+			w.Write(r.manifest)
+		case ruta == "/blobs/"+r.blobSuma:
+			if r.corromper {
+				w.Write([]byte(strings.ToUpper(string(r.blob))))
+				return
+			}
+			w.Write(r.blob)
+		default:
+			http.NotFound(w, req)
+		}
+	})
+	r.srv = httptest.NewServer(mux)
+	t.Cleanup(r.srv.Close)
+	return r
+}
+
+func (r *registry) opciones(dir string) Options {
+	return Options{
+		Ref:      strings.TrimPrefix(r.srv.URL, "http://") + "/" + repoPrueba + ":v1",
+		Archivo:  "categorias.yaml",
+		CacheDir: dir,
+		Username: usuarioPrueba,
+		Password: clavePrueba,
+		Insecure: true,
+	}
+}
+
+func nuevoFetcher(t *testing.T, o Options) *Fetcher {
+	t.Helper()
+	f, err := New(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestDescargarConBearer(t *testing.T) {
+	r := nuevoRegistry(t, "1: Electronica\n")
+	f := nuevoFetcher(t, r.opciones(t.TempDir()))
+
+	cambio, err := f.Descargar(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cambio {
+		t.Error("la primera descarga deberia informar un cambio")
+	}
+	if r.tokens != 1 {
+		t.Errorf("se pidieron %d tokens, se esperaba 1", r.tokens)
+	}
+	b, err := os.ReadFile(f.Ruta())
+	if err != nil || string(b) != string(r.blob) {
+		t.Fatalf("archivo descargado %q (%v), se esperaba %q", b, err, r.blob)
+	}
+
+	cambio, err = f.Descargar(context.Background())
+	if err != nil || cambio {
+		t.Errorf("sin cambios en el registry: cambio=%v err=%v", cambio, err)
+	}
+
+	// Un proceso nuevo con la copia en cache no vuelve a bajar la capa.
+	f2 := nuevoFetcher(t, r.opciones(f.opts.CacheDir))
+	cambio, err = f2.Descargar(context.Background())
+	if err != nil || cambio {
+		t.Errorf("con la capa en cache: cambio=%v err=%v", cambio, err)
+	}
+}
+
+func TestCredencialesInvalidas(t *testing.T) {
+	r := nuevoRegistry(t, "1: Electronica\n")
+	o := r.opciones(t.TempDir())
+	o.Password = "otra"
+	if _, err := nuevoFetcher(t, o).Descargar(context.Background()); err == nil {
+		t.Fatal("se esperaba un error con credenciales invalidas")
+	}
+}
+
+func TestDigestDeManifiestoDistinto(t *testing.T) {
+	r := nuevoRegistry(t, "1: Electronica\n")
+	o := r.opciones(t.TempDir())
+	o.Digest = digestDe([]byte("otro manifiesto"))
+	f := nuevoFetcher(t, o)
+	_, err := f.Descargar(context.Background())
+	if err == nil {
+		t.Fatal("se esperaba un error con el digest fijo distinto")
+	}
+	if _, err := os.Stat(f.Ruta()); !os.IsNotExist(err) {
+		t.Errorf("no deberia quedar archivo: %v", err)
+	}
+}
+
+func TestDigestDeBlobDistinto(t *testing.T) {
+	r := nuevoRegistry(t, "1: Electronica\n")
+	r.corromper = true
+	f := nuevoFetcher(t, r.opciones(t.TempDir()))
+	_, err := f.Descargar(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "digest") {
+		t.Fatalf("se esperaba un error de digest del blob, se obtuvo %v", err)
+	}
+	if _, err := os.Stat(f.Ruta()); !os.IsNotExist(err) {
+		t.Errorf("no deberia quedar archivo: %v", err)
+	}
+}
+
+func TestCacheSinRegistry(t *testing.T) {
+	r := nuevoRegistry(t, "1: Electronica\n")
+	dir := t.TempDir()
+	o := r.opciones(dir)
+	o.Digest = r.digest
+	if _, err := nuevoFetcher(t, o).Descargar(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	r.srv.Close()
+
+	t.Run("misma version", func(t *testing.T) {
+		cambio, err := nuevoFetcher(t, o).Descargar(context.Background())
+		if err != nil || cambio {
+			t.Errorf("con el registry caido deberia usar la cache: cambio=%v err=%v", cambio, err)
+		}
+	})
+
+	t.Run("digest fijo cambiado", func(t *testing.T) {
+		otro := o
+		otro.Digest = digestDe([]byte("version nueva"))
+		if _, err := nuevoFetcher(t, otro).Descargar(context.Background()); err == nil {
+			t.Error("con otro digest fijo no deberia usar la copia en cache")
+		}
+	})
+
+	t.Run("copia modificada", func(t *testing.T) {
+		f := nuevoFetcher(t, o)
+		if err := os.WriteFile(f.Ruta(), []byte("1: Otra cosa\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Descargar(context.Background()); err == nil {
+			t.Error("una copia que no coincide con su digest no deberia usarse")
+		}
+	})
+}
+
+func TestCacheSeRevalidaAlReiniciar(t *testing.T) {
+	r := nuevoRegistry(t, "1: Electronica\n")
+	dir := t.TempDir()
+	f := nuevoFetcher(t, r.opciones(dir))
+	if _, err := f.Descargar(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(f.Ruta(), []byte("modificado"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cambio, err := nuevoFetcher(t, r.opciones(dir)).Descargar(context.Background())
+	if err != nil || !cambio {
+		t.Fatalf("una copia modificada deberia volver a bajarse: cambio=%v err=%v", cambio, err)
+	}
+	if b, _ := os.ReadFile(f.Ruta()); string(b) != string(r.blob) {
+		t.Errorf("archivo %q, se esperaba %q", b, r.blob)
+	}
+}
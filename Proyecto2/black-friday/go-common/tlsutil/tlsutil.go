@@ -0,0 +1,378 @@
+// Package tlsutil arma la configuracion TLS/mTLS del canal gRPC entre
+// go-bridge y go-grpc-writer.
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	ModeOff  = "off"
+	ModeTLS  = "tls"
+	ModeMTLS = "mtls"
+	ModeDev  = "dev"
+)
+
+// Options describe la configuracion TLS de un extremo. En modo dev se genera
+// (o reutiliza) una CA desechable en DevDir y se trabaja como mtls.
+type Options struct {
+	Mode        string
+	CertFile    string
+	KeyFile     string
+	CAFile      string
+	ServerName  string
+	AllowedSANs []string
+	DevDir      string
+}
+
+func (o Options) Enabled() bool { return o.Mode != "" && o.Mode != ModeOff }
+
+func (o Options) mutual() bool { return o.Mode == ModeMTLS || o.Mode == ModeDev }
+
+func (o Options) validate(rol string) error {
+	switch o.Mode {
+	case "", ModeOff, ModeDev:
+		return nil
+	case ModeTLS, ModeMTLS:
+	default:
+		return fmt.Errorf("modo TLS desconocido %q", o.Mode)
+	}
+	if rol == "server" || o.Mode == ModeMTLS {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return fmt.Errorf("modo %s requiere certificado y llave", o.Mode)
+		}
+	}
+	if (rol == "client" || o.Mode == ModeMTLS) && o.CAFile == "" {
+		return fmt.Errorf("modo %s requiere CA", o.Mode)
+	}
+	return nil
+}
+
+// reloader relee un archivo cuando cambia su mtime. Se consulta en cada
+// handshake, asi que un certificado rotado se toma sin reiniciar.
+type reloader struct {
+	mu      sync.Mutex
+	paths   []string
+	mtimes  []time.Time
+	checked time.Time
+	load    func() error
+}
+
+func (r *reloader) refresh() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) < time.Second && r.mtimes != nil {
+		return nil
+	}
+	r.checked = time.Now()
+	mtimes := make([]time.Time, len(r.paths))
+	changed := r.mtimes == nil
+	for i, p := range r.paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		mtimes[i] = info.ModTime()
+		if !changed && !mtimes[i].Equal(r.mtimes[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := r.load(); err != nil {
+		return err
+	}
+	r.mtimes = mtimes
+	return nil
+}
+
+type material struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+
+	certR *reloader
+	caR   *reloader
+}
+
+func newMaterial(o Options) (*material, error) {
+	m := &material{}
+	if o.CertFile != "" {
+		m.certR = &reloader{paths: []string{o.CertFile, o.KeyFile}, load: func() error {
+			c, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+			if err != nil {
+				return err
+			}
+			m.mu.Lock()
+			m.cert = &c
+			m.mu.Unlock()
+			return nil
+		}}
+		if err := m.certR.refresh(); err != nil {
+			return nil, fmt.Errorf("certificado: %w", err)
+		}
+	}
+	if o.CAFile != "" {
+		m.caR = &reloader{paths: []string{o.CAFile}, load: func() error {
+			b, err := os.ReadFile(o.CAFile)
+			if err != nil {
+				return err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(b) {
+				return fmt.Errorf("%s no contiene certificados PEM", o.CAFile)
+			}
+			m.mu.Lock()
+			m.pool = pool
+			m.mu.Unlock()
+			return nil
+		}}
+		if err := m.caR.refresh(); err != nil {
+			return nil, fmt.Errorf("CA: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func (m *material) certificate() (*tls.Certificate, error) {
+	if m.certR != nil {
+		_ = m.certR.refresh()
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil, errors.New("sin certificado configurado")
+	}
+	return m.cert, nil
+}
+
+func (m *material) roots() *x509.CertPool {
+	if m.caR != nil {
+		_ = m.caR.refresh()
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pool
+}
+
+// checkSANs exige que el certificado tenga al menos un SAN (DNS o URI)
+// de la lista permitida.
+func checkSANs(cert *x509.Certificate, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	for _, s := range sans {
+		for _, a := range allowed {
+			if s == a {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("identidad %v no permitida", sans)
+}
+
+func verifyChain(certs []*x509.Certificate, roots *x509.CertPool, dnsName string, usage x509.ExtKeyUsage) (*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("el par no presento certificado")
+	}
+	inter := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		inter.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inter,
+		DNSName:       dnsName,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	return certs[0], err
+}
+
+// ServerConfig devuelve la configuracion del writer. En mtls la verificacion
+// del cliente se hace contra la CA vigente y la lista de SANs permitidos.
+func ServerConfig(o Options) (*tls.Config, error) {
+	o, err := prepare(o, "server")
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMaterial(o)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return m.certificate()
+		},
+	}
+	if o.mutual() {
+		// La cadena se verifica en VerifyConnection para usar la CA recargada.
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			leaf, err := verifyChain(cs.PeerCertificates, m.roots(), "", x509.ExtKeyUsageClientAuth)
+			if err != nil {
+				return err
+			}
+			return checkSANs(leaf, o.AllowedSANs)
+		}
+	}
+	return cfg, nil
+}
+
+// ClientConfig devuelve la configuracion del bridge hacia el writer.
+func ClientConfig(o Options) (*tls.Config, error) {
+	o, err := prepare(o, "client")
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMaterial(o)
+	if err != nil {
+		return nil, err
+	}
+	serverName := o.ServerName
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// La verificacion estandar usaria un pool fijo; se reemplaza por
+		// VerifyConnection para que una CA rotada aplique sin reiniciar.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			name := serverName
+			if name == "" {
+				name = cs.ServerName
+			}
+			leaf, err := verifyChain(cs.PeerCertificates, m.roots(), name, x509.ExtKeyUsageServerAuth)
+			if err != nil {
+				return err
+			}
+			return checkSANs(leaf, o.AllowedSANs)
+		},
+	}
+	if o.mutual() {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return m.certificate()
+		}
+	}
+	return cfg, nil
+}
+
+func prepare(o Options, rol string) (Options, error) {
+	if err := o.validate(rol); err != nil {
+		return o, err
+	}
+	if o.Mode != ModeDev {
+		return o, nil
+	}
+	dir := o.DevDir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "black-friday-dev-tls")
+	}
+	if err := ensureDevCA(dir); err != nil {
+		return o, fmt.Errorf("dev CA: %w", err)
+	}
+	o.CAFile = filepath.Join(dir, "ca.crt")
+	o.CertFile = filepath.Join(dir, rol+".crt")
+	o.KeyFile = filepath.Join(dir, rol+".key")
+	if o.ServerName == "" {
+		o.ServerName = "localhost"
+	}
+	return o, nil
+}
+
+// ensureDevCA genera una CA desechable y certificados server/client en dir si
+// todavia no existen. Solo para pruebas locales.
+func ensureDevCA(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "ca.crt")); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "black-friday dev CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(30 * 24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return err
+	}
+	hojas := []struct {
+		nombre string
+		dns    []string
+		uso    x509.ExtKeyUsage
+	}{
+		{"server", []string{"localhost", "go-writer-service"}, x509.ExtKeyUsageServerAuth},
+		{"client", []string{"go-bridge"}, x509.ExtKeyUsageClientAuth},
+	}
+	for i, h := range hojas {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: h.dns[len(h.dns)-1]},
+			DNSNames:     h.dns,
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(30 * 24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{h.uso},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			return err
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		if err := writePEM(filepath.Join(dir, h.nombre+".crt"), "CERTIFICATE", der); err != nil {
+			return err
+		}
+		if err := writePEM(filepath.Join(dir, h.nombre+".key"), "EC PRIVATE KEY", keyDER); err != nil {
+			return err
+		}
+	}
+	return writePEM(filepath.Join(dir, "ca.crt"), "CERTIFICATE", caDER)
+}
+
+func writePEM(path, tipo string, der []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: tipo, Bytes: der}), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
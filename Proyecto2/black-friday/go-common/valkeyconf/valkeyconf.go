@@ -0,0 +1,141 @@
+// Package valkeyconf crea el cliente de Valkey segun el modo configurado
+// (standalone, sentinel o cluster) con ACL y TLS opcionales.
+package valkeyconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Options refleja config.Valkey; se define aqui para que el paquete no dependa
+// de config.
+type Options struct {
+	Mode             string
+	Addr             string
+	Addrs            []string
+	MasterName       string
+	Username         string
+	Password         string
+	PasswordFile     string
+	SentinelPassword string
+	DB               int
+	TLS              TLS
+}
+
+type TLS struct {
+	Enabled            bool
+	CA                 string
+	Cert               string
+	Key                string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// Cluster indica si las claves de varios comandos deben compartir slot.
+func (o Options) Cluster() bool { return o.Mode == "cluster" }
+
+func (o Options) credenciales() (string, *tls.Config, error) {
+	pass := o.Password
+	if o.PasswordFile != "" {
+		b, err := os.ReadFile(o.PasswordFile)
+		if err != nil {
+			return "", nil, fmt.Errorf("valkey.password_file: %w", err)
+		}
+		pass = strings.TrimSpace(string(b))
+	}
+	var tlsCfg *tls.Config
+	if o.TLS.Enabled {
+		var err error
+		if tlsCfg, err = tlsConfig(o.TLS); err != nil {
+			return "", nil, err
+		}
+	}
+	return pass, tlsCfg, nil
+}
+
+// NewNode conecta a un nodo puntual (por ejemplo una replica de lectura) con
+// las mismas credenciales y TLS que el primario.
+func NewNode(o Options, addr string) (*redis.Client, error) {
+	pass, tlsCfg, err := o.credenciales()
+	if err != nil {
+		return nil, err
+	}
+	return redis.NewClient(&redis.Options{
+		Addr:      addr,
+		Username:  o.Username,
+		Password:  pass,
+		DB:        o.DB,
+		TLSConfig: tlsCfg,
+	}), nil
+}
+
+func New(o Options) (redis.UniversalClient, error) {
+	pass, tlsCfg, err := o.credenciales()
+	if err != nil {
+		return nil, err
+	}
+	switch o.Mode {
+	case "", "standalone":
+		return redis.NewClient(&redis.Options{
+			Addr:      o.Addr,
+			Username:  o.Username,
+			Password:  pass,
+			DB:        o.DB,
+			TLSConfig: tlsCfg,
+		}), nil
+	case "sentinel":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       o.MasterName,
+			SentinelAddrs:    o.Addrs,
+			SentinelPassword: o.SentinelPassword,
+			Username:         o.Username,
+			Password:         pass,
+			DB:               o.DB,
+			TLSConfig:        tlsCfg,
+		}), nil
+	case "cluster":
+		addrs := o.Addrs
+		if len(addrs) == 0 {
+			addrs = []string{o.Addr}
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     addrs,
+			Username:  o.Username,
+			Password:  pass,
+			TLSConfig: tlsCfg,
+		}), nil
+	}
+	return nil, fmt.Errorf("valkey.mode: modo desconocido %q", o.Mode)
+}
+
+func tlsConfig(t TLS) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CA != "" {
+		b, err := os.ReadFile(t.CA)
+		if err != nil {
+			return nil, fmt.Errorf("valkey.tls.ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("valkey.tls.ca: %s no contiene certificados PEM", t.CA)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, fmt.Errorf("valkey.tls.cert/key: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,118 @@
+package valkeyconf
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNewACL(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.RequireUserAuth("bridge", "clave")
+	archivo := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(archivo, []byte("clave\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	casos := []struct {
+		nombre string
+		opts   Options
+		ok     bool
+	}{
+		{"password", Options{Addr: m.Addr(), Username: "bridge", Password: "clave"}, true},
+		{"password_file tiene prioridad", Options{Addr: m.Addr(), Username: "bridge", Password: "otra", PasswordFile: archivo}, true},
+		{"password incorrecta", Options{Addr: m.Addr(), Username: "bridge", Password: "otra"}, false},
+		{"sin usuario", Options{Addr: m.Addr(), Password: "clave"}, false},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			rdb, err := New(c.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rdb.Close()
+			err = rdb.Ping(context.Background()).Err()
+			if c.ok != (err == nil) {
+				t.Errorf("ping: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewModos(t *testing.T) {
+	casos := []struct {
+		modo  string
+		error string
+	}{
+		{"", ""},
+		{"standalone", ""},
+		{"sentinel", ""},
+		{"cluster", ""},
+		{"replicado", "modo desconocido"},
+	}
+	for _, c := range casos {
+		t.Run(c.modo, func(t *testing.T) {
+			rdb, err := New(Options{Mode: c.modo, Addr: "localhost:6379", Addrs: []string{"localhost:26379"}, MasterName: "mymaster"})
+			if c.error != "" {
+				if err == nil || !strings.Contains(err.Error(), c.error) {
+					t.Fatalf("se esperaba un error con %q, se obtuvo %v", c.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rdb.Close()
+			_, esCluster := rdb.(*redis.ClusterClient)
+			if esCluster != (c.modo == "cluster") {
+				t.Errorf("modo %q devolvio %T", c.modo, rdb)
+			}
+			if (Options{Mode: c.modo}).Cluster() != esCluster {
+				t.Errorf("Cluster() no coincide con el cliente para %q", c.modo)
+			}
+		})
+	}
+}
+
+func TestCredencialesInvalidas(t *testing.T) {
+	dir := t.TempDir()
+	sinPEM := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(sinPEM, []byte("no es un certificado"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	casos := []struct {
+		nombre string
+		opts   Options
+		error  string
+	}{
+		{"password_file inexistente", Options{PasswordFile: filepath.Join(dir, "nada")}, "valkey.password_file"},
+		{"ca inexistente", Options{TLS: TLS{Enabled: true, CA: filepath.Join(dir, "nada")}}, "valkey.tls.ca"},
+		{"ca sin certificados", Options{TLS: TLS{Enabled: true, CA: sinPEM}}, "no contiene certificados"},
+		{"cert sin key", Options{TLS: TLS{Enabled: true, Cert: sinPEM}}, "valkey.tls.cert/key"},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			_, err := New(c.opts)
+			if err == nil || !strings.Contains(err.Error(), c.error) {
+				t.Fatalf("se esperaba un error con %q, se obtuvo %v", c.error, err)
+			}
+		})
+	}
+}
+
+func TestTLSDeshabilitado(t *testing.T) {
+	// Sin tls.enabled los archivos de TLS no se leen.
+	rdb, err := New(Options{Addr: "localhost:6379", TLS: TLS{CA: "/no/existe"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdb.Close()
+	if tlsCfg := rdb.(*redis.Client).Options().TLSConfig; tlsCfg != nil {
+		t.Errorf("tls=%v", tlsCfg)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/v9"
+)
+
+// headerIdentidad es la identidad que el writer copia del bridge.
+const headerIdentidad = "caller-identity"
+
+// Ajuste es un reembolso o cancelacion de una venta anterior, tal como lo
+// escribe el writer. Cantidad 0 revierte lo que quede de la venta.
+type Ajuste struct {
+	EventIDOriginal string `json:"event_id_original"`
+	Cantidad        int32  `json:"cantidad"`
+	Motivo          string `json:"motivo"`
+	Tipo            string `json:"tipo"`
+	Envelope        *Sobre `json:"envelope"`
+}
+
+// ventaOriginal es la clave con lo necesario para revertir una venta: la
+// categoria ya resuelta, producto, precios, la identidad de quien la hizo y
+// las unidades que quedan sin revertir. Tambien guarda cada ajuste aplicado,
+// asi un reintento con el mismo id no se aplica dos veces.
+func (k Claves) ventaOriginal(eventID string) string {
+	return k.categoria("venta_original", strings.ToLower(eventID))
+}
+
+// guardarOriginalScript no pisa una venta ya guardada: si el registro se
+// vuelve a entregar despues de un reembolso, restante no debe volver atras.
+var guardarOriginalScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  return 0
+end
+for i = 2, #ARGV, 2 do
+  redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 1])
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+return 1
+`)
+
+// revertirScript reserva las unidades del ajuste ARGV[1] sobre la venta.
+// ARGV[4] es la identidad de quien pide el ajuste y ARGV[5] es 1 si es
+// administradora. Devuelve {unidades, restante}; unidades es -1 si la venta
+// no esta (nunca llego o vencio consumer.refund_window), -2 si el ajuste ya
+// se aplico, -3 si pide mas de lo que queda y -4 si la venta es de otro
+// cliente. Una venta sin cliente (llego sin identidad) solo la puede revertir
+// un administrador. El id del ajuste se registra solo si se aplica, asi un reintento
+// corregido con el mismo id no queda como duplicado.
+var revertirScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  return {-1, 0}
+end
+local duenio = redis.call('HGET', KEYS[1], 'cliente')
+if ARGV[5] ~= '1' and (not duenio or duenio ~= ARGV[4]) then
+  return {-4, 0}
+end
+if redis.call('HEXISTS', KEYS[1], 'ajuste:' .. ARGV[1]) == 1 then
+  return {-2, 0}
+end
+local resto = tonumber(redis.call('HGET', KEYS[1], 'restante'))
+local cant = tonumber(ARGV[2])
+if cant == 0 then
+  cant = resto
+end
+if cant == 0 or cant > resto then
+  return {-3, resto}
+end
+redis.call('HSET', KEYS[1], 'ajuste:' .. ARGV[1], ARGV[3])
+return {cant, redis.call('HINCRBY', KEYS[1], 'restante', -cant)}
+`)
+
+// revertirCategoriaScript es la inversa de agregadosCategoriaScript. La venta
+// deja de contar en contador y suma_precio solo cuando se revierte completa
+// (ARGV[4] = 1); las unidades se descuentan siempre. KEYS[7] acumula los
+// ajustes de la categoria para las tasas de reembolso.
+var revertirCategoriaScript = redis.NewScript(`
+local n = tonumber(redis.call('GET', KEYS[1]) or '0')
+if ARGV[4] == '1' then
+  n = redis.call('DECR', KEYS[1])
+  redis.call('INCRBYFLOAT', KEYS[3], tostring(-tonumber(ARGV[2])))
+end
+local cant = redis.call('INCRBY', KEYS[2], -tonumber(ARGV[1]))
+local suma = tonumber(redis.call('GET', KEYS[3]) or '0')
+if n > 0 then
+  redis.call('SET', KEYS[4], tostring(cant / n), 'KEEPTTL')
+  redis.call('SET', KEYS[5], tostring(suma / n), 'KEEPTTL')
+else
+  redis.call('SET', KEYS[4], '0', 'KEEPTTL')
+  redis.call('SET', KEYS[5], '0', 'KEEPTTL')
+end
+if tonumber(redis.call('ZINCRBY', KEYS[6], -tonumber(ARGV[1]), ARGV[3])) <= 0 then
+  redis.call('ZREM', KEYS[6], ARGV[3])
+end
+redis.call('HINCRBY', KEYS[7], ARGV[5], 1)
+redis.call('HINCRBY', KEYS[7], 'unidades_' .. ARGV[5], ARGV[1])
+redis.call('HINCRBYFLOAT', KEYS[7], 'monto_' .. ARGV[5], ARGV[6])
+return n
+`)
+
+// revertirGlobalesScript es la inversa de agregadosGlobalesScript salvo por
+// los precios maximo y minimo, que no se pueden recalcular sin recorrer todas
+// las ventas y quedan como estaban.
+var revertirGlobalesScript = redis.NewScript(`
+if ARGV[3] == '1' then
+  redis.call('DECR', KEYS[1])
+end
+if tonumber(redis.call('ZINCRBY', KEYS[2], -tonumber(ARGV[1]), ARGV[2])) <= 0 then
+  redis.call('ZREM', KEYS[2], ARGV[2])
+end
+return 1
+`)
+
+// guardarOriginal deja la venta disponible para reembolsos durante
+// consumer.refund_window, con la identidad que la envio para que solo ella o
+// un administrador puedan revertirla.
+func (consumer *Consumer) guardarOriginal(ctx context.Context, message *sarama.ConsumerMessage, venta Venta, nombreCat string) {
+	ventana := consumer.cfg.Get().Consumer.RefundWindow
+	if ventana <= 0 || venta.Envelope == nil || venta.Envelope.EventID == "" {
+		return
+	}
+	campos := []any{
+		ventana.Milliseconds(),
+		"categoria", nombreCat,
+		"producto", venta.ProductoID,
+		"precio", strconv.FormatFloat(venta.Precio, 'f', -1, 64),
+		"cantidad", venta.CantidadVendida,
+		"restante", venta.CantidadVendida,
+	}
+	if id := headerDe(message, headerIdentidad); id != "" {
+		campos = append(campos, "cliente", id)
+	}
+	if cp := venta.Campana; cp != nil {
+		campos = append(campos,
+			"campana", cp.ID,
+			"precio_campana", strconv.FormatFloat(cp.PrecioEfectivo, 'f', -1, 64),
+			"anticipada", strconv.FormatBool(cp.AccesoAnticipado))
+	}
+	clave := consumer.claves.ventaOriginal(venta.Envelope.EventID)
+	if err := guardarOriginalScript.Run(ctx, consumer.rdb, []string{clave}, campos...).Err(); err != nil {
+		log.Printf("Error guardando la venta %s para reembolsos: %v", venta.Envelope.EventID, err)
+	}
+}
+
+// procesarAjuste aplica un reembolso o cancelacion: reserva las unidades
+// sobre la venta original y descuenta contadores, sumas, promedios, rankings
+// y la campana. Los ajustes que no se pueden aplicar se cuentan en
+// ajustes_rechazados. El sell-through no cambia: el stock lo lleva el bridge.
+func (consumer *Consumer) procesarAjuste(ctx context.Context, message *sarama.ConsumerMessage, aj Ajuste) {
+	defer consumer.auditar(ctx, message)
+	rdb, claves := consumer.rdb, consumer.claves
+	tipo := aj.Tipo
+	if tipo != "reembolso" && tipo != "cancelacion" {
+		log.Printf("Ajuste de tipo desconocido %q para %s", tipo, aj.EventIDOriginal)
+		return
+	}
+	idAjuste := idAuditoria(message)
+	if aj.Envelope != nil && aj.Envelope.EventID != "" {
+		idAjuste = strings.ToLower(aj.Envelope.EventID)
+	}
+
+	identidad := headerDe(message, headerIdentidad)
+	admin := "0"
+	if slices.Contains(consumer.cfg.Get().Auth.AdminIdentities, identidad) {
+		admin = "1"
+	}
+	clave := claves.ventaOriginal(aj.EventIDOriginal)
+	res, err := revertirScript.Run(ctx, rdb, []string{clave}, idAjuste, aj.Cantidad, tipo, identidad, admin).Int64Slice()
+	if err != nil {
+		log.Printf("Error aplicando %s de %s: %v", tipo, aj.EventIDOriginal, err)
+		return
+	}
+	cant, restante := res[0], res[1]
+	if cant < 0 {
+		motivo := map[int64]string{-1: "sin_original", -2: "duplicado", -3: "excede", -4: "otro_cliente"}[cant]
+		log.Printf("%s de %s rechazado: %s", tipo, aj.EventIDOriginal, motivo)
+		if err := rdb.HIncrBy(ctx, claves.global("ajustes_rechazados"), motivo, 1).Err(); err != nil {
+			log.Printf("Error contando ajuste rechazado: %v", err)
+		}
+		return
+	}
+
+	orig, err := rdb.HGetAll(ctx, clave).Result()
+	if err != nil {
+		log.Printf("Error leyendo la venta %s: %v", aj.EventIDOriginal, err)
+		return
+	}
+	completa := "0"
+	if restante == 0 {
+		completa = "1"
+	}
+	cat, producto := orig["categoria"], orig["producto"]
+	precio, _ := strconv.ParseFloat(orig["precio"], 64)
+	monto := strconv.FormatFloat(precio*float64(cant), 'f', -1, 64)
+
+	err = revertirCategoriaScript.Run(ctx, rdb, []string{
+		claves.categoria("contador", cat),
+		claves.categoria("suma_cantidad", cat),
+		claves.categoria("suma_precio", cat),
+		claves.categoria("promedio_productos", cat),
+		claves.categoria("promedio_precio_tag", cat),
+		claves.categoria("ranking_productos_cat", cat),
+		claves.categoria("ajustes", cat),
+	}, cant, orig["precio"], producto, completa, tipo, monto).Err()
+	if err != nil {
+		log.Printf("Error revirtiendo %s: %v", cat, err)
+	}
+	err = revertirGlobalesScript.Run(ctx, rdb, []string{
+		claves.global("total_ventas"),
+		claves.global("ranking_productos"),
+	}, cant, producto, completa).Err()
+	if err != nil {
+		log.Printf("Error revirtiendo globales: %v", err)
+	}
+	if id := orig["campana"]; id != "" {
+		pc, _ := strconv.ParseFloat(orig["precio_campana"], 64)
+		_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HIncrBy(ctx, claves.global("campana_unidades"), id, -cant)
+			pipe.HIncrByFloat(ctx, claves.global("campana_ingresos"), id, -pc*float64(cant))
+			if completa == "1" {
+				pipe.HIncrBy(ctx, claves.global("campana_ventas"), id, -1)
+				if orig["anticipada"] == "true" {
+					pipe.HIncrBy(ctx, claves.global("campana_anticipadas"), id, -1)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error revirtiendo campana %s: %v", id, err)
+		}
+	}
+}
+
+// tasasAjustes resume los ajustes de una categoria. Las unidades vendidas son
+// las brutas: las que quedan en suma_cantidad mas las revertidas.
+type tasasAjustes struct {
+	Reembolsos           int64   `json:"reembolsos"`
+	Cancelaciones        int64   `json:"cancelaciones"`
+	UnidadesReembolsadas int64   `json:"unidades_reembolsadas"`
+	UnidadesCanceladas   int64   `json:"unidades_canceladas"`
+	MontoReembolsado     float64 `json:"monto_reembolsado"`
+	MontoCancelado       float64 `json:"monto_cancelado"`
+	UnidadesVendidas     int64   `json:"unidades_vendidas"`
+	TasaReembolso        float64 `json:"tasa_reembolso"`
+	TasaCancelacion      float64 `json:"tasa_cancelacion"`
+}
+
+func nuevasTasas(h map[string]string, netas int64) tasasAjustes {
+	entero := func(k string) int64 { n, _ := strconv.ParseInt(h[k], 10, 64); return n }
+	real := func(k string) float64 { f, _ := strconv.ParseFloat(h[k], 64); return f }
+	t := tasasAjustes{
+		Reembolsos:           entero("reembolso"),
+		Cancelaciones:        entero("cancelacion"),
+		UnidadesReembolsadas: entero("unidades_reembolso"),
+		UnidadesCanceladas:   entero("unidades_cancelacion"),
+		MontoReembolsado:     real("monto_reembolso"),
+		MontoCancelado:       real("monto_cancelacion"),
+	}
+	t.UnidadesVendidas = netas + t.UnidadesReembolsadas + t.UnidadesCanceladas
+	if t.UnidadesVendidas > 0 {
+		t.TasaReembolso = float64(t.UnidadesReembolsadas) / float64(t.UnidadesVendidas)
+		t.TasaCancelacion = float64(t.UnidadesCanceladas) / float64(t.UnidadesVendidas)
+	}
+	return t
+}
+
+// ventaRevertible sigue, en reconcile, cuanto queda de cada venta con las
+// mismas reglas que revertirScript, para saber cuales ya no cuentan en
+// contador.
+type ventaRevertible struct {
+	categoria string
+	cliente   string
+	restante  int32
+	kafka     time.Time
+}
+
+type seguimientoAjustes struct {
+	ventana   time.Duration
+	admins    []string
+	ventas    map[string]*ventaRevertible
+	aplicados map[string]bool
+	// revertidas por categoria; sinOriginal son los ajustes cuya venta no
+	// esta en el rango leido.
+	revertidas  map[string]int64
+	sinOriginal int64
+}
+
+// nuevoSeguimientoAjustes usa la misma consumer.refund_window que el consumer:
+// un ajuste que llega despues ya no encuentra la venta. admins son las
+// identidades que pueden revertir ventas de otros.
+func nuevoSeguimientoAjustes(ventana time.Duration, admins []string) *seguimientoAjustes {
+	return &seguimientoAjustes{ventana: ventana, admins: admins, ventas: map[string]*ventaRevertible{}, aplicados: map[string]bool{}, revertidas: map[string]int64{}}
+}
+
+func (s *seguimientoAjustes) venta(m *sarama.ConsumerMessage, v Venta, cat string) {
+	if s.ventana <= 0 || v.Envelope == nil || v.Envelope.EventID == "" {
+		return
+	}
+	id := strings.ToLower(v.Envelope.EventID)
+	if _, ok := s.ventas[id]; !ok {
+		s.ventas[id] = &ventaRevertible{categoria: cat, cliente: headerDe(m, headerIdentidad), restante: v.CantidadVendida, kafka: m.Timestamp}
+	}
+}
+
+func (s *seguimientoAjustes) ajuste(m *sarama.ConsumerMessage, aj Ajuste) {
+	if s.ventana <= 0 {
+		return
+	}
+	v, ok := s.ventas[strings.ToLower(aj.EventIDOriginal)]
+	if !ok {
+		s.sinOriginal++
+		return
+	}
+	if m.Timestamp.Sub(v.kafka) > s.ventana {
+		return
+	}
+	if quien := headerDe(m, headerIdentidad); v.cliente != "" && quien != v.cliente && !slices.Contains(s.admins, quien) {
+		return
+	}
+	id := idAuditoria(m)
+	if aj.Envelope != nil && aj.Envelope.EventID != "" {
+		id = strings.ToLower(aj.Envelope.EventID)
+	}
+	if s.aplicados[id] {
+		return
+	}
+	cant := aj.Cantidad
+	if cant == 0 {
+		cant = v.restante
+	}
+	if cant == 0 || cant > v.restante {
+		return
+	}
+	s.aplicados[id] = true
+	v.restante -= cant
+	if v.restante == 0 {
+		s.revertidas[v.categoria]++
+	}
+}
+
+func headerDe(m *sarama.ConsumerMessage, clave string) string {
+	for _, h := range m.Headers {
+		if strings.EqualFold(string(h.Key), clave) {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
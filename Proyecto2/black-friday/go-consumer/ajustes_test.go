@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRevertirScript(t *testing.T) {
+	casos := []struct {
+		nombre    string
+		sinVenta  bool
+		cliente   string // vacio: la venta llego sin identidad
+		cantidad  int32
+		identidad string
+		admin     bool
+		unidades  int64
+		restante  int64
+	}{
+		{nombre: "reembolso parcial del cliente", cliente: "tienda-1", cantidad: 2, identidad: "tienda-1", unidades: 2, restante: 3},
+		{nombre: "cantidad 0 revierte todo", cliente: "tienda-1", identidad: "tienda-1", unidades: 5, restante: 0},
+		{nombre: "venta que no esta", sinVenta: true, cantidad: 1, identidad: "ops", admin: true, unidades: -1},
+		{nombre: "excede lo que queda", cliente: "tienda-1", cantidad: 6, identidad: "tienda-1", unidades: -3, restante: 5},
+		{nombre: "otro cliente", cliente: "tienda-1", cantidad: 1, identidad: "tienda-2", unidades: -4},
+		{nombre: "administrador sobre otro cliente", cliente: "tienda-1", cantidad: 1, identidad: "ops", admin: true, unidades: 1, restante: 4},
+		{nombre: "venta sin cliente", cantidad: 1, identidad: "tienda-2", unidades: -4},
+		{nombre: "venta sin cliente ni identidad", cantidad: 1, unidades: -4},
+		{nombre: "administrador sobre venta sin cliente", cantidad: 1, identidad: "ops", admin: true, unidades: 1, restante: 4},
+	}
+	ctx := context.Background()
+	_, rdb := nuevoValkey(t)
+	k := NewClaves(false, "")
+	for i, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			clave := k.ventaOriginal(fmt.Sprintf("venta-%d", i))
+			if !c.sinVenta {
+				campos := []any{time.Hour.Milliseconds(), "producto", "P-1", "cantidad", 5, "restante", 5}
+				if c.cliente != "" {
+					campos = append(campos, "cliente", c.cliente)
+				}
+				if err := guardarOriginalScript.Run(ctx, rdb, []string{clave}, campos...).Err(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			admin := "0"
+			if c.admin {
+				admin = "1"
+			}
+			revertir := func() []int64 {
+				t.Helper()
+				res, err := revertirScript.Run(ctx, rdb, []string{clave}, "aj-1", c.cantidad, "reembolso", c.identidad, admin).Int64Slice()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return res
+			}
+			res := revertir()
+			if res[0] != c.unidades || ((c.unidades > 0 || c.unidades == -3) && res[1] != c.restante) {
+				t.Fatalf("{%d, %d}, se esperaba {%d, %d}", res[0], res[1], c.unidades, c.restante)
+			}
+			// Un reintento con el mismo id solo es duplicado si el ajuste se
+			// aplico.
+			if c.unidades > 0 {
+				if res := revertir(); res[0] != -2 {
+					t.Errorf("reintento: {%d, %d}, se esperaba duplicado", res[0], res[1])
+				}
+			}
+		})
+	}
+
+	// guardarOriginal no pisa una venta ya guardada.
+	clave := k.ventaOriginal("venta-0")
+	if n, err := guardarOriginalScript.Run(ctx, rdb, []string{clave}, time.Hour.Milliseconds(), "restante", 5).Int(); err != nil || n != 0 {
+		t.Errorf("guardar de nuevo: %d, %v", n, err)
+	}
+	if r, _ := rdb.HGet(ctx, clave, "restante").Result(); r != "3" {
+		t.Errorf("restante = %s despues de volver a guardar", r)
+	}
+}
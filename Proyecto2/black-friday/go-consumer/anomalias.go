@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/v9"
+	"go-common/config"
+)
+
+// Tipos de anomalia.
+const (
+	anomaliaPicoPrecio      = "pico_precio"
+	anomaliaCaidaPrecio     = "caida_precio"
+	anomaliaPicoVentas      = "pico_ventas"
+	anomaliaVentasDetenidas = "ventas_detenidas"
+)
+
+// pisoPrecio es la desviacion minima del precio, como fraccion de la media;
+// sin ella un precio que nunca cambio haria anomalo cualquier centavo.
+const pisoPrecio = 0.01
+
+// olvidoIntervalos es cuantos intervalos sin ventas se sigue un producto antes
+// de descartar sus series.
+const olvidoIntervalos = 100
+
+type observacion struct {
+	T     time.Time `json:"t"`
+	Valor float64   `json:"valor"`
+}
+
+// Anomalia es lo que se publica en el topic de alertas. Evidencia son las
+// ultimas observaciones de la serie, incluida la anomala.
+type Anomalia struct {
+	Tipo       string        `json:"tipo"`
+	Ambito     string        `json:"ambito"`
+	Clave      string        `json:"clave"`
+	Categoria  string        `json:"categoria"`
+	Valor      float64       `json:"valor"`
+	Media      float64       `json:"media"`
+	Desviacion float64       `json:"desviacion"`
+	Z          float64       `json:"z"`
+	Umbral     float64       `json:"umbral"`
+	Detectada  time.Time     `json:"detectada"`
+	Evidencia  []observacion `json:"evidencia"`
+}
+
+// serie es una media y varianza con promedio movil exponencial.
+type serie struct {
+	n        int
+	media    float64
+	varianza float64
+	ventana  []observacion
+	visto    time.Time
+}
+
+// observar devuelve la media y la desviacion previas a x y despues incorpora
+// x. La observacion anomala tambien entra: si el cambio persiste pasa a ser
+// la nueva normalidad.
+func (s *serie) observar(o observacion, alpha float64, largo int) (media, desv float64, n int) {
+	media, desv, n = s.media, math.Sqrt(s.varianza), s.n
+	if s.n == 0 {
+		s.media = o.Valor
+	} else {
+		d := o.Valor - s.media
+		inc := alpha * d
+		s.media += inc
+		s.varianza = (1 - alpha) * (s.varianza + d*inc)
+	}
+	s.n++
+	s.ventana = append(s.ventana, o)
+	if len(s.ventana) > largo {
+		s.ventana = s.ventana[len(s.ventana)-largo:]
+	}
+	return media, desv, n
+}
+
+// Detector sigue las series de la replica. Con varias replicas cada una ve
+// una parte de las ventas (el writer reparte por event_id), asi que las tasas
+// son proporcionales a las totales y los desvios siguen siendo comparables.
+type Detector struct {
+	cfg    *config.Loader
+	salida chan Anomalia
+
+	mu           sync.Mutex
+	precios      map[string]*serie
+	ventasProd   map[string]*serie
+	ventasCat    map[string]*serie
+	pendProd     map[string]float64
+	pendCat      map[string]float64
+	catProducto  map[string]string
+	enfriamiento map[string]time.Time
+}
+
+func NewDetector(loader *config.Loader) *Detector {
+	return &Detector{
+		cfg:          loader,
+		salida:       make(chan Anomalia, 1000),
+		precios:      map[string]*serie{},
+		ventasProd:   map[string]*serie{},
+		ventasCat:    map[string]*serie{},
+		pendProd:     map[string]float64{},
+		pendCat:      map[string]float64{},
+		catProducto:  map[string]string{},
+		enfriamiento: map[string]time.Time{},
+	}
+}
+
+// Observar registra una venta: evalua el precio en el momento y suma las
+// unidades al intervalo en curso.
+func (d *Detector) Observar(cat string, venta Venta, t time.Time) {
+	cfg := d.cfg.Get().Anomalies
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.precios[venta.ProductoID]
+	if s == nil {
+		s = &serie{}
+		d.precios[venta.ProductoID] = s
+	}
+	s.visto = t
+	o := observacion{T: t, Valor: venta.Precio}
+	media, desv, n := s.observar(o, cfg.Alpha, cfg.Window)
+	if n >= cfg.MinSamples {
+		desv = math.Max(desv, media*pisoPrecio)
+		z := (venta.Precio - media) / desv
+		switch {
+		case z > cfg.Sensitivity:
+			d.emitir(anomaliaPicoPrecio, "producto", venta.ProductoID, cat, o, media, desv, z, s, t)
+		case z < -cfg.Sensitivity:
+			d.emitir(anomaliaCaidaPrecio, "producto", venta.ProductoID, cat, o, media, desv, z, s, t)
+		}
+	}
+	d.pendProd[venta.ProductoID] += float64(venta.CantidadVendida)
+	d.pendCat[cat] += float64(venta.CantidadVendida)
+	d.catProducto[venta.ProductoID] = cat
+}
+
+// Correr cierra un intervalo cada anomalies.interval y evalua las unidades
+// vendidas de cada producto y categoria.
+func (d *Detector) Correr(ctx context.Context, intervalo time.Duration) {
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			d.cerrarIntervalo(t, intervalo)
+		}
+	}
+}
+
+func (d *Detector) cerrarIntervalo(t time.Time, intervalo time.Duration) {
+	cfg := d.cfg.Get().Anomalies
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	evaluar := func(series map[string]*serie, pend map[string]float64, ambito string, cat func(string) string) {
+		for k := range pend {
+			if series[k] == nil {
+				series[k] = &serie{}
+			}
+		}
+		for k, s := range series {
+			x := pend[k]
+			if x > 0 {
+				s.visto = t
+			}
+			o := observacion{T: t, Valor: x}
+			media, desv, n := s.observar(o, cfg.Alpha, cfg.Window)
+			if n < cfg.MinSamples {
+				continue
+			}
+			// Las unidades son un conteo: la desviacion no baja de la de una
+			// Poisson con esa media, y una serie casi sin ventas no dispara
+			// por una venta suelta.
+			desv = math.Max(desv, math.Sqrt(math.Max(media, cfg.MinRate)))
+			z := (x - media) / desv
+			switch {
+			case z > cfg.Sensitivity:
+				d.emitir(anomaliaPicoVentas, ambito, k, cat(k), o, media, desv, z, s, t)
+			case z < -cfg.Sensitivity && media >= cfg.MinRate:
+				d.emitir(anomaliaVentasDetenidas, ambito, k, cat(k), o, media, desv, z, s, t)
+			}
+		}
+	}
+	evaluar(d.ventasProd, d.pendProd, "producto", func(p string) string { return d.catProducto[p] })
+	evaluar(d.ventasCat, d.pendCat, "categoria", func(c string) string { return c })
+	d.pendProd, d.pendCat = map[string]float64{}, map[string]float64{}
+
+	limite := t.Add(-olvidoIntervalos * intervalo)
+	for p, s := range d.ventasProd {
+		if s.visto.Before(limite) {
+			delete(d.ventasProd, p)
+			delete(d.precios, p)
+			delete(d.catProducto, p)
+		}
+	}
+	for k, hasta := range d.enfriamiento {
+		if t.After(hasta) {
+			delete(d.enfriamiento, k)
+		}
+	}
+}
+
+// emitir se llama con d.mu tomado.
+func (d *Detector) emitir(tipo, ambito, clave, cat string, o observacion, media, desv, z float64, s *serie, t time.Time) {
+	cfg := d.cfg.Get().Anomalies
+	id := tipo + "|" + ambito + "|" + clave
+	if hasta, ok := d.enfriamiento[id]; ok && t.Before(hasta) {
+		return
+	}
+	d.enfriamiento[id] = t.Add(cfg.Cooldown)
+	a := Anomalia{
+		Tipo:       tipo,
+		Ambito:     ambito,
+		Clave:      clave,
+		Categoria:  cat,
+		Valor:      o.Valor,
+		Media:      media,
+		Desviacion: desv,
+		Z:          z,
+		Umbral:     cfg.Sensitivity,
+		Detectada:  t,
+		Evidencia:  append([]observacion(nil), s.ventana...),
+	}
+	select {
+	case d.salida <- a:
+	default:
+		log.Printf("Anomalias: cola llena, se descarta %s de %s", tipo, clave)
+	}
+}
+
+// Publicar envia las anomalias a Kafka y al stream alertas de Valkey.
+func (d *Detector) Publicar(ctx context.Context, producer sarama.SyncProducer, rdb redis.UniversalClient, claves Claves) {
+	for {
+		var a Anomalia
+		select {
+		case <-ctx.Done():
+			return
+		case a = <-d.salida:
+		}
+		cfg := d.cfg.Get()
+		log.Printf("Anomalia %s en %s %s: %.2f (media %.2f, z %.1f)", a.Tipo, a.Ambito, a.Clave, a.Valor, a.Media, a.Z)
+		valor, err := json.Marshal(a)
+		if err != nil {
+			continue
+		}
+		_, _, err = producer.SendMessage(&sarama.ProducerMessage{
+			Topic: cfg.Anomalies.Topic,
+			Key:   sarama.StringEncoder(a.Ambito + ":" + a.Clave),
+			Value: sarama.ByteEncoder(valor),
+		})
+		if err != nil {
+			log.Printf("Anomalias: no se pudo publicar en Kafka: %v", err)
+		}
+		evidencia, _ := json.Marshal(a.Evidencia)
+		err = rdb.XAdd(ctx, &redis.XAddArgs{
+			Stream: claves.global("alertas"),
+			MaxLen: cfg.Valkey.StreamMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{
+				"tipo":       a.Tipo,
+				"ambito":     a.Ambito,
+				"clave":      a.Clave,
+				"categoria":  a.Categoria,
+				"valor":      a.Valor,
+				"media":      a.Media,
+				"desviacion": a.Desviacion,
+				"z":          strconv.FormatFloat(a.Z, 'f', 2, 64),
+				"evidencia":  string(evidencia),
+			},
+		}).Err()
+		if err != nil {
+			log.Printf("Anomalias: no se pudo guardar en Valkey: %v", err)
+		}
+	}
+}
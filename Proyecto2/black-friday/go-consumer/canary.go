@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go-common/config"
+)
+
+// ventanaCanary es cuantos resultados recientes entran en la tasa de exito
+// reciente.
+const ventanaCanary = 100
+
+type resultadoCanary struct {
+	ID         string    `json:"id"`
+	Enviado    time.Time `json:"enviado"`
+	Exito      bool      `json:"exito"`
+	LatenciaMs float64   `json:"latencia_ms"`
+	Motivo     string    `json:"motivo,omitempty"`
+}
+
+// Canary envia ventas sinteticas al bridge y mide cuanto tardan en quedar
+// confirmadas en Valkey por el consumer.
+type Canary struct {
+	rdb    redis.UniversalClient
+	claves Claves
+	cfg    *config.Loader
+	http   *http.Client
+
+	mu        sync.Mutex
+	total     int64
+	exitos    int64
+	fallos    map[string]int64
+	recientes []bool
+	latencia  *Histograma
+	ultimo    *resultadoCanary
+}
+
+func NewCanary(rdb redis.UniversalClient, claves Claves, loader *config.Loader) *Canary {
+	return &Canary{
+		rdb:      rdb,
+		claves:   claves,
+		cfg:      loader,
+		http:     &http.Client{},
+		fallos:   map[string]int64{},
+		latencia: nuevoHistograma(),
+	}
+}
+
+func (c *Canary) Correr(ctx context.Context) {
+	for {
+		r := c.probar(ctx)
+		c.registrar(ctx, r)
+		if !r.Exito {
+			log.Printf("Canary %s fallo: %s", r.ID, r.Motivo)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.cfg.Get().Canary.Interval):
+		}
+	}
+}
+
+// probar envia una venta marcada como canary y consulta Valkey hasta verla
+// confirmada o hasta que vence el SLO.
+func (c *Canary) probar(ctx context.Context) resultadoCanary {
+	cfg := c.cfg.Get().Canary
+	inicio := time.Now()
+	r := resultadoCanary{ID: "canary-" + strconv.FormatInt(inicio.UnixNano(), 10), Enviado: inicio}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.SLO)
+	defer cancel()
+	body, _ := json.Marshal(Venta{
+		Categoria:       cfg.Categoria,
+		ProductoID:      r.ID,
+		Precio:          1,
+		CantidadVendida: 1,
+		Canary:          true,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.BridgeURL, bytes.NewReader(body))
+	if err != nil {
+		r.Motivo = err.Error()
+		return r
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.APIKey != "" {
+		req.Header.Set("X-API-Key", cfg.APIKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		r.Motivo = "bridge: " + err.Error()
+		return r
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		r.Motivo = fmt.Sprintf("bridge: HTTP %d", resp.StatusCode)
+		return r
+	}
+
+	clave := c.claves.canaryVisto(r.ID)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		n, err := c.rdb.Exists(ctx, clave).Result()
+		if err == nil && n > 0 {
+			r.Exito = true
+			r.LatenciaMs = float64(time.Since(inicio)) / float64(time.Millisecond)
+			return r
+		}
+		select {
+		case <-ctx.Done():
+			r.Motivo = "slo: no aparecio en Valkey en " + cfg.SLO.String()
+			if err != nil {
+				r.Motivo = "valkey: " + err.Error()
+			}
+			return r
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Canary) registrar(ctx context.Context, r resultadoCanary) {
+	c.mu.Lock()
+	c.total++
+	if r.Exito {
+		c.exitos++
+		c.latencia.observar(r.LatenciaMs)
+	} else {
+		c.fallos[r.Motivo]++
+	}
+	c.recientes = append(c.recientes, r.Exito)
+	if len(c.recientes) > ventanaCanary {
+		c.recientes = c.recientes[1:]
+	}
+	c.ultimo = &r
+	c.mu.Unlock()
+
+	// El historial queda en Valkey para graficarlo en Grafana.
+	err := c.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: c.claves.global("canary_resultados"),
+		MaxLen: 10000,
+		Approx: true,
+		Values: map[string]interface{}{
+			"id":          r.ID,
+			"exito":       strconv.FormatBool(r.Exito),
+			"latencia_ms": r.LatenciaMs,
+			"motivo":      r.Motivo,
+		},
+	}).Err()
+	if err != nil {
+		log.Printf("Canary: no se pudo guardar el resultado: %v", err)
+	}
+}
+
+type EstadoCanary struct {
+	Total             int64            `json:"total"`
+	Exitos            int64            `json:"exitos"`
+	TasaExito         float64          `json:"tasa_exito"`
+	TasaExitoReciente float64          `json:"tasa_exito_reciente"`
+	Fallos            map[string]int64 `json:"fallos"`
+	Latencia          ResumenLatencia  `json:"latencia"`
+	Ultimo            *resultadoCanary `json:"ultimo"`
+}
+
+func (c *Canary) Estado() EstadoCanary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := EstadoCanary{
+		Total:    c.total,
+		Exitos:   c.exitos,
+		Fallos:   map[string]int64{},
+		Latencia: c.latencia.resumen(),
+		Ultimo:   c.ultimo,
+	}
+	for k, v := range c.fallos {
+		e.Fallos[k] = v
+	}
+	if c.total > 0 {
+		e.TasaExito = float64(c.exitos) / float64(c.total)
+	}
+	if len(c.recientes) > 0 {
+		var ok int
+		for _, b := range c.recientes {
+			if b {
+				ok++
+			}
+		}
+		e.TasaExitoReciente = float64(ok) / float64(len(c.recientes))
+	}
+	return e
+}
+
+// comandoCanary corre el canary hasta recibir SIGTERM; se despliega con la
+// imagen del consumer y command ["./main", "canary"].
+func comandoCanary(ctx context.Context, rdb redis.UniversalClient, claves Claves, loader *config.Loader) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go loader.Watch(ctx, 5*time.Second)
+
+	canary := NewCanary(rdb, claves, loader)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /canary", func(w http.ResponseWriter, r *http.Request) {
+		responderJSON(w, http.StatusOK, canary.Estado())
+	})
+	addr := loader.Get().Canary.Listen
+	go func() {
+		log.Printf("Canary API en %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Error en canary API: %v", err)
+		}
+	}()
+	go canary.Correr(ctx)
+
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
+	<-sigterm
+	return 0
+}
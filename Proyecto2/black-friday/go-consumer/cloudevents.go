@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// eventoCE son los atributos de CloudEvents 1.0 que usa el consumer, sea que
+// lleguen en headers ce_* (binary) o en el valor (structured).
+type eventoCE struct {
+	SpecVersion string          `json:"specversion"`
+	ID          string          `json:"id"`
+	Source      string          `json:"source"`
+	Type        string          `json:"type"`
+	Time        string          `json:"time"`
+	Tenant      string          `json:"tenant"`
+	IngestTime  string          `json:"ingesttime"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// Tipos de evento del topic de ventas. Los registros de writers anteriores no
+// traen tipo y son ventas.
+const (
+	tipoVenta       = "blackfriday.venta.v1"
+	tipoReembolso   = "blackfriday.reembolso.v1"
+	tipoCancelacion = "blackfriday.cancelacion.v1"
+
+	headerEventType = "event-type"
+)
+
+// leerEvento acepta los tres formatos que puede escribir el writer: JSON
+// plano, CloudEvents binary y CloudEvents structured. Devuelve los atributos
+// (SpecVersion vacio si no es CloudEvents), con Type completado desde el header
+// event-type, y los datos del evento.
+func leerEvento(m *sarama.ConsumerMessage) (eventoCE, []byte, error) {
+	var ce eventoCE
+	contentType, tipo := "", ""
+	for _, h := range m.Headers {
+		k, v := strings.ToLower(string(h.Key)), string(h.Value)
+		switch k {
+		case "content-type":
+			contentType = v
+		case headerEventType:
+			tipo = v
+		case "ce_specversion":
+			ce.SpecVersion = v
+		case "ce_id":
+			ce.ID = v
+		case "ce_source":
+			ce.Source = v
+		case "ce_type":
+			ce.Type = v
+		case "ce_time":
+			ce.Time = v
+		case "ce_tenant":
+			ce.Tenant = v
+		case "ce_ingesttime":
+			ce.IngestTime = v
+		}
+	}
+
+	datos := []byte(m.Value)
+	if strings.HasPrefix(contentType, "application/cloudevents+json") {
+		if err := json.Unmarshal(m.Value, &ce); err != nil {
+			return ce, nil, err
+		}
+		datos = ce.Data
+	}
+	if ce.SpecVersion != "" && ce.SpecVersion != "1.0" {
+		return ce, nil, fmt.Errorf("CloudEvents specversion %q no soportada", ce.SpecVersion)
+	}
+	if ce.Type == "" {
+		ce.Type = tipo
+	}
+	if ce.Type == "" {
+		ce.Type = tipoVenta
+	}
+	return ce, datos, nil
+}
+
+func esAjuste(tipo string) bool {
+	return tipo == tipoReembolso || tipo == tipoCancelacion
+}
+
+func ventaDeEvento(ce eventoCE, datos []byte) (Venta, error) {
+	var venta Venta
+	if err := json.Unmarshal(datos, &venta); err != nil {
+		return venta, err
+	}
+	// Un productor externo puede no incluir el sobre en los datos; se arma
+	// con los atributos del evento.
+	if venta.Envelope == nil && ce.SpecVersion != "" {
+		venta.Envelope = sobreDeCE(ce)
+	}
+	return venta, nil
+}
+
+func ajusteDeEvento(ce eventoCE, datos []byte) (Ajuste, error) {
+	var aj Ajuste
+	if err := json.Unmarshal(datos, &aj); err != nil {
+		return aj, err
+	}
+	if aj.Envelope == nil && ce.SpecVersion != "" {
+		aj.Envelope = sobreDeCE(ce)
+	}
+	return aj, nil
+}
+
+func sobreDeCE(ce eventoCE) *Sobre {
+	return &Sobre{
+		Version:    versionSobre,
+		EventID:    ce.ID,
+		EventTime:  nanosCE(ce.Time),
+		IngestTime: nanosCE(ce.IngestTime),
+		Source:     ce.Source,
+		Tenant:     ce.Tenant,
+	}
+}
+
+func nanosCE(s string) int64 {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0
+	}
+	return t.UnixNano()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go-common/config"
+)
+
+// ejecutarComando atiende los subcomandos de mantenimiento, p.ej.
+// "go-consumer -config cfg.yaml janitor -dry-run". Devuelve el codigo de
+// salida del proceso.
+func ejecutarComando(ctx context.Context, args []string, rdb redis.UniversalClient, claves Claves, loader *config.Loader) int {
+	cfg := loader.Get()
+	switch args[0] {
+	case "janitor":
+		return comandoJanitor(ctx, args[1:], rdb, claves, cfg)
+	case "namespaces":
+		return comandoNamespaces(ctx, args[1:], rdb, claves)
+	case "replay":
+		return comandoReplay(ctx, args[1:], rdb, claves, loader)
+	case "reconcile":
+		return comandoReconciliar(ctx, args[1:], rdb, claves, loader)
+	case "canary":
+		return comandoCanary(ctx, rdb, claves, loader)
+	case "webhook-stub":
+		return comandoWebhookStub(args[1:])
+	case "fraude":
+		return comandoFraude(ctx, args[1:], rdb, claves, cfg)
+	}
+	fmt.Fprintf(os.Stderr, "comando desconocido %q (janitor, namespaces, replay, reconcile, canary, webhook-stub, fraude)\n", args[0])
+	return 2
+}
+
+func comandoJanitor(ctx context.Context, args []string, rdb redis.UniversalClient, claves Claves, cfg *config.Config) int {
+	fs := flag.NewFlagSet("janitor", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "solo reporta lo que se expiraria o archivaria")
+	comoJSON := fs.Bool("json", false, "imprime el reporte en JSON")
+	fs.Parse(args)
+
+	j, err := NewJanitor(rdb, claves, cfg.Retention)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	rep, err := j.Ejecutar(ctx, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *comoJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+		return 0
+	}
+	imprimirReporte(rep)
+	return 0
+}
+
+func imprimirReporte(rep Reporte) {
+	if rep.DryRun {
+		fmt.Println("DRY-RUN: no se modifico ninguna clave")
+	}
+	if len(rep.Familias) == 0 {
+		fmt.Println("retention.policies esta vacio, no hay nada que aplicar")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FAMILIA\tPOLITICA\tCLAVES\tACCIONES")
+	for _, f := range rep.Familias {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\n", f.Familia, f.Politica, f.Claves, len(f.Acciones))
+	}
+	tw.Flush()
+	for _, f := range rep.Familias {
+		if len(f.Acciones) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s:\n", f.Familia)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  CLAVE\tTTL ACTUAL\tACCION")
+		for _, a := range f.Acciones {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", a.Clave, a.TTLActual, a.Accion)
+		}
+		tw.Flush()
+	}
+}
+
+// comandoWebhookStub levanta un receptor de webhooks que imprime cada aviso,
+// para probar alerting.webhooks sin un servicio real. Con -status se puede
+// simular un receptor caido y ver los reintentos.
+func comandoWebhookStub(args []string) int {
+	fs := flag.NewFlagSet("webhook-stub", flag.ExitOnError)
+	listen := fs.String("listen", ":9099", "direccion en la que escucha")
+	status := fs.Int("status", http.StatusOK, "codigo con el que responde")
+	fs.Parse(args)
+
+	http.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		var n notificacion
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			fmt.Printf("%s %s: body invalido: %v\n", time.Now().Format(time.RFC3339), r.URL.Path, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Printf("%s %s grupo=%s disparadas=%d resueltas=%d\n",
+			time.Now().Format(time.RFC3339), r.URL.Path, n.Grupo, n.Disparadas, n.Resueltas)
+		for _, a := range n.Alertas {
+			fmt.Println("  " + a.Mensaje)
+		}
+		w.WriteHeader(*status)
+	})
+	fmt.Printf("webhook-stub escuchando en %s\n", *listen)
+	if err := http.ListenAndServe(*listen, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
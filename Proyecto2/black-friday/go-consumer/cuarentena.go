@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/v9"
+	"go-common/config"
+)
+
+// Retenida es una venta en cuarentena, guardada en el hash fraude_cuarentena
+// con el registro original para poder volver a publicarla. Sale del hash al
+// decidirse; fraude_registros conserva su particion:offset para reconcile y
+// replay.
+type Retenida struct {
+	EventID    string           `json:"event_id"`
+	ProductoID string           `json:"producto_id"`
+	Categoria  string           `json:"categoria"`
+	Cliente    string           `json:"cliente,omitempty"`
+	Puntaje    float64          `json:"puntaje"`
+	Motivos    []Motivo         `json:"motivos"`
+	Hora       time.Time        `json:"hora"`
+	Particion  int32            `json:"particion"`
+	Offset     int64            `json:"offset"`
+	Clave      []byte           `json:"clave,omitempty"`
+	Valor      []byte           `json:"valor,omitempty"`
+	Headers    []headerRetenido `json:"headers,omitempty"`
+}
+
+type headerRetenido struct {
+	Clave string `json:"clave"`
+	Valor []byte `json:"valor"`
+}
+
+// Decision es lo que resolvio el revisor. Queda en el stream
+// fraude_decisiones, acotado a fraud.decisions_max_len, con lo necesario
+// para auditarla pero sin el registro original.
+type Decision struct {
+	EventID    string    `json:"event_id"`
+	Estado     string    `json:"estado"`
+	Hora       time.Time `json:"hora"`
+	Motivo     string    `json:"motivo,omitempty"`
+	ProductoID string    `json:"producto_id"`
+	Cliente    string    `json:"cliente,omitempty"`
+	Puntaje    float64   `json:"puntaje"`
+}
+
+const (
+	decisionLiberada  = "liberada"
+	decisionRechazada = "rechazada"
+)
+
+// reclamarScript saca la venta de la cuarentena y la devuelve, o nil si no
+// esta. Dos revisores no pueden decidir la misma venta: solo uno la obtiene.
+var reclamarScript = redis.NewScript(`
+local v = redis.call('HGET', KEYS[1], ARGV[1])
+if v then
+  redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return v
+`)
+
+// leerCuarentena devuelve una pagina de hasta n ventas pendientes, ordenada
+// por hora, y el cursor de la siguiente; 0 si no hay mas. Con HSCAN el
+// tamano de la pagina es aproximado.
+func leerCuarentena(ctx context.Context, rdb redis.Cmdable, k Claves, cursor uint64, n int64) ([]Retenida, uint64, error) {
+	crudos, siguiente, err := rdb.HScan(ctx, k.global("fraude_cuarentena"), cursor, "", n).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	var lista []Retenida
+	for i := 1; i < len(crudos); i += 2 {
+		var r Retenida
+		if err := json.Unmarshal([]byte(crudos[i]), &r); err != nil {
+			continue
+		}
+		lista = append(lista, r)
+	}
+	sort.Slice(lista, func(i, j int) bool { return lista[i].Hora.Before(lista[j].Hora) })
+	return lista, siguiente, nil
+}
+
+// leerDecisiones devuelve las n decisiones mas recientes, la ultima primero.
+func leerDecisiones(ctx context.Context, rdb redis.Cmdable, k Claves, n int64) ([]Decision, error) {
+	entradas, err := rdb.XRevRangeN(ctx, k.global("fraude_decisiones"), "+", "-", n).Result()
+	if err != nil {
+		return nil, err
+	}
+	lista := make([]Decision, 0, len(entradas))
+	for _, e := range entradas {
+		var d Decision
+		if crudo, ok := e.Values["decision"].(string); ok && json.Unmarshal([]byte(crudo), &d) == nil {
+			lista = append(lista, d)
+		}
+	}
+	return lista, nil
+}
+
+// decidir saca la venta de la cuarentena, registra la decision y, si es
+// liberar, vuelve a publicar la venta en el topic de ventas con el header
+// fraude-revision para que no se evalue de nuevo.
+func decidir(ctx context.Context, rdb redis.UniversalClient, k Claves, producer sarama.SyncProducer, cfg *config.Config, id string, d Decision) error {
+	claveCuarentena := k.global("fraude_cuarentena")
+	crudo, err := reclamarScript.Run(ctx, rdb, []string{claveCuarentena}, id).Text()
+	if errors.Is(err, redis.Nil) {
+		return fmt.Errorf("%s no esta en cuarentena o ya se decidio", id)
+	}
+	if err != nil {
+		return err
+	}
+	var r Retenida
+	if err := json.Unmarshal([]byte(crudo), &r); err != nil {
+		return err
+	}
+
+	if d.Estado == decisionLiberada {
+		msg := &sarama.ProducerMessage{
+			Topic: cfg.Kafka.Topic,
+			Key:   sarama.ByteEncoder(r.Clave),
+			Value: sarama.ByteEncoder(r.Valor),
+		}
+		for _, h := range r.Headers {
+			msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(h.Clave), Value: h.Valor})
+		}
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(headerRevision), Value: []byte(revisionLiberada)})
+		if _, _, err := producer.SendMessage(msg); err != nil {
+			// Sin la venta publicada la decision no vale; vuelve a la
+			// cuarentena para reintentar.
+			rdb.HSetNX(ctx, claveCuarentena, id, crudo)
+			return fmt.Errorf("publicando %s: %w", id, err)
+		}
+	}
+	d.EventID, d.ProductoID, d.Cliente, d.Puntaje = id, r.ProductoID, r.Cliente, r.Puntaje
+	datos, _ := json.Marshal(d)
+	campo := "liberadas"
+	if d.Estado == decisionRechazada {
+		campo = "rechazadas"
+	}
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: k.global("fraude_decisiones"),
+			MaxLen: cfg.Fraud.DecisionsMaxLen,
+			Approx: true,
+			Values: []any{"decision", datos},
+		})
+		pipe.HIncrBy(ctx, k.global("fraude"), campo, 1)
+		return nil
+	})
+	return err
+}
+
+// retenidasPorRegistro devuelve particion:offset de las ventas que quedaron
+// en cuarentena durante fraud.record_retention, decididas o no, para que
+// reconcile y replay no las cuenten. Una venta liberada vuelve al topic como
+// un registro nuevo y se cuenta ahi.
+func retenidasPorRegistro(ctx context.Context, rdb redis.UniversalClient, k Claves, cfg config.Fraud) (map[string]bool, error) {
+	desde := time.Now().Add(-cfg.RecordRetention).UnixMilli()
+	miembros, err := rdb.ZRangeByScore(ctx, k.global("fraude_registros"), &redis.ZRangeBy{
+		Min: strconv.FormatInt(desde, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(miembros))
+	for _, m := range miembros {
+		ids[m] = true
+	}
+	return ids, nil
+}
+
+// comandoFraude revisa la cuarentena:
+//
+//	go-consumer fraude pendientes [-json]
+//	go-consumer fraude decisiones [-n 50] [-json]
+//	go-consumer fraude liberar <event_id>...
+//	go-consumer fraude rechazar [-motivo texto] <event_id>...
+func comandoFraude(ctx context.Context, args []string, rdb redis.UniversalClient, claves Claves, cfg *config.Config) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "uso: fraude pendientes|decisiones|liberar|rechazar")
+		return 2
+	}
+	fs := flag.NewFlagSet("fraude "+args[0], flag.ExitOnError)
+	switch args[0] {
+	case "pendientes":
+		comoJSON := fs.Bool("json", false, "imprime la lista en JSON")
+		fs.Parse(args[1:])
+		var lista []Retenida
+		for cursor := uint64(0); ; {
+			pagina, siguiente, err := leerCuarentena(ctx, rdb, claves, cursor, 500)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			for _, r := range pagina {
+				r.Clave, r.Valor, r.Headers = nil, nil, nil
+				lista = append(lista, r)
+			}
+			if cursor = siguiente; cursor == 0 {
+				break
+			}
+		}
+		sort.Slice(lista, func(i, j int) bool { return lista[i].Hora.Before(lista[j].Hora) })
+		if *comoJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(lista)
+			return 0
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "EVENT_ID\tPRODUCTO\tCLIENTE\tPUNTAJE\tRETENIDA\tMOTIVOS\t")
+		for _, r := range lista {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%.1f\t%s\t%s\t\n", r.EventID, r.ProductoID, r.Cliente,
+				r.Puntaje, r.Hora.Format(time.RFC3339), resumirMotivos(r.Motivos))
+		}
+		tw.Flush()
+		return 0
+	case "decisiones":
+		n := fs.Int64("n", 50, "cuantas decisiones mostrar, la mas reciente primero")
+		comoJSON := fs.Bool("json", false, "imprime la lista en JSON")
+		fs.Parse(args[1:])
+		lista, err := leerDecisiones(ctx, rdb, claves, *n)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if *comoJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(lista)
+			return 0
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "EVENT_ID\tPRODUCTO\tCLIENTE\tPUNTAJE\tDECIDIDA\tESTADO\tMOTIVO\t")
+		for _, d := range lista {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%.1f\t%s\t%s\t%s\t\n", d.EventID, d.ProductoID, d.Cliente,
+				d.Puntaje, d.Hora.Format(time.RFC3339), d.Estado, d.Motivo)
+		}
+		tw.Flush()
+		return 0
+	case "liberar", "rechazar":
+		motivo := fs.String("motivo", "", "nota del revisor")
+		fs.Parse(args[1:])
+		if fs.NArg() == 0 {
+			fmt.Fprintf(os.Stderr, "uso: fraude %s <event_id>...\n", args[0])
+			return 2
+		}
+		d := Decision{Estado: decisionRechazada, Hora: time.Now(), Motivo: *motivo}
+		var producer sarama.SyncProducer
+		if args[0] == "liberar" {
+			d.Estado = decisionLiberada
+			var err error
+			if producer, err = nuevoProductor(cfg); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			defer producer.Close()
+		}
+		codigo := 0
+		for _, id := range fs.Args() {
+			id = strings.ToLower(id)
+			if err := decidir(ctx, rdb, claves, producer, cfg, id, d); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				codigo = 1
+				continue
+			}
+			fmt.Printf("%s %s\n", id, d.Estado)
+		}
+		return codigo
+	}
+	fmt.Fprintf(os.Stderr, "subcomando desconocido %q (pendientes, decisiones, liberar, rechazar)\n", args[0])
+	return 2
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/v9"
+	"go-common/config"
+)
+
+const (
+	// headerRevision marca una venta que se libero de la cuarentena; no se
+	// vuelve a evaluar.
+	headerRevision   = "fraude-revision"
+	headerPuntaje    = "fraude-puntaje"
+	headerMotivos    = "fraude-motivos"
+	revisionLiberada = "liberada"
+)
+
+// Motivo es lo que aporto una heuristica al puntaje de una venta.
+type Motivo struct {
+	Regla   string  `json:"regla"`
+	Puntos  float64 `json:"puntos"`
+	Detalle string  `json:"detalle"`
+}
+
+// entradaFraude es la venta que se evalua, con lo que las heuristicas
+// necesitan ademas del payload.
+type entradaFraude struct {
+	venta   Venta
+	cat     string
+	cliente string
+	t       time.Time
+}
+
+// reglaFraude es una heuristica de riesgo. Evaluar devuelve cuanto se paso la
+// venta del limite, de 0 (nada) a 1, y una descripcion para el revisor; el
+// puntaje es esa fraccion por el peso de la heuristica.
+type reglaFraude interface {
+	Nombre() string
+	Peso(cfg config.Fraud) float64
+	Evaluar(ctx context.Context, f *Fraude, e entradaFraude) (float64, string, error)
+}
+
+// exceso da 0.5 al pasar el limite y 1 al doblarlo.
+func exceso(x, limite float64) float64 {
+	if x <= limite {
+		return 0
+	}
+	return math.Min(1, x/(2*limite))
+}
+
+// Fraude decide si una venta cuenta o queda en cuarentena. Los contadores de
+// velocidad y de payloads repetidos viven en Valkey, asi que las replicas del
+// consumer los comparten.
+type Fraude struct {
+	rdb      redis.UniversalClient
+	claves   Claves
+	cfg      *config.Loader
+	producer sarama.SyncProducer
+	reglas   []reglaFraude
+}
+
+func NewFraude(rdb redis.UniversalClient, claves Claves, loader *config.Loader, producer sarama.SyncProducer) *Fraude {
+	return &Fraude{
+		rdb:      rdb,
+		claves:   claves,
+		cfg:      loader,
+		producer: producer,
+		reglas:   []reglaFraude{velocidad{}, cantidadAtipica{}, precioBajo{}, payloadRepetido{}},
+	}
+}
+
+// Puntuar suma lo que aporta cada heuristica, hasta 100. Una heuristica que
+// falla no aporta.
+func (f *Fraude) Puntuar(ctx context.Context, e entradaFraude) (float64, []Motivo) {
+	cfg := f.cfg.Get().Fraud
+	var puntaje float64
+	var motivos []Motivo
+	for _, r := range f.reglas {
+		peso := r.Peso(cfg)
+		if peso == 0 {
+			continue
+		}
+		x, detalle, err := r.Evaluar(ctx, f, e)
+		if err != nil {
+			log.Printf("Fraude: %s: %v", r.Nombre(), err)
+			continue
+		}
+		if x == 0 {
+			continue
+		}
+		p := math.Round(x*peso*10) / 10
+		puntaje += p
+		motivos = append(motivos, Motivo{Regla: r.Nombre(), Puntos: p, Detalle: detalle})
+	}
+	return math.Min(100, puntaje), motivos
+}
+
+// Retener evalua la venta y, si llega al umbral, la deja en cuarentena.
+// Devuelve true si la venta no se debe sumar.
+func (f *Fraude) Retener(ctx context.Context, m *sarama.ConsumerMessage, venta Venta, cat string) bool {
+	if headerDe(m, headerRevision) == revisionLiberada {
+		return false
+	}
+	cfg := f.cfg.Get()
+	e := entradaFraude{venta: venta, cat: cat, cliente: headerDe(m, headerIdentidad), t: m.Timestamp}
+	puntaje, motivos := f.Puntuar(ctx, e)
+	contadores := f.claves.global("fraude")
+	f.rdb.HIncrBy(ctx, contadores, "evaluadas", 1)
+	if puntaje < cfg.Fraud.Threshold {
+		return false
+	}
+
+	r := Retenida{
+		EventID:    idRetenida(m, venta),
+		ProductoID: venta.ProductoID,
+		Categoria:  cat,
+		Cliente:    e.cliente,
+		Puntaje:    puntaje,
+		Motivos:    motivos,
+		Hora:       time.Now(),
+		Particion:  m.Partition,
+		Offset:     m.Offset,
+		Clave:      m.Key,
+		Valor:      m.Value,
+	}
+	for _, h := range m.Headers {
+		r.Headers = append(r.Headers, headerRetenido{Clave: string(h.Key), Valor: h.Value})
+	}
+	datos, err := json.Marshal(r)
+	if err != nil {
+		return false
+	}
+	// Si no se puede guardar, la venta cuenta: mejor un falso negativo que
+	// perderla sin forma de liberarla. Las tres claves son globales y caen
+	// en el mismo slot.
+	registros := f.claves.global("fraude_registros")
+	_, err = f.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, f.claves.global("fraude_cuarentena"), r.EventID, datos)
+		pipe.ZAdd(ctx, registros, redis.Z{Score: float64(r.Hora.UnixMilli()), Member: idAuditoria(m)})
+		pipe.ZRemRangeByScore(ctx, registros, "-inf", strconv.FormatInt(r.Hora.Add(-cfg.Fraud.RecordRetention).UnixMilli(), 10))
+		return nil
+	})
+	if err != nil {
+		log.Printf("Fraude: no se pudo poner en cuarentena %s: %v", r.EventID, err)
+		return false
+	}
+	f.rdb.HIncrBy(ctx, contadores, "retenidas", 1)
+	log.Printf("Fraude: %s en cuarentena con %.1f puntos (%s)", r.EventID, puntaje, resumirMotivos(motivos))
+
+	explicacion, _ := json.Marshal(motivos)
+	msg := &sarama.ProducerMessage{
+		Topic: cfg.Fraud.ReviewTopic,
+		Key:   sarama.StringEncoder(r.EventID),
+		Value: sarama.ByteEncoder(m.Value),
+	}
+	for _, h := range m.Headers {
+		msg.Headers = append(msg.Headers, *h)
+	}
+	msg.Headers = append(msg.Headers,
+		sarama.RecordHeader{Key: []byte(headerPuntaje), Value: []byte(strconv.FormatFloat(puntaje, 'f', 1, 64))},
+		sarama.RecordHeader{Key: []byte(headerMotivos), Value: explicacion},
+	)
+	if _, _, err := f.producer.SendMessage(msg); err != nil {
+		log.Printf("Fraude: no se pudo publicar %s en %s: %v", r.EventID, cfg.Fraud.ReviewTopic, err)
+	}
+	return true
+}
+
+func resumirMotivos(motivos []Motivo) string {
+	partes := make([]string, len(motivos))
+	for i, m := range motivos {
+		partes[i] = fmt.Sprintf("%s %.1f", m.Regla, m.Puntos)
+	}
+	return strings.Join(partes, ", ")
+}
+
+// idRetenida es el event_id de la venta; los registros sin sobre se
+// identifican por particion y offset.
+func idRetenida(m *sarama.ConsumerMessage, venta Venta) string {
+	if e := venta.Envelope; e != nil && e.EventID != "" {
+		return strings.ToLower(e.EventID)
+	}
+	return idAuditoria(m)
+}
+
+// incrementarVentana suma 1 a la clave y le pone ttl la primera vez, asi la
+// ventana empieza con la primera venta.
+func incrementarVentana(ctx context.Context, pipe redis.Pipeliner, clave string, ttl time.Duration) *redis.IntCmd {
+	n := pipe.Incr(ctx, clave)
+	pipe.ExpireNX(ctx, clave, ttl)
+	return n
+}
+
+// velocidad cuenta las ventas del cliente en ventanas fijas de
+// fraud.velocity.window, en total y por producto. Las dos claves llevan el
+// cliente como hash tag y caen en el mismo slot.
+type velocidad struct{}
+
+func (velocidad) Nombre() string                { return "velocidad" }
+func (velocidad) Peso(cfg config.Fraud) float64 { return cfg.Velocity.Weight }
+
+func (velocidad) Evaluar(ctx context.Context, f *Fraude, e entradaFraude) (float64, string, error) {
+	if e.cliente == "" {
+		return 0, "", nil
+	}
+	cfg := f.cfg.Get().Fraud.Velocity
+	ventana := strconv.FormatInt(e.t.UnixNano()/int64(cfg.Window), 10)
+	base := f.claves.categoria("fraude_velocidad", e.cliente)
+	var total, porProducto *redis.IntCmd
+	_, err := f.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		total = incrementarVentana(ctx, pipe, base+":"+ventana, 2*cfg.Window)
+		porProducto = incrementarVentana(ctx, pipe, base+":"+e.venta.ProductoID+":"+ventana, 2*cfg.Window)
+		return nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	n, np := total.Val(), porProducto.Val()
+	x := math.Max(exceso(float64(n), float64(cfg.MaxPerClient)), exceso(float64(np), float64(cfg.MaxPerProduct)))
+	if x == 0 {
+		return 0, "", nil
+	}
+	return x, fmt.Sprintf("%s: %d ventas (%d de %s) en %s", e.cliente, n, np, e.venta.ProductoID, cfg.Window), nil
+}
+
+// cantidadAtipica compara las unidades con el promedio por venta de la
+// categoria, que el consumer ya mantiene.
+type cantidadAtipica struct{}
+
+func (cantidadAtipica) Nombre() string                { return "cantidad" }
+func (cantidadAtipica) Peso(cfg config.Fraud) float64 { return cfg.Quantity.Weight }
+
+func (cantidadAtipica) Evaluar(ctx context.Context, f *Fraude, e entradaFraude) (float64, string, error) {
+	cfg := f.cfg.Get().Fraud.Quantity
+	promedio, err := f.rdb.Get(ctx, f.claves.categoria("promedio_productos", e.cat)).Float64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, "", err
+	}
+	limite := math.Max(float64(cfg.Min), cfg.Factor*promedio)
+	x := exceso(float64(e.venta.CantidadVendida), limite)
+	if x == 0 {
+		return 0, "", nil
+	}
+	return x, fmt.Sprintf("%d unidades, limite %.0f (promedio de %s %.1f)", e.venta.CantidadVendida, limite, e.cat, promedio), nil
+}
+
+// precioBajo compara el precio con el de lista del catalogo; sin catalogo no
+// aporta.
+type precioBajo struct{}
+
+func (precioBajo) Nombre() string                { return "precio" }
+func (precioBajo) Peso(cfg config.Fraud) float64 { return cfg.Price.Weight }
+
+func (precioBajo) Evaluar(ctx context.Context, f *Fraude, e entradaFraude) (float64, string, error) {
+	cat := e.venta.Catalogo
+	if cat == nil || cat.PrecioLista <= 0 {
+		return 0, "", nil
+	}
+	piso := f.cfg.Get().Fraud.Price.Floor * cat.PrecioLista
+	if e.venta.Precio >= piso {
+		return 0, "", nil
+	}
+	// Igual que exceso pero al reves: la mitad justo bajo el piso y todo a
+	// la mitad del piso o menos.
+	x := 1.0
+	if e.venta.Precio > 0 {
+		x = math.Min(1, piso/(2*e.venta.Precio))
+	}
+	return x, fmt.Sprintf("precio %.2f, lista %.2f", e.venta.Precio, cat.PrecioLista), nil
+}
+
+// payloadRepetido cuenta los payloads identicos salvo por el event_id.
+type payloadRepetido struct{}
+
+func (payloadRepetido) Nombre() string                { return "repetido" }
+func (payloadRepetido) Peso(cfg config.Fraud) float64 { return cfg.Duplicates.Weight }
+
+func (payloadRepetido) Evaluar(ctx context.Context, f *Fraude, e entradaFraude) (float64, string, error) {
+	cfg := f.cfg.Get().Fraud.Duplicates
+	v := e.venta
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%s|%d", e.cliente, v.ProductoID, v.Categoria,
+		strconv.FormatFloat(v.Precio, 'f', -1, 64), v.CantidadVendida)))
+	huella := hex.EncodeToString(h[:12])
+	var n *redis.IntCmd
+	_, err := f.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		n = incrementarVentana(ctx, pipe, f.claves.categoria("fraude_huella", huella), cfg.Window)
+		return nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	x := exceso(float64(n.Val()), float64(cfg.Max))
+	if x == 0 {
+		return 0, "", nil
+	}
+	return x, fmt.Sprintf("payload repetido %d veces en %s", n.Val(), cfg.Window), nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/v9"
+	"go-common/config"
+)
+
+// productorFalso guarda los mensajes enviados, o falla con err.
+type productorFalso struct {
+	sarama.SyncProducer
+	err      error
+	enviados []*sarama.ProducerMessage
+}
+
+func (p *productorFalso) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if p.err != nil {
+		return 0, 0, p.err
+	}
+	p.enviados = append(p.enviados, msg)
+	return 0, int64(len(p.enviados)), nil
+}
+
+func nuevoFraude(t *testing.T, ajustar func(*config.Fraud)) (*Fraude, *productorFalso, *config.Config) {
+	t.Helper()
+	_, rdb := nuevoValkey(t)
+	cfg := config.Defaults()
+	if ajustar != nil {
+		ajustar(&cfg.Fraud)
+	}
+	p := &productorFalso{}
+	return NewFraude(rdb, NewClaves(false, ""), config.NewLoader(cfg), p), p, cfg
+}
+
+func TestPuntuar(t *testing.T) {
+	ahora := time.Date(2026, 11, 27, 12, 0, 0, 0, time.UTC)
+	lista := &InfoCatalogo{PrecioLista: 100}
+	casos := []struct {
+		nombre  string
+		venta   Venta
+		puntaje float64
+		reglas  string
+	}{
+		{"venta normal", Venta{ProductoID: "P-1", Precio: 90, CantidadVendida: 1, Catalogo: lista}, 0, ""},
+		{"sin catalogo no mira el precio", Venta{ProductoID: "P-1", Precio: 1, CantidadVendida: 1}, 0, ""},
+		{"precio justo bajo el piso", Venta{ProductoID: "P-1", Precio: 40, CantidadVendida: 1, Catalogo: lista}, 37.5, "precio"},
+		{"precio a la mitad del piso", Venta{ProductoID: "P-1", Precio: 20, CantidadVendida: 1, Catalogo: lista}, 60, "precio"},
+		{"cantidad sobre el limite", Venta{ProductoID: "P-1", Precio: 90, CantidadVendida: 30}, 37.5, "cantidad"},
+		{"cantidad al doble del limite", Venta{ProductoID: "P-1", Precio: 90, CantidadVendida: 40}, 50, "cantidad"},
+		{"el puntaje llega hasta 100", Venta{ProductoID: "P-1", Precio: 20, CantidadVendida: 40, Catalogo: lista}, 100, "cantidad,precio"},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			f, _, _ := nuevoFraude(t, nil)
+			// Promedio de 2 unidades por venta: el limite queda en el minimo de 20.
+			f.rdb.Set(context.Background(), f.claves.categoria("promedio_productos", "Electronica"), "2", 0)
+			puntaje, motivos := f.Puntuar(context.Background(), entradaFraude{venta: c.venta, cat: "Electronica", t: ahora})
+			var reglas []string
+			for _, m := range motivos {
+				reglas = append(reglas, m.Regla)
+			}
+			if puntaje != c.puntaje || strings.Join(reglas, ",") != c.reglas {
+				t.Errorf("puntaje %.1f con %v, se esperaba %.1f con %s", puntaje, reglas, c.puntaje, c.reglas)
+			}
+		})
+	}
+}
+
+func TestPuntuarContadores(t *testing.T) {
+	ahora := time.Date(2026, 11, 27, 12, 0, 0, 0, time.UTC)
+	f, _, _ := nuevoFraude(t, func(cfg *config.Fraud) {
+		cfg.Velocity.MaxPerClient, cfg.Velocity.MaxPerProduct = 2, 2
+		cfg.Duplicates.Max = 10
+	})
+	ctx := context.Background()
+	puntuar := func(cliente, producto string, t time.Time) float64 {
+		p, _ := f.Puntuar(ctx, entradaFraude{venta: Venta{ProductoID: producto, Precio: 10, CantidadVendida: 1}, cat: "Ropa", cliente: cliente, t: t})
+		return p
+	}
+	// La tercera venta del cliente en la ventana pasa el limite de 2.
+	if p := puntuar("tienda-1", "P-1", ahora) + puntuar("tienda-1", "P-1", ahora); p != 0 {
+		t.Errorf("las dos primeras ventas suman %.1f", p)
+	}
+	if p := puntuar("tienda-1", "P-1", ahora); p != 60 {
+		t.Errorf("tercera venta: %.1f, se esperaba 60", p)
+	}
+	// Otra ventana empieza de cero, y sin identidad no se cuenta velocidad.
+	if p := puntuar("tienda-1", "P-1", ahora.Add(time.Minute)); p != 0 {
+		t.Errorf("en la ventana siguiente: %.1f", p)
+	}
+	for i := 0; i < 5; i++ {
+		if p := puntuar("", "P-2", ahora); p != 0 {
+			t.Fatalf("venta sin identidad %d: %.1f", i, p)
+		}
+	}
+}
+
+func TestRetenerYDecidir(t *testing.T) {
+	f, productor, cfg := nuevoFraude(t, nil)
+	ctx := context.Background()
+	k := f.claves
+	retener := func(offset int64, id string) bool {
+		m := &sarama.ConsumerMessage{Partition: 0, Offset: offset, Timestamp: time.Now(), Value: []byte(`{}`),
+			Headers: []*sarama.RecordHeader{{Key: []byte(headerIdentidad), Value: []byte("tienda-1")}}}
+		venta := ventaSospechosa()
+		venta.Envelope = &Sobre{EventID: id}
+		return f.Retener(ctx, m, venta, "Ropa")
+	}
+	if !retener(7, "EVT-1") || !retener(8, "evt-2") || !retener(9, "evt-3") {
+		t.Fatal("la venta deberia quedar en cuarentena")
+	}
+	if len(productor.enviados) != 3 || productor.enviados[0].Topic != cfg.Fraud.ReviewTopic {
+		t.Fatalf("se publicaron %d en revision", len(productor.enviados))
+	}
+
+	var pendientes []Retenida
+	for cursor := uint64(0); ; {
+		pagina, siguiente, err := leerCuarentena(ctx, f.rdb, k, cursor, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pendientes = append(pendientes, pagina...)
+		if cursor = siguiente; cursor == 0 {
+			break
+		}
+	}
+	if len(pendientes) != 3 || pendientes[0].Cliente != "tienda-1" {
+		t.Fatalf("pendientes %+v", pendientes)
+	}
+
+	// Un fallo al publicar la venta liberada la deja en cuarentena.
+	productor.err = errors.New("sin brokers")
+	if err := decidir(ctx, f.rdb, k, productor, cfg, "evt-1", Decision{Estado: decisionLiberada, Hora: time.Now()}); err == nil {
+		t.Fatal("liberar sin publicar deberia fallar")
+	}
+	productor.err = nil
+	if err := decidir(ctx, f.rdb, k, productor, cfg, "evt-1", Decision{Estado: decisionLiberada, Hora: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	liberada := productor.enviados[len(productor.enviados)-1]
+	if liberada.Topic != cfg.Kafka.Topic || headerProducido(liberada, headerRevision) != revisionLiberada {
+		t.Errorf("venta liberada en %s sin el header de revision", liberada.Topic)
+	}
+	if err := decidir(ctx, f.rdb, k, productor, cfg, "evt-1", Decision{Estado: decisionRechazada, Hora: time.Now()}); err == nil {
+		t.Error("una venta ya decidida no se puede volver a decidir")
+	}
+	if err := decidir(ctx, f.rdb, k, nil, cfg, "evt-2", Decision{Estado: decisionRechazada, Hora: time.Now(), Motivo: "bot"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Las decididas salen del hash y quedan en el stream sin el registro.
+	if n, _ := f.rdb.HLen(ctx, k.global("fraude_cuarentena")).Result(); n != 1 {
+		t.Errorf("quedan %d en cuarentena, se esperaba 1", n)
+	}
+	decisiones, err := leerDecisiones(ctx, f.rdb, k, 10)
+	if err != nil || len(decisiones) != 2 {
+		t.Fatalf("decisiones %+v (%v)", decisiones, err)
+	}
+	if d := decisiones[0]; d.EventID != "evt-2" || d.Estado != decisionRechazada || d.Motivo != "bot" || d.Cliente != "tienda-1" {
+		t.Errorf("decision mas reciente %+v", d)
+	}
+
+	// Reconcile y replay siguen excluyendo los registros, decididos o no.
+	ids, err := retenidasPorRegistro(ctx, f.rdb, k, cfg.Fraud)
+	if err != nil || len(ids) != 3 || !ids["0:7"] || !ids["0:9"] {
+		t.Errorf("registros retenidos %v (%v)", ids, err)
+	}
+	contadores, _ := f.rdb.HGetAll(ctx, k.global("fraude")).Result()
+	if contadores["retenidas"] != "3" || contadores["liberadas"] != "1" || contadores["rechazadas"] != "1" {
+		t.Errorf("contadores %v", contadores)
+	}
+}
+
+func TestRegistrosVencidos(t *testing.T) {
+	f, _, cfg := nuevoFraude(t, func(cfg *config.Fraud) { cfg.RecordRetention = time.Hour })
+	ctx := context.Background()
+	clave := f.claves.global("fraude_registros")
+	viejo := time.Now().Add(-2 * time.Hour).UnixMilli()
+	f.rdb.ZAdd(ctx, clave, redis.Z{Score: float64(viejo), Member: "0:1"})
+
+	ids, err := retenidasPorRegistro(ctx, f.rdb, f.claves, cfg.Fraud)
+	if err != nil || len(ids) != 0 {
+		t.Errorf("un registro vencido no deberia contar: %v (%v)", ids, err)
+	}
+	// La siguiente retencion lo recorta.
+	m := &sarama.ConsumerMessage{Partition: 0, Offset: 2, Timestamp: time.Now(), Value: []byte(`{}`)}
+	if !f.Retener(ctx, m, ventaSospechosa(), "Ropa") {
+		t.Fatal("la venta deberia quedar en cuarentena")
+	}
+	if miembros, _ := f.rdb.ZRange(ctx, clave, 0, -1).Result(); len(miembros) != 1 || miembros[0] != "0:2" {
+		t.Errorf("fraude_registros = %v", miembros)
+	}
+}
+
+// ventaSospechosa suma 100 puntos: 500 unidades y un precio muy por debajo
+// del de lista.
+func ventaSospechosa() Venta {
+	return Venta{ProductoID: "P-1", Precio: 10, CantidadVendida: 500, Catalogo: &InfoCatalogo{PrecioLista: 100}}
+}
+
+func headerProducido(m *sarama.ProducerMessage, clave string) string {
+	for _, h := range m.Headers {
+		if string(h.Key) == clave {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -4,5 +4,12 @@ go 1.24.0
 
 require (
 	github.com/IBM/sarama v1.43.0
+	github.com/alicebob/miniredis/v2 v2.37.0
+	github.com/xdg-go/scram v1.1.2
 	github.com/redis/go-redis/v9 v9.5.1
-)
\ No newline at end of file
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require go-common v0.0.0
+
+replace go-common => ../go-common
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/v9"
+)
+
+// Claves arma los nombres de las claves que escribe el consumer. En modo
+// cluster la parte variable va entre llaves ("contador:{Electronica}") para
+// que todas las claves de una categoria caigan en el mismo slot y los scripts
+// de agregados sigan siendo validos. El prefijo del namespace queda fuera de
+// las llaves, asi una clave y su copia en otro namespace comparten slot.
+type Claves struct {
+	cluster bool
+	prefijo string
+}
+
+// registroNamespaces es el set, fuera de todo namespace, con los namespaces
+// que algun consumer uso.
+const registroNamespaces = "namespaces"
+
+func NewClaves(cluster bool, namespace string) Claves {
+	return Claves{cluster: cluster, prefijo: normalizarNamespace(namespace)}
+}
+
+// normalizarNamespace agrega el ":" final si falta: "run-42" y "run-42:"
+// son el mismo namespace.
+func normalizarNamespace(ns string) string {
+	if ns != "" && !strings.HasSuffix(ns, ":") {
+		ns += ":"
+	}
+	return ns
+}
+
+// EnNamespace devuelve las mismas reglas de nombres con otro prefijo.
+func (k Claves) EnNamespace(ns string) Claves {
+	return NewClaves(k.cluster, ns)
+}
+
+func (k Claves) categoria(base, cat string) string {
+	if k.cluster {
+		return k.prefijo + base + ":{" + cat + "}"
+	}
+	return k.prefijo + base + ":" + cat
+}
+
+func (k Claves) global(base string) string {
+	if k.cluster {
+		return k.prefijo + base + ":{global}"
+	}
+	return k.prefijo + base
+}
+
+// patrones devuelve los patrones de SCAN que cubren todas las claves con esa
+// base, tanto la global como las de cada categoria.
+func (k Claves) patrones(base string) []string {
+	return []string{k.prefijo + base, k.prefijo + base + ":*"}
+}
+
+// agregadosCategoriaScript actualiza contador, sumas, promedios y ranking de
+// una categoria de forma atomica. Los SET usan KEEPTTL para no borrar la
+// expiracion que fija el janitor.
+var agregadosCategoriaScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+local cant = redis.call('INCRBY', KEYS[2], ARGV[1])
+local suma = tonumber(redis.call('INCRBYFLOAT', KEYS[3], ARGV[2]))
+redis.call('SET', KEYS[4], tostring(cant / n), 'KEEPTTL')
+redis.call('SET', KEYS[5], tostring(suma / n), 'KEEPTTL')
+redis.call('ZINCRBY', KEYS[6], ARGV[1], ARGV[3])
+return n
+`)
+
+// agregadosGlobalesScript actualiza total, ranking global y precios
+// maximo/minimo sin carreras entre replicas del consumer.
+var agregadosGlobalesScript = redis.NewScript(`
+redis.call('INCR', KEYS[1])
+redis.call('ZINCRBY', KEYS[2], ARGV[1], ARGV[2])
+local p = tonumber(ARGV[3])
+local max = tonumber(redis.call('GET', KEYS[3]))
+if not max or p > max then
+  redis.call('SET', KEYS[3], ARGV[3], 'KEEPTTL')
+end
+local min = tonumber(redis.call('GET', KEYS[4]))
+if not min or p < min then
+  redis.call('SET', KEYS[4], ARGV[3], 'KEEPTTL')
+end
+return 1
+`)
+
+func (k Claves) actualizarCategoria(ctx context.Context, rdb redis.UniversalClient, cat string, venta Venta) error {
+	return agregadosCategoriaScript.Run(ctx, rdb, []string{
+		k.categoria("contador", cat),
+		k.categoria("suma_cantidad", cat),
+		k.categoria("suma_precio", cat),
+		k.categoria("promedio_productos", cat),
+		k.categoria("promedio_precio_tag", cat),
+		k.categoria("ranking_productos_cat", cat),
+	}, venta.CantidadVendida, strconv.FormatFloat(venta.Precio, 'f', -1, 64), venta.ProductoID).Err()
+}
+
+func (k Claves) actualizarGlobales(ctx context.Context, rdb redis.UniversalClient, venta Venta) error {
+	return agregadosGlobalesScript.Run(ctx, rdb, []string{
+		k.global("total_ventas"),
+		k.global("ranking_productos"),
+		k.global("precio_max_global"),
+		k.global("precio_min_global"),
+	}, venta.CantidadVendida, venta.ProductoID, strconv.FormatFloat(venta.Precio, 'f', -1, 64)).Err()
+}
+
+// actualizarCampana suma la venta a los hashes por campana; el campo de cada
+// hash es el id de la campana.
+func (k Claves) actualizarCampana(ctx context.Context, rdb redis.UniversalClient, venta Venta) error {
+	id := venta.Campana.ID
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, k.global("campana_ventas"), id, 1)
+		pipe.HIncrBy(ctx, k.global("campana_unidades"), id, int64(venta.CantidadVendida))
+		pipe.HIncrByFloat(ctx, k.global("campana_ingresos"), id, venta.Campana.PrecioEfectivo*float64(venta.CantidadVendida))
+		if venta.Campana.AccesoAnticipado {
+			pipe.HIncrBy(ctx, k.global("campana_anticipadas"), id, 1)
+		}
+		return nil
+	})
+	return err
+}
+
+// canaryVistoTTL es cuanto dura la confirmacion de una venta del canary; solo
+// tiene que sobrevivir hasta que el canary la consulte.
+const canaryVistoTTL = 10 * time.Minute
+
+func (k Claves) canaryVisto(id string) string {
+	return k.categoria("canary_visto", id)
+}
+
+// idAuditoria identifica un registro de Kafka en el libro de auditoria.
+func idAuditoria(m *sarama.ConsumerMessage) string {
+	return strconv.Itoa(int(m.Partition)) + ":" + strconv.FormatInt(m.Offset, 10)
+}
+
+// claveAuditoria agrupa los registros por el minuto de su timestamp de Kafka,
+// asi la reconciliacion puede leer solo los minutos de una ventana.
+func (k Claves) claveAuditoria(t time.Time) string {
+	return k.categoria("auditoria", t.UTC().Format("200601021504"))
+}
+
+// registrarAuditoria cuenta cuantas veces se proceso cada registro; un valor
+// mayor que 1 es un duplicado.
+func (k Claves) registrarAuditoria(ctx context.Context, rdb redis.UniversalClient, m *sarama.ConsumerMessage, ttl time.Duration) error {
+	clave := k.claveAuditoria(m.Timestamp)
+	_, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, clave, idAuditoria(m), 1)
+		pipe.Expire(ctx, clave, ttl)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers que agregan el bridge y el writer, en nanosegundos Unix.
+const (
+	headerBridgeRecibido = "t-bridge-recibido"
+	headerBridgeEnviado  = "t-bridge-enviado"
+	headerWriterRecibido = "t-writer-recibido"
+)
+
+// sellos reune las marcas de un evento. Kafka es el timestamp del registro,
+// que con LogAppendTime en sales-topic es el momento en que el lider lo
+// escribio.
+type sellos struct {
+	bridgeRecibido, bridgeEnviado, writerRecibido, kafka, consumerRecibido, valkeyCommit time.Time
+}
+
+func sellosDe(m *sarama.ConsumerMessage) sellos {
+	var s sellos
+	for _, h := range m.Headers {
+		ns, err := strconv.ParseInt(string(h.Value), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch string(h.Key) {
+		case headerBridgeRecibido:
+			s.bridgeRecibido = time.Unix(0, ns)
+		case headerBridgeEnviado:
+			s.bridgeEnviado = time.Unix(0, ns)
+		case headerWriterRecibido:
+			s.writerRecibido = time.Unix(0, ns)
+		}
+	}
+	s.kafka = m.Timestamp
+	return s
+}
+
+// saltos en el orden del recorrido; "total" va de la llegada al bridge al
+// commit en Valkey. Los relojes de cada pod pueden diferir, asi que un salto
+// entre pods puede salir negativo: se cuenta como 0.
+var saltos = []struct {
+	nombre   string
+	desde, a func(s sellos) time.Time
+}{
+	{"bridge", func(s sellos) time.Time { return s.bridgeRecibido }, func(s sellos) time.Time { return s.bridgeEnviado }},
+	{"grpc", func(s sellos) time.Time { return s.bridgeEnviado }, func(s sellos) time.Time { return s.writerRecibido }},
+	{"writer_kafka", func(s sellos) time.Time { return s.writerRecibido }, func(s sellos) time.Time { return s.kafka }},
+	{"kafka_consumer", func(s sellos) time.Time { return s.kafka }, func(s sellos) time.Time { return s.consumerRecibido }},
+	{"consumer_valkey", func(s sellos) time.Time { return s.consumerRecibido }, func(s sellos) time.Time { return s.valkeyCommit }},
+	{"total", func(s sellos) time.Time { return s.bridgeRecibido }, func(s sellos) time.Time { return s.valkeyCommit }},
+}
+
+// limitesMs son los limites superiores de cada bucket; el ultimo bucket
+// cuenta lo que supera 10s.
+var limitesMs = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+type Histograma struct {
+	cuentas []int64
+	n       int64
+	suma    float64
+	max     float64
+}
+
+func nuevoHistograma() *Histograma {
+	return &Histograma{cuentas: make([]int64, len(limitesMs)+1)}
+}
+
+func (h *Histograma) observar(ms float64) {
+	i := 0
+	for i < len(limitesMs) && ms > limitesMs[i] {
+		i++
+	}
+	h.cuentas[i]++
+	h.n++
+	h.suma += ms
+	h.max = math.Max(h.max, ms)
+}
+
+// percentil interpola dentro del bucket donde cae q.
+func (h *Histograma) percentil(q float64) float64 {
+	objetivo := q * float64(h.n)
+	var acumulado float64
+	for i, c := range h.cuentas {
+		if c == 0 {
+			continue
+		}
+		if acumulado+float64(c) >= objetivo {
+			bajo := 0.0
+			if i > 0 {
+				bajo = limitesMs[i-1]
+			}
+			alto := h.max
+			if i < len(limitesMs) {
+				alto = math.Min(limitesMs[i], h.max)
+			}
+			return bajo + (alto-bajo)*(objetivo-acumulado)/float64(c)
+		}
+		acumulado += float64(c)
+	}
+	return h.max
+}
+
+type ResumenLatencia struct {
+	N       int64            `json:"n"`
+	MediaMs float64          `json:"media_ms"`
+	P50Ms   float64          `json:"p50_ms"`
+	P90Ms   float64          `json:"p90_ms"`
+	P99Ms   float64          `json:"p99_ms"`
+	MaxMs   float64          `json:"max_ms"`
+	Buckets map[string]int64 `json:"buckets"`
+}
+
+func (h *Histograma) resumen() ResumenLatencia {
+	r := ResumenLatencia{N: h.n, MaxMs: h.max, Buckets: map[string]int64{}}
+	if h.n > 0 {
+		r.MediaMs = h.suma / float64(h.n)
+		r.P50Ms, r.P90Ms, r.P99Ms = h.percentil(0.5), h.percentil(0.9), h.percentil(0.99)
+	}
+	var acumulado int64
+	for i, c := range h.cuentas {
+		acumulado += c
+		le := "+Inf"
+		if i < len(limitesMs) {
+			le = strconv.FormatFloat(limitesMs[i], 'f', -1, 64)
+		}
+		r.Buckets[le] = acumulado
+	}
+	return r
+}
+
+// todasLasCategorias junta los saltos de todas las categorias reales; las
+// ventas del canary van solo a categoriaCanary.
+const (
+	todasLasCategorias = "_todas"
+	categoriaCanary    = "_canary"
+)
+
+// Latencias guarda un histograma por categoria y salto.
+type Latencias struct {
+	mu     sync.Mutex
+	desde  time.Time
+	porCat map[string]map[string]*Histograma
+}
+
+func NewLatencias() *Latencias {
+	return &Latencias{desde: time.Now(), porCat: map[string]map[string]*Histograma{}}
+}
+
+func (l *Latencias) Registrar(cat string, s sellos) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, salto := range saltos {
+		desde, a := salto.desde(s), salto.a(s)
+		if desde.IsZero() || a.IsZero() {
+			continue
+		}
+		ms := max(0, float64(a.Sub(desde))/float64(time.Millisecond))
+		cats := []string{cat, todasLasCategorias}
+		if cat == categoriaCanary {
+			cats = cats[:1]
+		}
+		for _, c := range cats {
+			hs := l.porCat[c]
+			if hs == nil {
+				hs = map[string]*Histograma{}
+				l.porCat[c] = hs
+			}
+			h := hs[salto.nombre]
+			if h == nil {
+				h = nuevoHistograma()
+				hs[salto.nombre] = h
+			}
+			h.observar(ms)
+		}
+	}
+}
+
+type ReporteLatencias struct {
+	Desde      time.Time                             `json:"desde"`
+	Categorias map[string]map[string]ResumenLatencia `json:"categorias"`
+}
+
+func (l *Latencias) Reporte() ReporteLatencias {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rep := ReporteLatencias{Desde: l.desde, Categorias: map[string]map[string]ResumenLatencia{}}
+	for cat, hs := range l.porCat {
+		rep.Categorias[cat] = map[string]ResumenLatencia{}
+		for salto, h := range hs {
+			rep.Categorias[cat][salto] = h.resumen()
+		}
+	}
+	return rep
+}
+
+// Reiniciar descarta lo acumulado, p.ej. antes de una prueba de carga.
+func (l *Latencias) Reiniciar() {
+	l.mu.Lock()
+	l.desde = time.Now()
+	l.porCat = map[string]map[string]*Histograma{}
+	l.mu.Unlock()
+}
@@ -2,31 +2,86 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/redis/go-redis/v9"
+	"go-common/categorias"
+	"go-common/config"
+	"go-common/kafkaconf"
+	"go-common/valkeyconf"
 )
 type Venta struct {
-	Categoria       int32   `json:"categoria"`
-	ProductoID      string  `json:"producto_id"`
-	Precio          float64 `json:"precio"`
-	CantidadVendida int32   `json:"cantidad_vendida"`
+	Categoria       int32           `json:"categoria"`
+	ProductoID      string          `json:"producto_id"`
+	Precio          float64         `json:"precio"`
+	CantidadVendida int32           `json:"cantidad_vendida"`
+	Canary          bool            `json:"canary"`
+	Envelope        *Sobre          `json:"envelope"`
+	Catalogo        *InfoCatalogo   `json:"catalogo"`
+	Inventario      *InfoInventario `json:"inventario"`
+	Campana         *InfoCampana    `json:"campana"`
 }
 
-var categorias = map[int32]string{
-	1: "Electronica", 2: "Ropa", 3: "Hogar", 4: "Belleza",
+// InfoCampana es la campana que el bridge aplico a la venta; los ingresos de
+// la campana se cuentan a precio_efectivo.
+type InfoCampana struct {
+	ID               string  `json:"id"`
+	Nombre           string  `json:"nombre"`
+	PrecioEfectivo   float64 `json:"precio_efectivo"`
+	DescuentoPct     float64 `json:"descuento_pct"`
+	AccesoAnticipado bool    `json:"acceso_anticipado"`
+}
+
+// InfoInventario es el stock que quedo despues de reservar la venta en el
+// bridge; no viene si el producto no tiene inventario.
+type InfoInventario struct {
+	Disponible int64 `json:"disponible"`
+	Total      int64 `json:"total"`
+}
+
+// InfoCatalogo es lo que el bridge agrega desde el catalogo de productos; el
+// producto_id de la venta ya viene normalizado al id canonico.
+type InfoCatalogo struct {
+	Nombre            string  `json:"nombre"`
+	Categoria         int32   `json:"categoria"`
+	PrecioLista       float64 `json:"precio_lista"`
+	Alias             string  `json:"alias"`
+	Desconocido       bool    `json:"desconocido"`
+	CategoriaDistinta bool    `json:"categoria_distinta"`
+	CategoriaPayload  int32   `json:"categoria_payload"`
+}
+
+// versionSobre es la ultima version del sobre que entiende el consumer.
+const versionSobre = 1
+
+// Sobre es el envelope que agregan el bridge y el writer; los registros mas
+// viejos no lo traen. Los tiempos van en nanosegundos Unix.
+type Sobre struct {
+	Version    uint32 `json:"version"`
+	EventID    string `json:"event_id"`
+	EventTime  int64  `json:"event_time"`
+	IngestTime int64  `json:"ingest_time"`
+	Source     string `json:"source"`
+	Tenant     string `json:"tenant"`
 }
 
 type Consumer struct {
-	rdb *redis.Client
+	rdb       redis.UniversalClient
+	lectura   *Lectura
+	cfg       *config.Loader
+	claves    Claves
+	latencias *Latencias
+
+	registro     *categorias.Registro
+	desconocidas Desconocidas
+	anomalias    *Detector
+	fraude       *Fraude
 }
 
 func (consumer *Consumer) Setup(sarama.ConsumerGroupSession) error { return nil }
@@ -35,36 +90,111 @@ func (consumer *Consumer) Cleanup(sarama.ConsumerGroupSession) error { return ni
 
 func (consumer *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for message := range claim.Messages() {
-		procesarMensaje(session.Context(), consumer.rdb, message.Value)
+		consumer.procesarMensaje(session.Context(), message)
 		session.MarkMessage(message, "")
 	}
 	return nil
 }
 
 func main() {
-	valkeyAddr := os.Getenv("VALKEY_ADDR")
-	if valkeyAddr == "" { valkeyAddr = "localhost:6379" }
-	
-	kafkaEnv := os.Getenv("KAFKA_BROKERS")
-	if kafkaEnv == "" { kafkaEnv = "localhost:9092" }
-	brokers := strings.Split(kafkaEnv, ",")
-
-	rdb := redis.NewClient(&redis.Options{Addr: valkeyAddr})
-
-	config := sarama.NewConfig()
-	config.Consumer.Return.Errors = true
-	config.Consumer.Offsets.Initial = sarama.OffsetOldest
-	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
-
-	groupName := "black-friday-group"
-	consumerGroup, err := sarama.NewConsumerGroup(brokers, groupName, config)
+	loader := config.MustLoad("go-consumer")
+	cfg := loader.Get()
+
+	valkeyOpts := valkeyconf.Options{
+		Mode:             cfg.Valkey.Mode,
+		Addr:             cfg.Valkey.Addr,
+		Addrs:            cfg.Valkey.Addrs,
+		MasterName:       cfg.Valkey.MasterName,
+		Username:         cfg.Valkey.Username,
+		Password:         cfg.Valkey.Password,
+		PasswordFile:     cfg.Valkey.PasswordFile,
+		SentinelPassword: cfg.Valkey.SentinelPassword,
+		DB:               cfg.Valkey.DB,
+		TLS:              valkeyconf.TLS(cfg.Valkey.TLS),
+	}
+	rdb, err := valkeyconf.New(valkeyOpts)
+	if err != nil {
+		log.Fatalf("Error configurando Valkey: %v", err)
+	}
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		log.Printf("Valkey no responde todavia: %v", err)
+	}
+	if err := validarNamespace(cfg.Valkey.Namespace); err != nil {
+		log.Fatalf("Fatal config: valkey.namespace: %v", err)
+	}
+	claves := NewClaves(valkeyOpts.Cluster(), cfg.Valkey.Namespace)
+
+	if args := loader.Args(); len(args) > 0 {
+		os.Exit(ejecutarComando(context.Background(), args, rdb, claves, loader))
+	}
+
+	saramaCfg, err := configSarama(cfg)
 	if err != nil {
-		log.Fatalf("Error creando consumer group: %v", err)
+		log.Fatalf("Error configurando Kafka: %v", err)
+	}
+
+	consumerGroup, err := sarama.NewConsumerGroup(cfg.Kafka.Brokers, cfg.Kafka.Group, saramaCfg)
+	if err != nil {
+		log.Fatalf("Error creando consumer group: %v", kafkaconf.Explicar(err))
 	}
 	defer consumerGroup.Close()
 
+	lectura, err := NewLectura(rdb, valkeyOpts, cfg.Valkey.ReadReplicas, cfg.Valkey.MaxReplicaLag)
+	if err != nil {
+		log.Fatalf("Error configurando replicas de lectura: %v", err)
+	}
+
+	if claves.prefijo != "" {
+		if err := rdb.SAdd(context.Background(), registroNamespaces, claves.prefijo).Err(); err != nil {
+			log.Printf("No se pudo registrar el namespace %s: %v", claves.prefijo, err)
+		}
+		log.Printf("Usando namespace %s", claves.prefijo)
+	}
+
+	janitor, err := NewJanitor(rdb, claves, cfg.Retention)
+	if err != nil {
+		log.Fatalf("Error en la politica de retencion: %v", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
-	consumer := &Consumer{rdb: rdb}
+	registro := nuevoRegistro(ctx, rdb, cfg)
+	consumer := &Consumer{rdb: rdb, lectura: lectura, cfg: loader, claves: claves, latencias: NewLatencias(), registro: registro}
+	loader.OnReload(func(nuevo *config.Config) {
+		if nuevo.Registry.Source == "config" {
+			registro.Reemplazar(categoriasDeConfig(nuevo))
+		}
+		lectura.SetMaxLag(nuevo.Valkey.MaxReplicaLag)
+		if err := janitor.Actualizar(nuevo.Retention); err != nil {
+			log.Printf("config: retention invalida, se mantiene la anterior: %v", err)
+		}
+	})
+	go loader.Watch(ctx, 5*time.Second)
+	go lectura.Monitorear(ctx, cfg.Valkey.LagCheckInterval)
+	go consumer.servirStats(cfg.Consumer.StatsListen)
+	go janitor.Correr(ctx)
+	go registro.Vigilar(ctx, cfg.Registry.Refresh)
+	go vigilarArtefactoCategorias(ctx, cfg, registro)
+
+	var producer sarama.SyncProducer
+	if cfg.Anomalies.Enabled || cfg.Fraud.Enabled {
+		producer, err = nuevoProductor(cfg)
+		if err != nil {
+			log.Fatalf("Error creando el productor de Kafka: %v", kafkaconf.Explicar(err))
+		}
+		defer producer.Close()
+	}
+	if cfg.Fraud.Enabled {
+		consumer.fraude = NewFraude(rdb, claves, loader, producer)
+	}
+	if cfg.Anomalies.Enabled {
+		consumer.anomalias = NewDetector(loader)
+		go consumer.anomalias.Correr(ctx, cfg.Anomalies.Interval)
+		go consumer.anomalias.Publicar(ctx, producer, rdb, claves)
+	}
+
+	if cfg.Alerting.Enabled {
+		go NewReglas(rdb, claves, loader).Correr(ctx, cfg.Alerting.Interval)
+	}
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
@@ -72,7 +202,7 @@ func main() {
 	go func() {
 		defer wg.Done()
 		for {
-			if err := consumerGroup.Consume(ctx, []string{"sales-topic"}, consumer); err != nil {
+			if err := consumerGroup.Consume(ctx, []string{cfg.Kafka.Topic}, consumer); err != nil {
 				log.Printf("Error en consumer: %v", err)
 			}
 			if ctx.Err() != nil {
@@ -91,64 +221,175 @@ func main() {
 	wg.Wait()
 }
 
-func procesarMensaje(ctx context.Context, rdb *redis.Client, value []byte) {
-	var venta Venta
-	if err := json.Unmarshal(value, &venta); err != nil {
+// configSarama arma la configuracion de consumer group que comparten el
+// consumer y los comandos que leen el topic.
+func configSarama(cfg *config.Config) (*sarama.Config, error) {
+	saramaCfg, err := kafkaconf.New(kafkaconf.Kafka{
+		Version: cfg.Kafka.Version,
+		SASL:    kafkaconf.SASL(cfg.Kafka.SASL),
+		TLS:     kafkaconf.TLS(cfg.Kafka.TLS),
+	})
+	if err != nil {
+		return nil, err
+	}
+	saramaCfg.Consumer.Return.Errors = true
+	saramaCfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	saramaCfg.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
+	return saramaCfg, nil
+}
+
+// nuevoProductor arma el productor con el que el consumer publica alertas y
+// ventas en cuarentena.
+func nuevoProductor(cfg *config.Config) (sarama.SyncProducer, error) {
+	prodCfg, err := configSarama(cfg)
+	if err != nil {
+		return nil, err
+	}
+	prodCfg.Producer.Return.Successes = true
+	prodCfg.Producer.RequiredAcks = sarama.WaitForAll
+	return sarama.NewSyncProducer(cfg.Kafka.Brokers, prodCfg)
+}
+
+func (consumer *Consumer) procesarMensaje(ctx context.Context, message *sarama.ConsumerMessage) {
+	recibido := time.Now()
+	ce, datos, err := leerEvento(message)
+	if err != nil {
+		return
+	}
+	if esAjuste(ce.Type) {
+		if aj, err := ajusteDeEvento(ce, datos); err == nil {
+			consumer.procesarAjuste(ctx, message, aj)
+		}
+		return
+	}
+	if ce.Type != tipoVenta {
+		log.Printf("Evento de tipo desconocido %q en %s", ce.Type, idAuditoria(message))
+		consumer.auditar(ctx, message)
+		return
+	}
+	venta, err := ventaDeEvento(ce, datos)
+	if err != nil {
+		return
+	}
+	rdb := consumer.rdb
+	cfg := consumer.cfg.Get()
+	claves := consumer.claves
+
+	if venta.Envelope != nil && venta.Envelope.Version > versionSobre {
+		log.Printf("Evento %s con sobre v%d, este consumer entiende hasta v%d", venta.Envelope.EventID, venta.Envelope.Version, versionSobre)
+	}
+
+	if venta.Canary {
+		consumer.procesarCanary(ctx, message, venta, recibido)
+		return
+	}
+	nombreCat, ok := nombreCategoria(consumer.registro, cfg.Registry.Unknown, venta.Categoria)
+	if !ok {
+		// Descartada a proposito: queda en la auditoria para que reconcile
+		// no la cuente como perdida.
+		consumer.desconocidas.Sumar(venta.Categoria)
+		consumer.auditar(ctx, message)
 		return
 	}
 
-	nombreCat, existe := categorias[venta.Categoria]
-	if !existe {
-		nombreCat = "Otros"
+	if consumer.fraude != nil && consumer.fraude.Retener(ctx, message, venta, nombreCat) {
+		consumer.auditar(ctx, message)
+		return
 	}
 
-	keyMonitoredName := fmt.Sprintf("producto_monitoreado_nombre:%s", nombreCat)
-	
+	keyMonitoredName := claves.categoria("producto_monitoreado_nombre", nombreCat)
+
 	seAsigno, _ := rdb.SetNX(ctx, keyMonitoredName, venta.ProductoID, 0).Result()
 	if seAsigno {
 		log.Printf("ELEGIDO para %s: %s", nombreCat, venta.ProductoID)
 	}
 
-	productoElegido, _ := rdb.Get(ctx, keyMonitoredName).Result()
+	// El producto monitoreado casi nunca cambia, asi que se puede leer de una
+	// replica; si esta atrasada solo se pierde algun punto del stream.
+	productoElegido := venta.ProductoID
+	if !seAsigno {
+		productoElegido, _ = consumer.lectura.Cliente().Get(ctx, keyMonitoredName).Result()
+	}
 
 	if venta.ProductoID == productoElegido {
-		keyStreamUnico := fmt.Sprintf("stream_precio_producto_unico:%s", nombreCat)
+		keyStreamUnico := claves.categoria("stream_precio_producto_unico", nombreCat)
+		valores := map[string]interface{}{
+			"precio": venta.Precio,
+		}
+		if e := venta.Envelope; e != nil {
+			valores["event_id"] = e.EventID
+			valores["event_time"] = e.EventTime
+		}
 		rdb.XAdd(ctx, &redis.XAddArgs{
 			Stream: keyStreamUnico,
-			MaxLen: 1000, 
-			Values: map[string]interface{}{
-				"precio": venta.Precio,
-			},
+			MaxLen: cfg.Valkey.StreamMaxLen,
+			Values: valores,
 		})
 	}
 
-	keyContador := fmt.Sprintf("contador:%s", nombreCat)
-	keySumaCantidad := fmt.Sprintf("suma_cantidad:%s", nombreCat)
-	keySumaPrecio := fmt.Sprintf("suma_precio:%s", nombreCat)
-	
-	nuevoContador, _ := rdb.Incr(ctx, keyContador).Result()
-	nuevaSumaCant, _ := rdb.IncrBy(ctx, keySumaCantidad, int64(venta.CantidadVendida)).Result()
-	nuevaSumaPrecio, _ := rdb.IncrByFloat(ctx, keySumaPrecio, venta.Precio).Result()
-
-	if nuevoContador > 0 {
-		promProd := float64(nuevaSumaCant) / float64(nuevoContador)
-		rdb.Set(ctx, fmt.Sprintf("promedio_productos:%s", nombreCat), promProd, 0)
-
-		promPrecio := nuevaSumaPrecio / float64(nuevoContador)
-		rdb.Set(ctx, fmt.Sprintf("promedio_precio_tag:%s", nombreCat), promPrecio, 0)
+	if err := claves.actualizarCategoria(ctx, rdb, nombreCat, venta); err != nil {
+		log.Printf("Error actualizando %s: %v", nombreCat, err)
+	}
+	if err := claves.actualizarGlobales(ctx, rdb, venta); err != nil {
+		log.Printf("Error actualizando globales: %v", err)
 	}
+	if vida := cfg.Consumer.TrendingHalfLife; vida > 0 {
+		hora := message.Timestamp
+		if hora.IsZero() {
+			hora = recibido
+		}
+		if err := claves.actualizarTendencias(ctx, rdb, nombreCat, venta, hora, vida); err != nil {
+			log.Printf("Error actualizando tendencias: %v", err)
+		}
+	}
+	if inv := venta.Inventario; inv != nil && inv.Total > 0 {
+		st := float64(inv.Total-inv.Disponible) / float64(inv.Total) * 100
+		if err := rdb.ZAdd(ctx, claves.global("sell_through"), redis.Z{Score: st, Member: venta.ProductoID}).Err(); err != nil {
+			log.Printf("Error actualizando sell-through: %v", err)
+		}
+	}
+	if venta.Campana != nil {
+		if err := claves.actualizarCampana(ctx, rdb, venta); err != nil {
+			log.Printf("Error actualizando campana %s: %v", venta.Campana.ID, err)
+		}
+	}
+	consumer.guardarOriginal(ctx, message, venta, nombreCat)
+	if consumer.anomalias != nil {
+		consumer.anomalias.Observar(nombreCat, venta, recibido)
+	}
+	if consumer.latencias != nil {
+		s := sellosDe(message)
+		s.consumerRecibido, s.valkeyCommit = recibido, time.Now()
+		consumer.latencias.Registrar(nombreCat, s)
+	}
+	consumer.auditar(ctx, message)
+}
 
-	rdb.Incr(ctx, "total_ventas")
-	rdb.ZIncrBy(ctx, "ranking_productos", float64(venta.CantidadVendida), venta.ProductoID)
-	rdb.ZIncrBy(ctx, fmt.Sprintf("ranking_productos_cat:%s", nombreCat), float64(venta.CantidadVendida), venta.ProductoID)
+func (consumer *Consumer) auditar(ctx context.Context, message *sarama.ConsumerMessage) {
+	if ttl := consumer.cfg.Get().Consumer.AuditTTL; ttl > 0 {
+		if err := consumer.claves.registrarAuditoria(ctx, consumer.rdb, message, ttl); err != nil {
+			log.Printf("Error registrando auditoria: %v", err)
+		}
+	}
+}
 
-	currentMax, _ := rdb.Get(ctx, "precio_max_global").Float64()
-	if venta.Precio > currentMax {
-		rdb.Set(ctx, "precio_max_global", venta.Precio, 0)
+// procesarCanary confirma una venta sintetica sin tocar contadores, promedios
+// ni rankings reales: solo marca el id como visto y suma canary_contador.
+func (consumer *Consumer) procesarCanary(ctx context.Context, message *sarama.ConsumerMessage, venta Venta, recibido time.Time) {
+	commit := time.Now()
+	_, err := consumer.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, consumer.claves.canaryVisto(venta.ProductoID), commit.UnixNano(), canaryVistoTTL)
+		pipe.Incr(ctx, consumer.claves.global("canary_contador"))
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error confirmando canary %s: %v", venta.ProductoID, err)
+		return
 	}
-	
-	currentMin, err := rdb.Get(ctx, "precio_min_global").Float64()
-	if err == redis.Nil || venta.Precio < currentMin {
-		rdb.Set(ctx, "precio_min_global", venta.Precio, 0)
+	if consumer.latencias != nil {
+		s := sellosDe(message)
+		s.consumerRecibido, s.valkeyCommit = recibido, time.Now()
+		consumer.latencias.Registrar(categoriaCanary, s)
 	}
-}
\ No newline at end of file
+	consumer.auditar(ctx, message)
+}
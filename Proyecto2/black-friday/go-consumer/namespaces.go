@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// basesAgregados son las bases de todas las familias de claves.
+func basesAgregados() []string {
+	var bases []string
+	for _, b := range familias {
+		bases = append(bases, b...)
+	}
+	sort.Strings(bases)
+	return bases
+}
+
+// basesOperativas guardan estado que no sale de reprocesar Kafka: un replay no
+// las reconstruye, asi que intercambiarNamespace las deja como estan en el
+// destino.
+var basesOperativas = map[string]bool{
+	// Resultados que escribe el comando canary, no el consumer.
+	"canary_resultados": true,
+	// Estado de cada regla de alerta y candado de la replica lider.
+	"alertas_estado": true,
+	"alertas_lider":  true,
+	// Ventas pendientes de revision, decisiones de los revisores y los
+	// registros retenidos que el replay excluye.
+	"fraude_cuarentena": true,
+	"fraude_decisiones": true,
+	"fraude_registros":  true,
+}
+
+// basesReconstruibles son las bases que un replay vuelve a escribir.
+func basesReconstruibles() []string {
+	var bases []string
+	for _, b := range basesAgregados() {
+		if !basesOperativas[b] {
+			bases = append(bases, b)
+		}
+	}
+	return bases
+}
+
+// basesNamespace son todas las bases de clave que forman un namespace: los
+// agregados y los streams de archivo del janitor.
+func basesNamespace() []string {
+	return append(basesAgregados(), "archivo")
+}
+
+// validarNamespace evita prefijos que se confundirian con las claves del
+// namespace por defecto, p.ej. "contador:" haria que "contador:*" incluya
+// claves ajenas.
+func validarNamespace(ns string) error {
+	ns = normalizarNamespace(ns)
+	if ns == "" {
+		return nil
+	}
+	if strings.ContainsAny(ns, "*?[]{} ") {
+		return fmt.Errorf("namespace %q: no puede contener espacios, comodines ni llaves", ns)
+	}
+	for _, base := range append(basesNamespace(), registroNamespaces) {
+		if strings.HasPrefix(ns, base+":") {
+			return fmt.Errorf("namespace %q: choca con las claves %s:*", ns, base)
+		}
+	}
+	return nil
+}
+
+func nombreNamespace(ns string) string {
+	if ns == "" {
+		return "(default)"
+	}
+	return ns
+}
+
+// comandoNamespaces atiende "namespaces list|copy|drop".
+func comandoNamespaces(ctx context.Context, args []string, rdb redis.UniversalClient, claves Claves) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "uso: namespaces list | copy [-replace] <origen> <destino> | drop [-dry-run] <namespace>")
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "list":
+		err = listarNamespaces(ctx, rdb, claves)
+	case "copy":
+		fs := flag.NewFlagSet("namespaces copy", flag.ExitOnError)
+		replace := fs.Bool("replace", false, "sobrescribe claves que ya existen en el destino")
+		fs.Parse(args[1:])
+		if fs.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "uso: namespaces copy [-replace] <origen> <destino>")
+			return 2
+		}
+		err = copiarNamespace(ctx, rdb, claves, fs.Arg(0), fs.Arg(1), *replace)
+	case "drop":
+		fs := flag.NewFlagSet("namespaces drop", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "solo lista las claves que se borrarian")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "uso: namespaces drop [-dry-run] <namespace>")
+			return 2
+		}
+		err = borrarNamespace(ctx, rdb, claves, fs.Arg(0), *dryRun)
+	default:
+		fmt.Fprintf(os.Stderr, "subcomando desconocido %q (list, copy, drop)\n", args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func listarNamespaces(ctx context.Context, rdb redis.UniversalClient, claves Claves) error {
+	registrados, err := rdb.SMembers(ctx, registroNamespaces).Result()
+	if err != nil {
+		return err
+	}
+	sort.Strings(registrados)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tCLAVES")
+	for _, ns := range append([]string{""}, registrados...) {
+		ks, err := clavesConBases(ctx, rdb, claves.EnNamespace(ns), basesNamespace())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%d\n", nombreNamespace(ns), len(ks))
+	}
+	return tw.Flush()
+}
+
+// copiarNamespace copia todas las claves con COPY dentro de una transaccion.
+// En cluster go-redis agrupa la transaccion por slot, asi que es atomica por
+// categoria y no entre categorias.
+func copiarNamespace(ctx context.Context, rdb redis.UniversalClient, claves Claves, origen, destino string, replace bool) error {
+	origen, destino = normalizarNamespace(origen), normalizarNamespace(destino)
+	for _, ns := range []string{origen, destino} {
+		if err := validarNamespace(ns); err != nil {
+			return err
+		}
+	}
+	if origen == destino {
+		return fmt.Errorf("origen y destino son el mismo namespace")
+	}
+	ks, err := clavesConBases(ctx, rdb, claves.EnNamespace(origen), basesNamespace())
+	if err != nil {
+		return err
+	}
+	if len(ks) == 0 {
+		return fmt.Errorf("el namespace %s no tiene claves", nombreNamespace(origen))
+	}
+	if !replace {
+		existentes, err := clavesConBases(ctx, rdb, claves.EnNamespace(destino), basesNamespace())
+		if err != nil {
+			return err
+		}
+		if len(existentes) > 0 {
+			return fmt.Errorf("el namespace %s ya tiene %d claves, use -replace", nombreNamespace(destino), len(existentes))
+		}
+	}
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, k := range ks {
+			args := []any{"COPY", k, destino + strings.TrimPrefix(k, origen)}
+			if replace {
+				args = append(args, "REPLACE")
+			}
+			pipe.Do(ctx, args...)
+		}
+		if destino != "" {
+			pipe.SAdd(ctx, registroNamespaces, destino)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("copiando %s a %s: %w", nombreNamespace(origen), nombreNamespace(destino), err)
+	}
+	fmt.Printf("%d claves copiadas de %s a %s\n", len(ks), nombreNamespace(origen), nombreNamespace(destino))
+	return nil
+}
+
+// borrarNamespace borra las claves del namespace y lo quita del registro en
+// una sola transaccion (por slot en cluster). El namespace por defecto no se
+// puede borrar; para eso esta la politica de retencion.
+func borrarNamespace(ctx context.Context, rdb redis.UniversalClient, claves Claves, ns string, dryRun bool) error {
+	ns = normalizarNamespace(ns)
+	if ns == "" {
+		return fmt.Errorf("no se puede borrar el namespace por defecto")
+	}
+	if err := validarNamespace(ns); err != nil {
+		return err
+	}
+	ks, err := clavesConBases(ctx, rdb, claves.EnNamespace(ns), basesNamespace())
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Printf("DRY-RUN: se borrarian %d claves de %s\n", len(ks), ns)
+		for _, k := range ks {
+			fmt.Println("  " + k)
+		}
+		return nil
+	}
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, k := range ks {
+			pipe.Unlink(ctx, k)
+		}
+		pipe.SRem(ctx, registroNamespaces, ns)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("borrando %s: %w", ns, err)
+	}
+	fmt.Printf("%d claves borradas de %s\n", len(ks), ns)
+	return nil
+}
+
+// intercambiarNamespace reemplaza los agregados de destino por los de nuevo y
+// borra nuevo, todo en una transaccion: los lectores ven los agregados viejos
+// o los reconstruidos, nunca una mezcla (por slot en cluster). Los streams de
+// archivo y las claves operativas del destino se conservan.
+func intercambiarNamespace(ctx context.Context, rdb redis.UniversalClient, claves Claves, nuevo, destino string) (int, error) {
+	nuevo, destino = normalizarNamespace(nuevo), normalizarNamespace(destino)
+	if nuevo == destino {
+		return 0, fmt.Errorf("origen y destino son el mismo namespace")
+	}
+	ks, err := clavesConBases(ctx, rdb, claves.EnNamespace(nuevo), basesReconstruibles())
+	if err != nil {
+		return 0, err
+	}
+	todas, err := clavesConBases(ctx, rdb, claves.EnNamespace(nuevo), basesNamespace())
+	if err != nil {
+		return 0, err
+	}
+	viejas, err := clavesConBases(ctx, rdb, claves.EnNamespace(destino), basesReconstruibles())
+	if err != nil {
+		return 0, err
+	}
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, k := range viejas {
+			pipe.Unlink(ctx, k)
+		}
+		for _, k := range ks {
+			pipe.Do(ctx, "COPY", k, destino+strings.TrimPrefix(k, nuevo), "REPLACE")
+		}
+		for _, k := range todas {
+			pipe.Unlink(ctx, k)
+		}
+		pipe.SRem(ctx, registroNamespaces, nuevo)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("intercambiando %s por %s: %w", nombreNamespace(destino), nombreNamespace(nuevo), err)
+	}
+	return len(ks), nil
+}
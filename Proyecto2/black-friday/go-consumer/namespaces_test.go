@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidarNamespace(t *testing.T) {
+	casos := []struct {
+		ns    string
+		error bool
+	}{
+		{"", false},
+		{"replay-42", false},
+		{"replay-42:", false},
+		{"run *", true},
+		{"run{1}", true},
+		{"contador:x", true},
+		{"archivo:x", true},
+		{"namespaces:x", true},
+	}
+	for _, c := range casos {
+		if err := validarNamespace(c.ns); (err != nil) != c.error {
+			t.Errorf("validarNamespace(%q) = %v", c.ns, err)
+		}
+	}
+}
+
+func TestCopiarNamespace(t *testing.T) {
+	m, rdb := nuevoValkey(t)
+	ctx := context.Background()
+	k := NewClaves(false, "")
+	m.Set("contador:Electronica", "3")
+	m.Set("total_ventas", "3")
+
+	if err := copiarNamespace(ctx, rdb, k, "vacio", "otro", false); err == nil {
+		t.Error("copiar un namespace sin claves deberia fallar")
+	}
+	if err := copiarNamespace(ctx, rdb, k, "", "", false); err == nil {
+		t.Error("copiar un namespace sobre si mismo deberia fallar")
+	}
+	if err := copiarNamespace(ctx, rdb, k, "", "prueba", false); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.Get("prueba:contador:Electronica"); v != "3" {
+		t.Errorf("prueba:contador:Electronica = %q", v)
+	}
+	if ok, _ := m.SIsMember(registroNamespaces, "prueba:"); !ok {
+		t.Error("el destino deberia quedar registrado")
+	}
+
+	m.Set("contador:Electronica", "9")
+	if err := copiarNamespace(ctx, rdb, k, "", "prueba", false); err == nil {
+		t.Error("sin -replace no deberia sobrescribir un namespace con claves")
+	}
+	if err := copiarNamespace(ctx, rdb, k, "", "prueba", true); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.Get("prueba:contador:Electronica"); v != "9" {
+		t.Errorf("con -replace prueba:contador:Electronica = %q", v)
+	}
+
+	if err := borrarNamespace(ctx, rdb, k, "", false); err == nil {
+		t.Error("el namespace por defecto no se puede borrar")
+	}
+	if err := borrarNamespace(ctx, rdb, k, "prueba", true); err != nil || !m.Exists("prueba:total_ventas") {
+		t.Errorf("dry-run no deberia borrar: %v", err)
+	}
+	if err := borrarNamespace(ctx, rdb, k, "prueba", false); err != nil {
+		t.Fatal(err)
+	}
+	for _, clave := range m.Keys() {
+		if strings.HasPrefix(clave, "prueba:") {
+			t.Errorf("quedo %s despues de borrar el namespace", clave)
+		}
+	}
+	if ok, _ := m.SIsMember(registroNamespaces, "prueba:"); ok {
+		t.Error("el namespace borrado deberia salir del registro")
+	}
+}
+
+func TestIntercambiarNamespace(t *testing.T) {
+	m, rdb := nuevoValkey(t)
+	ctx := context.Background()
+	k := NewClaves(false, "")
+
+	// Namespace en vivo: agregados viejos, archivo y estado operativo.
+	m.Set("contador:Electronica", "5")
+	m.Set("contador:Ropa", "2")
+	m.Set("total_ventas", "7")
+	m.XAdd("archivo:contador", "*", []string{"clave", "contador:Hogar"})
+	m.XAdd("canary_resultados", "*", []string{"ok", "1"})
+	m.HSet("fraude_cuarentena", "evt-1", `{"event_id":"evt-1"}`)
+	m.ZAdd("fraude_registros", 1, "0:7")
+	m.HSet("alertas_estado", "stock_bajo", "disparada")
+	m.Set("alertas_lider", "consumer-0")
+
+	// Namespace reconstruido por el replay.
+	m.Set("replay-1:contador:Electronica", "6")
+	m.Set("replay-1:total_ventas", "6")
+	m.XAdd("replay-1:canary_resultados", "*", []string{"ok", "0"})
+	m.HSet("replay-1:fraude_cuarentena", "evt-2", `{"event_id":"evt-2"}`)
+	m.SAdd(registroNamespaces, "replay-1:")
+
+	n, err := intercambiarNamespace(ctx, rdb, k, "replay-1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("se publicaron %d claves, se esperaban 2", n)
+	}
+
+	esperadas := map[string]string{
+		"contador:Electronica": "6",
+		"total_ventas":         "6",
+		"alertas_lider":        "consumer-0",
+	}
+	for clave, valor := range esperadas {
+		if v, err := m.Get(clave); err != nil || v != valor {
+			t.Errorf("%s = %q (%v), se esperaba %q", clave, v, err, valor)
+		}
+	}
+	if m.Exists("contador:Ropa") {
+		t.Error("un agregado que el replay no reconstruyo no deberia sobrevivir")
+	}
+	if s, _ := m.Stream("archivo:contador"); len(s) != 1 {
+		t.Error("el archivo del destino deberia conservarse")
+	}
+	if s, _ := m.Stream("canary_resultados"); len(s) != 1 || s[0].Values[1] != "1" {
+		t.Errorf("canary_resultados en vivo = %v", s)
+	}
+	if v := m.HGet("fraude_cuarentena", "evt-1"); v == "" {
+		t.Error("la cuarentena en vivo no deberia borrarse")
+	}
+	if _, err := m.ZScore("fraude_registros", "0:7"); err != nil {
+		t.Error("los registros retenidos en vivo no deberian borrarse")
+	}
+	if v := m.HGet("fraude_cuarentena", "evt-2"); v != "" {
+		t.Error("la cuarentena del replay no deberia publicarse")
+	}
+	if v := m.HGet("alertas_estado", "stock_bajo"); v != "disparada" {
+		t.Errorf("alertas_estado = %q", v)
+	}
+	for _, clave := range m.Keys() {
+		if strings.HasPrefix(clave, "replay-1:") {
+			t.Errorf("quedo %s despues del intercambio", clave)
+		}
+	}
+	if ok, _ := m.SIsMember(registroNamespaces, "replay-1:"); ok {
+		t.Error("el namespace del replay deberia salir del registro")
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/v9"
+	"go-common/config"
+	"go-common/kafkaconf"
+)
+
+// ventana es el rango [desde, hasta) de timestamps de Kafka a auditar.
+type ventana struct {
+	desde, hasta time.Time
+}
+
+// parseVentana acepta una duracion hacia atras ("1h") o "inicio/fin" en
+// RFC3339.
+func parseVentana(s string, ahora time.Time) (*ventana, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return &ventana{desde: ahora.Add(-d), hasta: ahora}, nil
+	}
+	a, b, ok := strings.Cut(s, "/")
+	desde, err1 := time.Parse(time.RFC3339, a)
+	hasta, err2 := time.Parse(time.RFC3339, b)
+	if !ok || err1 != nil || err2 != nil || !hasta.After(desde) {
+		return nil, fmt.Errorf("-window %q: se espera una duracion (1h) o inicio/fin en RFC3339", s)
+	}
+	return &ventana{desde: desde, hasta: hasta}, nil
+}
+
+func (v *ventana) contiene(t time.Time) bool {
+	return v != nil && !t.Before(v.desde) && t.Before(v.hasta)
+}
+
+// umbralDrift acepta un numero de eventos ("25") o un porcentaje del total
+// de Kafka ("0.5%").
+func umbralDrift(s string, total int64) (int64, error) {
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil || f < 0 {
+			return 0, fmt.Errorf("-max-drift %q invalido", s)
+		}
+		return int64(f / 100 * float64(total)), nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("-max-drift %q invalido", s)
+	}
+	return n, nil
+}
+
+type rangoParticion struct {
+	Particion int32 `json:"particion"`
+	Inicio    int64 `json:"inicio"`
+	Fin       int64 `json:"fin"`
+	Registros int64 `json:"registros"`
+}
+
+type driftCategoria struct {
+	Categoria string `json:"categoria"`
+	Kafka     int64  `json:"kafka"`
+	Valkey    int64  `json:"valkey"`
+	Drift     int64  `json:"drift"`
+}
+
+type auditoriaVentana struct {
+	Desde       time.Time `json:"desde"`
+	Hasta       time.Time `json:"hasta"`
+	Kafka       int       `json:"kafka"`
+	Procesados  int       `json:"procesados"`
+	Faltantes   int       `json:"faltantes"`
+	Duplicados  int       `json:"duplicados"`
+	Inesperados int       `json:"inesperados"`
+	MFaltantes  []string  `json:"muestra_faltantes"`
+	MDuplicados []string  `json:"muestra_duplicados"`
+}
+
+type reporteReconciliacion struct {
+	Namespace   string            `json:"namespace"`
+	Grupo       string            `json:"group"`
+	Particiones []rangoParticion  `json:"particiones"`
+	Categorias  []driftCategoria  `json:"categorias"`
+	Total       driftCategoria    `json:"total"`
+	Canary      *driftCategoria   `json:"canary,omitempty"`
+	Ventana     *auditoriaVentana `json:"ventana,omitempty"`
+	Umbral      int64             `json:"umbral"`
+	Excedido    bool              `json:"excedido"`
+	Notas       []string          `json:"notas,omitempty"`
+}
+
+// comandoReconciliar compara los registros de Kafka con los contadores de
+// Valkey. Por defecto cuenta hasta los offsets confirmados del group en vivo,
+// que es exactamente lo que el consumer ya aplico. Devuelve 3 si el drift
+// supera -max-drift.
+func comandoReconciliar(ctx context.Context, args []string, rdb redis.UniversalClient, claves Claves, loader *config.Loader) int {
+	cfg := loader.Get()
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	ns := fs.String("namespace", claves.prefijo, "namespace de Valkey a comparar")
+	grupo := fs.String("group", cfg.Kafka.Group, "consumer group cuyos offsets confirmados marcan el fin del rango")
+	hasta := fs.String("to", "group", "fin del rango por particion: group (offsets confirmados) o hwm")
+	vent := fs.String("window", "", "ventana a auditar por evento: duracion (1h) o inicio/fin RFC3339")
+	muestra := fs.Int("sample", 10, "cantidad de ids de ejemplo por tipo de problema")
+	maxDrift := fs.String("max-drift", "0", "drift tolerado: eventos (25) o porcentaje (0.5%)")
+	comoJSON := fs.Bool("json", false, "imprime el reporte en JSON")
+	fs.Parse(args)
+
+	if err := validarNamespace(*ns); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *hasta != "group" && *hasta != "hwm" {
+		fmt.Fprintln(os.Stderr, "reconcile: -to debe ser group o hwm")
+		return 2
+	}
+	v, err := parseVentana(*vent, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	k := claves.EnNamespace(*ns)
+	rep := reporteReconciliacion{Namespace: nombreNamespace(k.prefijo), Grupo: *grupo}
+
+	saramaCfg, err := configSarama(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error configurando Kafka: %v\n", err)
+		return 1
+	}
+	client, err := sarama.NewClient(cfg.Kafka.Brokers, saramaCfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error conectando a Kafka: %v\n", kafkaconf.Explicar(err))
+		return 1
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+		return 1
+	}
+	defer admin.Close()
+
+	topic := cfg.Kafka.Topic
+	particiones, err := client.Partitions(topic)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+		return 1
+	}
+	var confirmados *sarama.OffsetFetchResponse
+	if *hasta == "group" {
+		confirmados, err = admin.ListConsumerGroupOffsets(*grupo, map[string][]int32{topic: particiones})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+			return 1
+		}
+	}
+
+	// Se leen los registros de cada rango para contar por categoria y, dentro
+	// de la ventana, guardar sus ids.
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+		return 1
+	}
+	defer consumer.Close()
+	porCategoria := map[string]int64{}
+	registro := nuevoRegistro(ctx, rdb, cfg)
+	var canaries, descartadas, enCuarentena int64
+	idsKafka := map[string]bool{}
+	retenidas, err := retenidasPorRegistro(ctx, rdb, k, cfg.Fraud)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ajustes := nuevoSeguimientoAjustes(cfg.Consumer.RefundWindow, cfg.Auth.AdminIdentities)
+	for _, p := range particiones {
+		inicio, err := client.GetOffset(topic, p, sarama.OffsetOldest)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+			return 1
+		}
+		fin, err := client.GetOffset(topic, p, sarama.OffsetNewest)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+			return 1
+		}
+		if confirmados != nil {
+			if b := confirmados.GetBlock(topic, p); b != nil {
+				fin = min(fin, max(b.Offset, inicio))
+			}
+		}
+		if inicio > 0 {
+			rep.Notas = append(rep.Notas, fmt.Sprintf("la particion %d ya no tiene los offsets [0, %d) por la retencion de Kafka; Valkey puede tener mas que Kafka", p, inicio))
+		}
+		rp := rangoParticion{Particion: p, Inicio: inicio, Fin: fin}
+		if fin > inicio {
+			n, err := leerRango(consumer, topic, p, inicio, fin, func(m *sarama.ConsumerMessage) {
+				ce, datos, err := leerEvento(m)
+				if err != nil {
+					return
+				}
+				// Todo lo que el consumer decodifica queda en la auditoria,
+				// tambien los ajustes.
+				if v.contiene(m.Timestamp) {
+					idsKafka[idAuditoria(m)] = true
+				}
+				if esAjuste(ce.Type) {
+					if aj, err := ajusteDeEvento(ce, datos); err == nil {
+						ajustes.ajuste(m, aj)
+					}
+					return
+				}
+				if ce.Type != tipoVenta {
+					return
+				}
+				venta, err := ventaDeEvento(ce, datos)
+				if err != nil {
+					return
+				}
+				// Las ventas del canary no tocan los contadores por categoria,
+				// se comparan aparte con canary_contador.
+				if venta.Canary {
+					canaries++
+				} else if retenidas[idAuditoria(m)] {
+					enCuarentena++
+				} else if n, ok := nombreCategoria(registro, cfg.Registry.Unknown, venta.Categoria); ok {
+					porCategoria[n]++
+					ajustes.venta(m, venta, n)
+				} else {
+					descartadas++
+				}
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "leyendo la particion %d: %v\n", p, err)
+				return 1
+			}
+			rp.Registros = n
+		}
+		rep.Particiones = append(rep.Particiones, rp)
+	}
+
+	// Una venta revertida por completo ya no cuenta en contador ni en
+	// total_ventas.
+	var revertidas int64
+	for cat, n := range ajustes.revertidas {
+		porCategoria[cat] -= n
+		revertidas += n
+	}
+	if revertidas > 0 {
+		rep.Notas = append(rep.Notas, fmt.Sprintf("%d ventas revertidas por completo con reembolsos o cancelaciones se descuentan", revertidas))
+	}
+	if ajustes.sinOriginal > 0 {
+		rep.Notas = append(rep.Notas, fmt.Sprintf("%d ajustes de ventas que no estan en el rango leido no se pueden verificar", ajustes.sinOriginal))
+	}
+
+	if enCuarentena > 0 {
+		rep.Notas = append(rep.Notas, fmt.Sprintf("%d ventas en cuarentena por fraude se excluyen; las liberadas se cuentan como un registro nuevo", enCuarentena))
+	}
+
+	// Valkey: contador por categoria y total_ventas.
+	if descartadas > 0 {
+		rep.Notas = append(rep.Notas, fmt.Sprintf("%d ventas con categorias no registradas se excluyen (category_registry.unknown reject)", descartadas))
+	}
+	nombres := map[string]bool{categoriaOtros: true}
+	for _, c := range registro.Lista() {
+		nombres[c.Slug] = true
+	}
+	for n := range porCategoria {
+		nombres[n] = true
+	}
+	var lista []string
+	for n := range nombres {
+		lista = append(lista, n)
+	}
+	sort.Strings(lista)
+	for _, n := range lista {
+		enValkey, err := rdb.Get(ctx, k.categoria("contador", n)).Int64()
+		if err != nil && err != redis.Nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if enValkey == 0 && porCategoria[n] == 0 {
+			continue
+		}
+		d := driftCategoria{Categoria: n, Kafka: porCategoria[n], Valkey: enValkey, Drift: enValkey - porCategoria[n]}
+		rep.Categorias = append(rep.Categorias, d)
+		rep.Total.Kafka += d.Kafka
+	}
+	total, err := rdb.Get(ctx, k.global("total_ventas")).Int64()
+	if err != nil && err != redis.Nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	rep.Total = driftCategoria{Categoria: "total_ventas", Kafka: rep.Total.Kafka, Valkey: total, Drift: total - rep.Total.Kafka}
+	enValkey, err := rdb.Get(ctx, k.global("canary_contador")).Int64()
+	if err != nil && err != redis.Nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if canaries > 0 || enValkey > 0 {
+		rep.Canary = &driftCategoria{Categoria: "canary", Kafka: canaries, Valkey: enValkey, Drift: enValkey - canaries}
+	}
+	if spec, ok := cfg.Retention.Policies["contador"]; ok && !strings.HasPrefix(spec, "none") {
+		rep.Notas = append(rep.Notas, fmt.Sprintf("la familia contador tiene retencion %s; los totales no son comparables, use -window", spec))
+	}
+
+	if v != nil {
+		a, err := auditar(ctx, rdb, k, v, idsKafka, *muestra)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if a.Procesados == 0 && a.Kafka > 0 {
+			rep.Notas = append(rep.Notas, "no hay libro de auditoria en la ventana; active consumer.audit_ttl")
+		}
+		rep.Ventana = a
+	}
+
+	rep.Umbral, err = umbralDrift(*maxDrift, rep.Total.Kafka)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	peor := abs(rep.Total.Drift)
+	for _, c := range rep.Categorias {
+		peor = max(peor, abs(c.Drift))
+	}
+	if rep.Ventana != nil {
+		peor = max(peor, int64(rep.Ventana.Faltantes+rep.Ventana.Duplicados))
+	}
+	rep.Excedido = peor > rep.Umbral
+
+	if *comoJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+	} else {
+		imprimirReconciliacion(rep)
+	}
+	if rep.Excedido {
+		return 3
+	}
+	return 0
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// leerRango consume [inicio, fin) de una particion. Falla si no llegan
+// mensajes durante 10s, p.ej. porque el rango cae en un hueco.
+func leerRango(consumer sarama.Consumer, topic string, p int32, inicio, fin int64, fn func(*sarama.ConsumerMessage)) (int64, error) {
+	pc, err := consumer.ConsumePartition(topic, p, inicio)
+	if err != nil {
+		return 0, kafkaconf.Explicar(err)
+	}
+	defer pc.Close()
+	var n int64
+	for {
+		select {
+		case m := <-pc.Messages():
+			if m.Offset >= fin {
+				return n, nil
+			}
+			fn(m)
+			n++
+			if m.Offset >= fin-1 {
+				return n, nil
+			}
+		case err := <-pc.Errors():
+			return n, err
+		case <-time.After(10 * time.Second):
+			return n, fmt.Errorf("sin mensajes despues del offset %d (fin %d)", inicio+n, fin)
+		}
+	}
+}
+
+// auditar compara los ids de Kafka de la ventana con el libro que escribe el
+// consumer, minuto por minuto.
+func auditar(ctx context.Context, rdb redis.UniversalClient, k Claves, v *ventana, idsKafka map[string]bool, muestra int) (*auditoriaVentana, error) {
+	a := &auditoriaVentana{Desde: v.desde, Hasta: v.hasta, Kafka: len(idsKafka)}
+	procesados := map[string]int64{}
+	for t := v.desde.UTC().Truncate(time.Minute); t.Before(v.hasta); t = t.Add(time.Minute) {
+		cuentas, err := rdb.HGetAll(ctx, k.claveAuditoria(t)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for id, c := range cuentas {
+			n, _ := strconv.ParseInt(c, 10, 64)
+			procesados[id] += n
+		}
+	}
+	a.Procesados = len(procesados)
+	var faltantes, duplicados []string
+	for id := range idsKafka {
+		switch n := procesados[id]; {
+		case n == 0:
+			faltantes = append(faltantes, id)
+		case n > 1:
+			duplicados = append(duplicados, fmt.Sprintf("%s (x%d)", id, n))
+		}
+	}
+	for id := range procesados {
+		// El libro se agrupa por minuto, asi que puede traer registros de
+		// los bordes que quedan fuera de la ventana exacta.
+		if !idsKafka[id] && len(idsKafka) > 0 {
+			a.Inesperados++
+		}
+	}
+	sort.Strings(faltantes)
+	sort.Strings(duplicados)
+	a.Faltantes, a.Duplicados = len(faltantes), len(duplicados)
+	a.MFaltantes = faltantes[:min(muestra, len(faltantes))]
+	a.MDuplicados = duplicados[:min(muestra, len(duplicados))]
+	return a, nil
+}
+
+func imprimirReconciliacion(rep reporteReconciliacion) {
+	fmt.Printf("Namespace %s, fin del rango segun %s\n\n", rep.Namespace, rep.Grupo)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "PARTICION\tINICIO\tFIN\tREGISTROS\t")
+	for _, p := range rep.Particiones {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t\n", p.Particion, p.Inicio, p.Fin, p.Registros)
+	}
+	tw.Flush()
+	fmt.Println()
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "CATEGORIA\tKAFKA\tVALKEY\tDRIFT\t")
+	for _, c := range append(rep.Categorias, rep.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%+d\t\n", c.Categoria, c.Kafka, c.Valkey, c.Drift)
+	}
+	if c := rep.Canary; c != nil {
+		fmt.Fprintf(tw, "(%s)\t%d\t%d\t%+d\t\n", c.Categoria, c.Kafka, c.Valkey, c.Drift)
+	}
+	tw.Flush()
+	if a := rep.Ventana; a != nil {
+		fmt.Printf("\nVentana %s - %s: %d en Kafka, %d procesados, %d faltantes, %d duplicados, %d fuera de la ventana\n",
+			a.Desde.Format(time.RFC3339), a.Hasta.Format(time.RFC3339), a.Kafka, a.Procesados, a.Faltantes, a.Duplicados, a.Inesperados)
+		if len(a.MFaltantes) > 0 {
+			fmt.Println("  faltantes (particion:offset): " + strings.Join(a.MFaltantes, ", "))
+		}
+		if len(a.MDuplicados) > 0 {
+			fmt.Println("  duplicados (particion:offset): " + strings.Join(a.MDuplicados, ", "))
+		}
+	}
+	for _, n := range rep.Notas {
+		fmt.Println("nota: " + n)
+	}
+	estado := "OK"
+	if rep.Excedido {
+		estado = "EXCEDIDO"
+	}
+	fmt.Printf("\nDrift tolerado %d: %s\n", rep.Umbral, estado)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func TestParseVentana(t *testing.T) {
+	ahora := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	casos := []struct {
+		entrada      string
+		desde, hasta time.Time
+		nula, error  bool
+	}{
+		{entrada: "", nula: true},
+		{entrada: "1h", desde: ahora.Add(-time.Hour), hasta: ahora},
+		{entrada: "2026-10-19T10:00:00Z/2026-10-19T11:00:00Z",
+			desde: time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC), hasta: time.Date(2026, 10, 19, 11, 0, 0, 0, time.UTC)},
+		{entrada: "2026-10-19T11:00:00Z/2026-10-19T10:00:00Z", error: true},
+		{entrada: "2026-10-19T10:00:00Z", error: true},
+		{entrada: "-1h", error: true},
+		{entrada: "ayer", error: true},
+	}
+	for _, c := range casos {
+		v, err := parseVentana(c.entrada, ahora)
+		switch {
+		case c.error:
+			if err == nil {
+				t.Errorf("parseVentana(%q) deberia fallar", c.entrada)
+			}
+		case err != nil:
+			t.Errorf("parseVentana(%q): %v", c.entrada, err)
+		case c.nula:
+			if v != nil {
+				t.Errorf("parseVentana(%q) = %+v, se esperaba sin ventana", c.entrada, v)
+			}
+		case !v.desde.Equal(c.desde) || !v.hasta.Equal(c.hasta):
+			t.Errorf("parseVentana(%q) = %s..%s", c.entrada, v.desde, v.hasta)
+		}
+	}
+
+	v := &ventana{desde: ahora.Add(-time.Hour), hasta: ahora}
+	if !v.contiene(ahora.Add(-time.Hour)) || v.contiene(ahora) || (*ventana)(nil).contiene(ahora) {
+		t.Error("la ventana es [desde, hasta)")
+	}
+}
+
+func TestUmbralDrift(t *testing.T) {
+	casos := []struct {
+		entrada string
+		total   int64
+		umbral  int64
+		error   bool
+	}{
+		{"0", 1000, 0, false},
+		{"25", 1000, 25, false},
+		{"0.5%", 1000, 5, false},
+		{"10%", 55, 5, false},
+		{"-1", 1000, 0, true},
+		{"x%", 1000, 0, true},
+		{"-1%", 1000, 0, true},
+	}
+	for _, c := range casos {
+		n, err := umbralDrift(c.entrada, c.total)
+		if (err != nil) != c.error || n != c.umbral {
+			t.Errorf("umbralDrift(%q, %d) = %d, %v", c.entrada, c.total, n, err)
+		}
+	}
+}
+
+func TestAuditar(t *testing.T) {
+	_, rdb := nuevoValkey(t)
+	ctx := context.Background()
+	k := NewClaves(false, "")
+	desde := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	registrar := func(p int32, off int64, ts time.Time) {
+		t.Helper()
+		m := &sarama.ConsumerMessage{Partition: p, Offset: off, Timestamp: ts}
+		if err := k.registrarAuditoria(ctx, rdb, m, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registrar(0, 1, desde.Add(10*time.Second))
+	registrar(0, 2, desde.Add(70*time.Second))
+	registrar(0, 2, desde.Add(70*time.Second))
+	// Fuera de Kafka pero en un minuto de la ventana.
+	registrar(1, 9, desde.Add(80*time.Second))
+
+	idsKafka := map[string]bool{"0:1": true, "0:2": true, "0:3": true}
+	a, err := auditar(ctx, rdb, k, &ventana{desde: desde, hasta: desde.Add(2 * time.Minute)}, idsKafka, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Kafka != 3 || a.Procesados != 3 || a.Faltantes != 1 || a.Duplicados != 1 || a.Inesperados != 1 {
+		t.Errorf("auditoria %+v", a)
+	}
+	if len(a.MFaltantes) != 1 || a.MFaltantes[0] != "0:3" {
+		t.Errorf("muestra de faltantes %v", a.MFaltantes)
+	}
+	if len(a.MDuplicados) != 1 || a.MDuplicados[0] != "0:2 (x2)" {
+		t.Errorf("muestra de duplicados %v", a.MDuplicados)
+	}
+
+	a, err = auditar(ctx, rdb, k, &ventana{desde: desde, hasta: desde.Add(2 * time.Minute)}, idsKafka, 0)
+	if err != nil || len(a.MFaltantes) != 0 || a.Faltantes != 1 {
+		t.Errorf("con muestra 0 solo se cuentan: %+v (%v)", a, err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"go-common/categorias"
+	"go-common/config"
+)
+
+// categoriaOtros agrega los ids no registrados con category_registry.unknown
+// other.
+const categoriaOtros = "Otros"
+
+// nuevoRegistro carga el registro de categorias segun category_registry.source.
+func nuevoRegistro(ctx context.Context, rdb redis.UniversalClient, cfg *config.Config) *categorias.Registro {
+	if cfg.Registry.Source != "valkey" {
+		rdb = nil
+	}
+	reg := categorias.NewRegistro(rdb, categoriasDeConfig(cfg))
+	if err := reg.Cargar(ctx); err != nil {
+		log.Printf("Registro de categorias: se usa el de config hasta poder leer Valkey: %v", err)
+	}
+	return reg
+}
+
+// nombreCategoria devuelve el slug con el que se nombran las claves de la
+// categoria. Un id no registrado va a "Otros" solo con la politica other; con
+// reject se devuelve false y la venta no se agrega.
+func nombreCategoria(reg *categorias.Registro, politica string, id int32) (string, bool) {
+	if cat, ok := reg.Buscar(id); ok {
+		return cat.Slug, true
+	}
+	return categoriaOtros, politica == "other"
+}
+
+// Desconocidas cuenta por id las ventas con categorias no registradas desde
+// que arranco el consumer.
+type Desconocidas struct {
+	mu    sync.Mutex
+	porID map[int32]int64
+}
+
+func (d *Desconocidas) Sumar(id int32) {
+	d.mu.Lock()
+	if d.porID == nil {
+		d.porID = map[int32]int64{}
+	}
+	d.porID[id]++
+	n := d.porID[id]
+	d.mu.Unlock()
+	// Se avisa la primera vez y luego cada 1000 para no llenar el log.
+	if n == 1 || n%1000 == 0 {
+		log.Printf("Categoria %d no registrada: %d ventas", id, n)
+	}
+}
+
+func (d *Desconocidas) Copia() map[string]int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m := make(map[string]int64, len(d.porID))
+	for id, n := range d.porID {
+		m[strconv.Itoa(int(id))] = n
+	}
+	return m
+}
+
+type estadoCategorias struct {
+	Politica     string                 `json:"politica_desconocidas"`
+	Categorias   []categorias.Categoria `json:"categorias"`
+	Desconocidas map[string]int64       `json:"desconocidas"`
+}
+
+func (consumer *Consumer) handleCategorias(w http.ResponseWriter, r *http.Request) {
+	responderJSON(w, http.StatusOK, estadoCategorias{
+		Politica:     consumer.cfg.Get().Registry.Unknown,
+		Categorias:   consumer.registro.Lista(),
+		Desconocidas: consumer.desconocidas.Copia(),
+	})
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go-common/config"
+)
+
+// Estados de una regla en el hash alertas_estado.
+const (
+	reglaDisparada = "disparada"
+	reglaResuelta  = "resuelta"
+)
+
+// liderScript toma o renueva el candado KEYS[1] para ARGV[1] por ARGV[2] ms.
+// Devuelve 1 si la replica es la que evalua las reglas.
+var liderScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if v == ARGV[1] then
+  redis.call('PEXPIRE', KEYS[1], ARGV[2])
+  return 1
+end
+if not v then
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+  return 1
+end
+return 0
+`)
+
+// transicionScript guarda el ultimo valor de la regla ARGV[1] y, si ARGV[2]
+// no esta vacio, la pasa a ese estado. Devuelve 1 si hay que avisar, 0 si la
+// regla ya estaba en ese estado y -1 si volvio a disparar antes del cooldown.
+// ARGV[3] es ahora y ARGV[4] el cooldown, en ms; ARGV[6] es desde cuando se
+// cumple la condicion.
+var transicionScript = redis.NewScript(`
+local r = ARGV[1]
+redis.call('HSET', KEYS[1], r .. ':valor', ARGV[5])
+if ARGV[2] == '' then
+  return 0
+end
+local estado = redis.call('HGET', KEYS[1], r .. ':estado') or 'resuelta'
+if estado == ARGV[2] then
+  return 0
+end
+if ARGV[2] == 'disparada' then
+  local ult = tonumber(redis.call('HGET', KEYS[1], r .. ':notificada') or '0')
+  if tonumber(ARGV[3]) - ult < tonumber(ARGV[4]) then
+    return -1
+  end
+  redis.call('HSET', KEYS[1], r .. ':notificada', ARGV[3])
+end
+redis.call('HSET', KEYS[1], r .. ':estado', ARGV[2], r .. ':desde', ARGV[6])
+return 1
+`)
+
+// Aviso es una regla que cambio de estado, tal como llega al webhook.
+type Aviso struct {
+	Regla      string    `json:"regla"`
+	Estado     string    `json:"estado"`
+	Severidad  string    `json:"severidad"`
+	Metrica    string    `json:"metrica"`
+	Categoria  string    `json:"categoria,omitempty"`
+	Valor      float64   `json:"valor"`
+	Comparador string    `json:"comparador"`
+	Umbral     float64   `json:"umbral"`
+	Desde      time.Time `json:"desde"`
+	Hora       time.Time `json:"hora"`
+	Mensaje    string    `json:"mensaje"`
+
+	grupo    string
+	webhooks []string
+}
+
+// notificacion es el body de cada POST: los avisos de un grupo en una misma
+// evaluacion.
+type notificacion struct {
+	Grupo      string  `json:"grupo"`
+	Disparadas int     `json:"disparadas"`
+	Resueltas  int     `json:"resueltas"`
+	Alertas    []Aviso `json:"alertas"`
+}
+
+// estadoRegla es lo que la replica lider recuerda de cada regla entre
+// evaluaciones.
+type estadoRegla struct {
+	firma  string
+	desde  time.Time
+	previo float64
+	tPrev  time.Time
+}
+
+// Reglas evalua las reglas de alerting.rules. Con varias replicas solo evalua
+// la que tiene el candado alertas_lider; el estado de cada regla vive en
+// Valkey, asi que un cambio de lider no repite ni pierde avisos.
+type Reglas struct {
+	rdb     redis.UniversalClient
+	claves  Claves
+	cfg     *config.Loader
+	id      string
+	http    *http.Client
+	estados map[string]*estadoRegla
+}
+
+func NewReglas(rdb redis.UniversalClient, claves Claves, loader *config.Loader) *Reglas {
+	id, err := os.Hostname()
+	if err != nil {
+		id = "consumer"
+	}
+	return &Reglas{
+		rdb:     rdb,
+		claves:  claves,
+		cfg:     loader,
+		id:      fmt.Sprintf("%s-%d", id, os.Getpid()),
+		http:    &http.Client{},
+		estados: map[string]*estadoRegla{},
+	}
+}
+
+// Correr evalua las reglas cada intervalo hasta que ctx termina.
+func (rs *Reglas) Correr(ctx context.Context, intervalo time.Duration) {
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			rs.evaluar(ctx, t)
+		}
+	}
+}
+
+func (rs *Reglas) evaluar(ctx context.Context, ahora time.Time) {
+	cfg := rs.cfg.Get().Alerting
+	lider, err := liderScript.Run(ctx, rs.rdb, []string{rs.claves.global("alertas_lider")}, rs.id, (3 * cfg.Interval).Milliseconds()).Int()
+	if err != nil {
+		log.Printf("Alertas: no se pudo tomar el candado: %v", err)
+		return
+	}
+	if lider == 0 {
+		// Si vuelve a ser lider empieza de cero: las tasas y los for
+		// dependen de muestras seguidas.
+		clear(rs.estados)
+		return
+	}
+
+	hash := rs.claves.global("alertas_estado")
+	vigentes := map[string]bool{}
+	var avisos []Aviso
+	for _, r := range cfg.Rules {
+		vigentes[r.Name] = true
+		e := rs.estados[r.Name]
+		firma := r.Metric + "|" + r.Category
+		if e == nil || e.firma != firma {
+			e = &estadoRegla{firma: firma}
+			rs.estados[r.Name] = e
+		}
+		valor, ok, err := rs.leer(ctx, r, e, ahora)
+		if err != nil {
+			log.Printf("Alertas: regla %s: %v", r.Name, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		deseado := ""
+		if comparar(valor, r.Comparator, r.Threshold) {
+			if e.desde.IsZero() {
+				e.desde = ahora
+			}
+			if ahora.Sub(e.desde) >= r.For {
+				deseado = reglaDisparada
+			}
+		} else {
+			e.desde = time.Time{}
+			deseado = reglaResuelta
+		}
+		cooldown := r.Cooldown
+		if cooldown == 0 {
+			cooldown = cfg.Cooldown
+		}
+		desde := ahora
+		if deseado == reglaDisparada {
+			desde = e.desde
+		}
+		res, err := transicionScript.Run(ctx, rs.rdb, []string{hash},
+			r.Name, deseado, ahora.UnixMilli(), cooldown.Milliseconds(),
+			strconv.FormatFloat(valor, 'f', -1, 64), desde.UnixMilli()).Int()
+		if err != nil {
+			log.Printf("Alertas: regla %s: %v", r.Name, err)
+			continue
+		}
+		if res == 1 {
+			avisos = append(avisos, nuevoAviso(r, deseado, valor, desde, ahora))
+		}
+	}
+	for nombre := range rs.estados {
+		if !vigentes[nombre] {
+			delete(rs.estados, nombre)
+		}
+	}
+	rs.olvidar(ctx, hash, vigentes)
+	rs.notificar(cfg, avisos)
+}
+
+// olvidar borra el estado de las reglas que ya no estan en la configuracion,
+// para que si vuelven no arranquen disparadas.
+func (rs *Reglas) olvidar(ctx context.Context, hash string, vigentes map[string]bool) {
+	campos, err := rs.rdb.HKeys(ctx, hash).Result()
+	if err != nil {
+		return
+	}
+	var viejos []string
+	for _, c := range campos {
+		if i := strings.LastIndexByte(c, ':'); i > 0 && !vigentes[c[:i]] {
+			viejos = append(viejos, c)
+		}
+	}
+	if len(viejos) > 0 {
+		rs.rdb.HDel(ctx, hash, viejos...)
+	}
+}
+
+// leer devuelve el valor actual de la metrica de la regla. ok es false si no
+// hay dato: un maximo o un promedio que todavia no existe, o la primera
+// muestra de una tasa. Los contadores que no existen valen 0, p.ej. despues
+// del reset diario.
+func (rs *Reglas) leer(ctx context.Context, r config.AlertRule, e *estadoRegla, ahora time.Time) (float64, bool, error) {
+	k := rs.claves
+	var clave string
+	switch r.Metric {
+	case "ventas_por_minuto":
+		clave = k.global("total_ventas")
+		if r.Category != "" {
+			clave = k.categoria("contador", r.Category)
+		}
+	case "unidades_por_minuto":
+		clave = k.categoria("suma_cantidad", r.Category)
+	case "total_ventas", "precio_max_global", "precio_min_global":
+		clave = k.global(r.Metric)
+	default:
+		clave = k.categoria(r.Metric, r.Category)
+	}
+	v, err := rs.rdb.Get(ctx, clave).Float64()
+	switch {
+	case errors.Is(err, redis.Nil):
+		switch r.Metric {
+		case "precio_max_global", "precio_min_global", "promedio_productos", "promedio_precio_tag":
+			return 0, false, nil
+		}
+		v = 0
+	case err != nil:
+		return 0, false, err
+	}
+	if !strings.HasSuffix(r.Metric, "_por_minuto") {
+		return v, true, nil
+	}
+	previo, tPrev := e.previo, e.tPrev
+	e.previo, e.tPrev = v, ahora
+	if tPrev.IsZero() {
+		return 0, false, nil
+	}
+	delta := v - previo
+	if delta < 0 {
+		// El contador se reinicio entre las dos muestras.
+		delta = v
+	}
+	return delta / ahora.Sub(tPrev).Minutes(), true, nil
+}
+
+func comparar(v float64, comparador string, umbral float64) bool {
+	switch comparador {
+	case ">":
+		return v > umbral
+	case ">=":
+		return v >= umbral
+	case "<":
+		return v < umbral
+	case "<=":
+		return v <= umbral
+	case "==":
+		return v == umbral
+	case "!=":
+		return v != umbral
+	}
+	return false
+}
+
+func nuevoAviso(r config.AlertRule, estado string, valor float64, desde, ahora time.Time) Aviso {
+	a := Aviso{
+		Regla:      r.Name,
+		Estado:     estado,
+		Severidad:  r.Severity,
+		Metrica:    r.Metric,
+		Categoria:  r.Category,
+		Valor:      valor,
+		Comparador: r.Comparator,
+		Umbral:     r.Threshold,
+		Desde:      desde,
+		Hora:       ahora,
+		grupo:      r.Group,
+		webhooks:   r.Webhooks,
+	}
+	if a.Severidad == "" {
+		a.Severidad = "warning"
+	}
+	if a.grupo == "" {
+		a.grupo = a.Severidad
+	}
+	metrica := r.Metric
+	if r.Category != "" {
+		metrica += " de " + r.Category
+	}
+	if estado == reglaDisparada {
+		a.Mensaje = fmt.Sprintf("[%s] %s: %s = %g (%s %g) desde %s",
+			strings.ToUpper(a.Severidad), r.Name, metrica, valor, r.Comparator, r.Threshold, desde.Format(time.RFC3339))
+	} else {
+		a.Mensaje = fmt.Sprintf("[RESUELTA] %s: %s = %g", r.Name, metrica, valor)
+	}
+	return a
+}
+
+// notificar junta los avisos por webhook y grupo y envia cada lote sin
+// demorar la siguiente evaluacion.
+func (rs *Reglas) notificar(cfg config.Alerting, avisos []Aviso) {
+	for _, wh := range cfg.Webhooks {
+		grupos := map[string]*notificacion{}
+		for _, a := range avisos {
+			if len(a.webhooks) > 0 && !slices.Contains(a.webhooks, wh.Name) {
+				continue
+			}
+			n := grupos[a.grupo]
+			if n == nil {
+				n = &notificacion{Grupo: a.grupo}
+				grupos[a.grupo] = n
+			}
+			n.Alertas = append(n.Alertas, a)
+			if a.Estado == reglaDisparada {
+				n.Disparadas++
+			} else {
+				n.Resueltas++
+			}
+		}
+		nombres := make([]string, 0, len(grupos))
+		for g := range grupos {
+			nombres = append(nombres, g)
+		}
+		sort.Strings(nombres)
+		for _, g := range nombres {
+			log.Printf("Alertas: %s grupo %s: %d disparadas, %d resueltas", wh.Name, g, grupos[g].Disparadas, grupos[g].Resueltas)
+			go rs.enviar(wh, grupos[g], cfg.Timeout, cfg.Retries)
+		}
+	}
+}
+
+// enviar hace el POST con reintentos y espera exponencial (1s, 2s, 4s...).
+func (rs *Reglas) enviar(wh config.Webhook, n *notificacion, timeout time.Duration, reintentos int) {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return
+	}
+	for intento := 0; ; intento++ {
+		err = rs.post(wh.URL, body, timeout)
+		if err == nil {
+			return
+		}
+		if intento >= reintentos {
+			log.Printf("Alertas: no se pudo avisar a %s: %v", wh.Name, err)
+			return
+		}
+		time.Sleep(time.Duration(1<<intento) * time.Second)
+	}
+}
+
+func (rs *Reglas) post(url string, body []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := rs.http.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("respuesta %s", res.Status)
+	}
+	return nil
+}
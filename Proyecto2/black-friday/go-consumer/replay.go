@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/redis/go-redis/v9"
+	"go-common/config"
+	"go-common/kafkaconf"
+)
+
+// replayer procesa el topic con la misma logica del consumer pero escribiendo
+// en otro namespace. Los offsets de inicio se confirman en el group antes de
+// unirse, asi la primera sesion y las que siguen a un rebalance arrancan de lo
+// confirmado.
+type replayer struct {
+	consumer *Consumer
+	topic    string
+	inicio   map[int32]int64
+	// retenidas son particion:offset de las ventas que el consumer en vivo
+	// dejo en cuarentena. El replay no evalua fraude y no debe contarlas; las
+	// liberadas vuelven al topic como registros nuevos y se cuentan ahi.
+	retenidas map[string]bool
+
+	pos        map[int32]*atomic.Int64
+	procesados atomic.Int64
+}
+
+func (r *replayer) Setup(sarama.ConsumerGroupSession) error { return nil }
+
+func (r *replayer) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (r *replayer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for message := range claim.Messages() {
+		if !r.retenidas[idAuditoria(message)] {
+			r.consumer.procesarMensaje(session.Context(), message)
+		}
+		session.MarkMessage(message, "")
+		r.pos[message.Partition].Store(message.Offset + 1)
+		r.procesados.Add(1)
+	}
+	return nil
+}
+
+// parseDesde acepta "earliest", un offset para todas las particiones
+// ("1500"), offsets por particion ("0=1500,1=1200") o un instante RFC3339.
+func parseDesde(s string) (func(client sarama.Client, topic string, p int32) (int64, error), error) {
+	if s == "" || s == "earliest" {
+		return func(client sarama.Client, topic string, p int32) (int64, error) {
+			return client.GetOffset(topic, p, sarama.OffsetOldest)
+		}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return func(client sarama.Client, topic string, p int32) (int64, error) {
+			off, err := client.GetOffset(topic, p, t.UnixMilli())
+			if err == nil && off < 0 {
+				// No hay mensajes desde ese instante.
+				return client.GetOffset(topic, p, sarama.OffsetNewest)
+			}
+			return off, err
+		}, nil
+	}
+	porParticion := map[int32]int64{}
+	todas := int64(-1)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		todas = n
+	} else {
+		for _, par := range strings.Split(s, ",") {
+			ps, offs, ok := strings.Cut(par, "=")
+			p, err1 := strconv.ParseInt(strings.TrimSpace(ps), 10, 32)
+			o, err2 := strconv.ParseInt(strings.TrimSpace(offs), 10, 64)
+			if !ok || err1 != nil || err2 != nil || o < 0 {
+				return nil, fmt.Errorf("-from %q: se espera earliest, un offset, particion=offset,... o RFC3339", s)
+			}
+			porParticion[int32(p)] = o
+		}
+	}
+	return func(client sarama.Client, topic string, p int32) (int64, error) {
+		off, ok := porParticion[p]
+		if todas >= 0 {
+			off, ok = todas, true
+		}
+		oldest, err := client.GetOffset(topic, p, sarama.OffsetOldest)
+		if err != nil || !ok {
+			return oldest, err
+		}
+		newest, err := client.GetOffset(topic, p, sarama.OffsetNewest)
+		if err != nil {
+			return 0, err
+		}
+		if off < oldest || off > newest {
+			log.Printf("replay: offset %d fuera de [%d, %d] en la particion %d, se ajusta", off, oldest, newest, p)
+			off = max(oldest, min(off, newest))
+		}
+		return off, nil
+	}, nil
+}
+
+func comandoReplay(ctx context.Context, args []string, rdb redis.UniversalClient, claves Claves, loader *config.Loader) int {
+	cfg := loader.Get()
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	ns := fs.String("namespace", "", "namespace nuevo donde se reconstruyen los agregados (requerido)")
+	desde := fs.String("from", "earliest", "earliest, un offset, particion=offset,... o un instante RFC3339")
+	grupo := fs.String("group", "", "consumer group del replay (por defecto <kafka.group>-replay-<namespace>)")
+	resume := fs.Bool("resume", false, "continua un replay anterior desde los offsets confirmados del group")
+	swap := fs.Bool("swap", false, "al alcanzar el final reemplaza el namespace del consumer por el reconstruido")
+	force := fs.Bool("force", false, "con -swap, intercambia aunque el consumer en vivo siga activo")
+	progreso := fs.Duration("progress", 5*time.Second, "intervalo del reporte de progreso")
+	fs.Parse(args)
+
+	*ns = normalizarNamespace(*ns)
+	if *ns == "" {
+		fmt.Fprintln(os.Stderr, "replay: -namespace es requerido")
+		return 2
+	}
+	if err := validarNamespace(*ns); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *ns == claves.prefijo {
+		fmt.Fprintf(os.Stderr, "replay: %s es el namespace en vivo, use uno nuevo\n", *ns)
+		return 2
+	}
+	if *grupo == "" {
+		*grupo = cfg.Kafka.Group + "-replay-" + strings.ReplaceAll(strings.TrimSuffix(*ns, ":"), ":", "-")
+	}
+	inicioDe, err := parseDesde(*desde)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	destino := claves.EnNamespace(*ns)
+	if !*resume {
+		existentes, err := clavesConBases(ctx, rdb, destino, basesNamespace())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(existentes) > 0 {
+			fmt.Fprintf(os.Stderr, "replay: %s ya tiene %d claves; use -resume o borrelo con namespaces drop\n", *ns, len(existentes))
+			return 1
+		}
+	}
+
+	saramaCfg, err := configSarama(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error configurando Kafka: %v\n", err)
+		return 1
+	}
+	client, err := sarama.NewClient(cfg.Kafka.Brokers, saramaCfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error conectando a Kafka: %v\n", kafkaconf.Explicar(err))
+		return 1
+	}
+	// El admin cierra tambien el client.
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+		return 1
+	}
+	defer admin.Close()
+
+	topic := cfg.Kafka.Topic
+	particiones, err := client.Partitions(topic)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+		return 1
+	}
+	// La cuarentena vive en el namespace en vivo. Lo que se retenga despues de
+	// este punto no se excluye; el swap pide detener el consumer antes.
+	retenidas, err := retenidasPorRegistro(ctx, rdb, claves, cfg.Fraud)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	r := &replayer{
+		consumer: &Consumer{
+			rdb:      rdb,
+			lectura:  &Lectura{primario: rdb},
+			cfg:      loader,
+			claves:   destino,
+			registro: nuevoRegistro(ctx, rdb, cfg),
+		},
+		topic:     topic,
+		inicio:    map[int32]int64{},
+		retenidas: retenidas,
+		pos:       map[int32]*atomic.Int64{},
+	}
+	om, err := sarama.NewOffsetManagerFromClient(*grupo, client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+		return 1
+	}
+	for _, p := range particiones {
+		var off int64
+		if *resume {
+			pom, err := om.ManagePartition(topic, p)
+			if err == nil {
+				off, _ = pom.NextOffset()
+				pom.Close()
+			}
+			if err != nil || off < 0 {
+				off, err = client.GetOffset(topic, p, sarama.OffsetOldest)
+			}
+			if err != nil {
+				om.Close()
+				fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+				return 1
+			}
+		} else {
+			if off, err = inicioDe(client, topic, p); err != nil {
+				om.Close()
+				fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+				return 1
+			}
+			// Un group nuevo no tiene offsets y arrancaria en
+			// Consumer.Offsets.Initial; se confirma el inicio antes de unirse.
+			pom, err := om.ManagePartition(topic, p)
+			if err != nil {
+				om.Close()
+				fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+				return 1
+			}
+			fijarOffset(pom, off)
+		}
+		r.inicio[p] = off
+		r.pos[p] = &atomic.Int64{}
+		r.pos[p].Store(off)
+	}
+	om.Commit()
+	if err := om.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "replay: confirmando offsets de inicio de %s: %v\n", *grupo, kafkaconf.Explicar(err))
+		return 1
+	}
+
+	if err := rdb.SAdd(ctx, registroNamespaces, *ns).Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	group, err := sarama.NewConsumerGroupFromClient(*grupo, client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+		return 1
+	}
+	log.Printf("replay: %s -> %s con el group %s, inicio %v", topic, *ns, *grupo, r.inicio)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			if err := group.Consume(ctx, []string{topic}, r); err != nil {
+				log.Printf("replay: %v", err)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case err := <-group.Errors():
+				log.Printf("replay: %v", err)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	inicio := time.Now()
+	alcanzado := r.esperar(ctx, client, *progreso, sig)
+	cancel()
+	wg.Wait()
+	if err := group.Close(); err != nil {
+		log.Printf("replay: cerrando group: %v", err)
+	}
+	fmt.Printf("replay: %d mensajes en %s\n", r.procesados.Load(), time.Since(inicio).Round(time.Second))
+	if !alcanzado {
+		fmt.Printf("replay interrumpido; continue con: replay -namespace %s -group %s -resume\n", *ns, *grupo)
+		return 1
+	}
+	if !*swap {
+		fmt.Printf("replay completo en %s; para publicarlo: replay -namespace %s -group %s -resume -swap\n", *ns, *ns, *grupo)
+		return 0
+	}
+	return r.intercambiar(context.Background(), admin, client, rdb, claves, *ns, cfg.Kafka.Group, *force)
+}
+
+// esperar reporta progreso y ETA hasta que todas las particiones alcanzan su
+// high watermark actual. Devuelve false si se interrumpio.
+func (r *replayer) esperar(ctx context.Context, client sarama.Client, intervalo time.Duration, sig <-chan os.Signal) bool {
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	var tasa float64
+	anterior := r.procesados.Load()
+	for {
+		var total, pendiente int64
+		for p, pos := range r.pos {
+			hwm, err := client.GetOffset(r.topic, p, sarama.OffsetNewest)
+			if err != nil {
+				log.Printf("replay: high watermark de la particion %d: %v", p, err)
+				pendiente = -1
+				break
+			}
+			total += hwm - r.inicio[p]
+			pendiente += max(0, hwm-pos.Load())
+		}
+		if pendiente == 0 {
+			log.Printf("replay: %d/%d (100%%), alcanzado el final del topic", total, total)
+			return true
+		}
+		if pendiente > 0 {
+			hechos := total - pendiente
+			pct := 100.0
+			if total > 0 {
+				pct = 100 * float64(hechos) / float64(total)
+			}
+			eta := "?"
+			if tasa > 0 {
+				eta = (time.Duration(float64(pendiente)/tasa) * time.Second).Round(time.Second).String()
+			}
+			log.Printf("replay: %d/%d (%.1f%%) %.0f msg/s ETA %s", hechos, total, pct, tasa, eta)
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-sig:
+			return false
+		case <-ticker.C:
+		}
+		actual := r.procesados.Load()
+		muestra := float64(actual-anterior) / intervalo.Seconds()
+		anterior = actual
+		if tasa == 0 {
+			tasa = muestra
+		} else {
+			tasa = 0.7*tasa + 0.3*muestra
+		}
+	}
+}
+
+// fijarOffset deja pom en off. ResetOffset solo retrocede y MarkOffset solo
+// avanza, y un group sin offsets confirmados parte de -1; con los dos se
+// cubre cualquier caso.
+func fijarOffset(pom sarama.PartitionOffsetManager, off int64) {
+	pom.MarkOffset(off, "")
+	pom.ResetOffset(off, "")
+}
+
+// intercambiar publica el namespace reconstruido. Si el group en vivo no
+// tiene miembros tambien le confirma los offsets del replay, para que al
+// volver a levantar el consumer no cuente dos veces lo ya reconstruido.
+func (r *replayer) intercambiar(ctx context.Context, admin sarama.ClusterAdmin, client sarama.Client, rdb redis.UniversalClient, claves Claves, ns, grupoVivo string, force bool) int {
+	grupos, err := admin.DescribeConsumerGroups([]string{grupoVivo})
+	if err != nil || len(grupos) == 0 {
+		fmt.Fprintf(os.Stderr, "swap: no se pudo consultar el group %s: %v\n", grupoVivo, kafkaconf.Explicar(err))
+		return 1
+	}
+	vacio := grupos[0].State == "Empty" || grupos[0].State == "Dead"
+	if !vacio && !force {
+		fmt.Fprintf(os.Stderr, "swap: el group %s tiene miembros (%s). Detenga el consumer, p.ej. kubectl scale deploy/go-consumer --replicas=0, o use -force\n", grupoVivo, grupos[0].State)
+		return 1
+	}
+
+	n, err := intercambiarNamespace(ctx, rdb, claves, ns, claves.prefijo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("swap: %d claves de %s publicadas en %s\n", n, ns, nombreNamespace(claves.prefijo))
+
+	if !vacio {
+		fmt.Println("swap: el consumer seguia activo; los mensajes en vuelo durante el swap pueden quedar contados de mas o de menos")
+		return 0
+	}
+	om, err := sarama.NewOffsetManagerFromClient(grupoVivo, client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+		return 1
+	}
+	for p, pos := range r.pos {
+		pom, err := om.ManagePartition(r.topic, p)
+		if err != nil {
+			om.Close()
+			fmt.Fprintln(os.Stderr, kafkaconf.Explicar(err))
+			return 1
+		}
+		fijarOffset(pom, pos.Load())
+	}
+	om.Commit()
+	if err := om.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "swap: confirmando offsets de %s: %v\n", grupoVivo, err)
+		return 1
+	}
+	fmt.Printf("swap: offsets de %s movidos al final del replay; ya se puede levantar el consumer\n", grupoVivo)
+	return 0
+}
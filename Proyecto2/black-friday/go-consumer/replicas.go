@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go-common/valkeyconf"
+)
+
+type replica struct {
+	addr  string
+	rdb   *redis.Client
+	sana  atomic.Bool
+	lag   atomic.Int64
+	bytes atomic.Int64
+}
+
+type muestraOffset struct {
+	t      time.Time
+	offset int64
+}
+
+// Lectura enruta las lecturas a una replica sana y las escrituras quedan en el
+// primario. Una replica se considera sana si su enlace esta arriba y su
+// retraso estimado no supera valkey.max_replica_lag; si ninguna lo cumple se
+// lee del primario. Con max_replica_lag en 0 todas las lecturas van al
+// primario.
+type Lectura struct {
+	primario redis.UniversalClient
+	replicas []*replica
+	maxLag   atomic.Int64
+	rr       atomic.Uint64
+
+	mu        sync.Mutex
+	historial []muestraOffset
+}
+
+func NewLectura(primario redis.UniversalClient, opts valkeyconf.Options, addrs []string, maxLag time.Duration) (*Lectura, error) {
+	l := &Lectura{primario: primario}
+	l.maxLag.Store(int64(maxLag))
+	for _, addr := range addrs {
+		rdb, err := valkeyconf.NewNode(opts, addr)
+		if err != nil {
+			return nil, err
+		}
+		l.replicas = append(l.replicas, &replica{addr: addr, rdb: rdb})
+	}
+	return l, nil
+}
+
+func (l *Lectura) SetMaxLag(d time.Duration) { l.maxLag.Store(int64(d)) }
+
+// Cliente devuelve el cliente para una lectura que tolera datos atrasados.
+func (l *Lectura) Cliente() redis.Cmdable {
+	c, _ := l.ClienteConFuente()
+	return c
+}
+
+// ClienteConFuente devuelve ademas "primario" o "replica:<addr>".
+func (l *Lectura) ClienteConFuente() (redis.Cmdable, string) {
+	if l.maxLag.Load() > 0 && len(l.replicas) > 0 {
+		inicio := l.rr.Add(1)
+		for i := range l.replicas {
+			r := l.replicas[(int(inicio)+i)%len(l.replicas)]
+			if r.sana.Load() {
+				return r.rdb, "replica:" + r.addr
+			}
+		}
+	}
+	return l.primario, "primario"
+}
+
+// Monitorear mide el retraso de cada replica comparando su offset de
+// replicacion con el historial de offsets del primario.
+func (l *Lectura) Monitorear(ctx context.Context, intervalo time.Duration) {
+	if len(l.replicas) == 0 {
+		return
+	}
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for {
+		l.medir(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *Lectura) medir(ctx context.Context) {
+	info, err := l.primario.Info(ctx, "replication").Result()
+	if err != nil {
+		for _, r := range l.replicas {
+			r.sana.Store(false)
+		}
+		return
+	}
+	primOffset, _ := strconv.ParseInt(parseInfo(info)["master_repl_offset"], 10, 64)
+	ahora := time.Now()
+	maxLag := time.Duration(l.maxLag.Load())
+
+	l.mu.Lock()
+	l.historial = append(l.historial, muestraOffset{t: ahora, offset: primOffset})
+	// Se guarda historial suficiente para medir retrasos un poco mayores al
+	// umbral; mas alla de eso la replica ya esta fuera de tolerancia.
+	limite := ahora.Add(-4*maxLag - time.Minute)
+	for len(l.historial) > 1 && l.historial[0].t.Before(limite) {
+		l.historial = l.historial[1:]
+	}
+	historial := append([]muestraOffset(nil), l.historial...)
+	l.mu.Unlock()
+
+	for _, r := range l.replicas {
+		campos := map[string]string{}
+		if info, err := r.rdb.Info(ctx, "replication").Result(); err == nil {
+			campos = parseInfo(info)
+		}
+		offsetStr := campos["slave_repl_offset"]
+		if offsetStr == "" {
+			offsetStr = campos["replica_repl_offset"]
+		}
+		offset, errOff := strconv.ParseInt(offsetStr, 10, 64)
+		if campos["master_link_status"] != "up" || errOff != nil {
+			if r.sana.Swap(false) {
+				log.Printf("Replica %s fuera de servicio, lecturas al primario", r.addr)
+			}
+			continue
+		}
+		lag := estimarRetraso(historial, offset, ahora)
+		r.lag.Store(int64(lag))
+		r.bytes.Store(max(0, primOffset-offset))
+		sana := lag <= maxLag
+		if r.sana.Swap(sana) != sana {
+			if sana {
+				log.Printf("Replica %s sana (retraso %s)", r.addr, lag)
+			} else {
+				log.Printf("Replica %s con retraso %s > %s, lecturas al primario", r.addr, lag, maxLag)
+			}
+		}
+	}
+}
+
+// estimarRetraso devuelve cuanto tiempo lleva pendiente la escritura mas
+// antigua que la replica todavia no aplico.
+func estimarRetraso(historial []muestraOffset, offset int64, ahora time.Time) time.Duration {
+	for i, m := range historial {
+		if m.offset > offset {
+			if i > 0 {
+				return ahora.Sub(historial[i-1].t)
+			}
+			return ahora.Sub(m.t)
+		}
+	}
+	return 0
+}
+
+type EstadoReplica struct {
+	Addr       string  `json:"addr"`
+	Sana       bool    `json:"sana"`
+	RetrasoSeg float64 `json:"retraso_seg"`
+	Bytes      int64   `json:"retraso_bytes"`
+}
+
+func (l *Lectura) Estado() []EstadoReplica {
+	estado := make([]EstadoReplica, 0, len(l.replicas))
+	for _, r := range l.replicas {
+		estado = append(estado, EstadoReplica{
+			Addr:       r.addr,
+			Sana:       r.sana.Load(),
+			RetrasoSeg: time.Duration(r.lag.Load()).Seconds(),
+			Bytes:      r.bytes.Load(),
+		})
+	}
+	return estado
+}
+
+func parseInfo(info string) map[string]string {
+	campos := map[string]string{}
+	sc := bufio.NewScanner(strings.NewReader(info))
+	for sc.Scan() {
+		k, v, ok := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+		if ok {
+			campos[k] = v
+		}
+	}
+	return campos
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/redis/go-redis/v9"
+	"go-common/config"
+)
+
+// familias agrupa las claves que escribe el consumer. Cada familia recibe una
+// sola politica de retencion en retention.policies.
+var familias = map[string][]string{
+	"contador":             {"contador", "suma_cantidad", "suma_precio"},
+	"promedio":             {"promedio_productos", "promedio_precio_tag"},
+	"ranking_productos":    {"ranking_productos", "ranking_productos_cat"},
+	"precio_global":        {"precio_max_global", "precio_min_global"},
+	"total_ventas":         {"total_ventas"},
+	"producto_monitoreado": {"producto_monitoreado_nombre"},
+	"stream_precio":        {"stream_precio_producto_unico"},
+	"auditoria":            {"auditoria"},
+	"canary":               {"canary_visto", "canary_contador", "canary_resultados"},
+	"inventario":           {"sell_through"},
+	"campana":              {"campana_ventas", "campana_unidades", "campana_ingresos", "campana_anticipadas"},
+	"ajustes":              {"ajustes", "ajustes_rechazados"},
+	"venta_original":       {"venta_original"},
+	"alertas":              {"alertas"},
+	"reglas_alerta":        {"alertas_estado", "alertas_lider"},
+	"fraude":               {"fraude", "fraude_cuarentena", "fraude_decisiones", "fraude_registros"},
+	"fraude_contadores":    {"fraude_velocidad", "fraude_huella"},
+	"tendencia":            {"tendencia_productos", "tendencia_productos_cat", "tendencia_categorias", "tendencia_epoca"},
+}
+
+// politica es una entrada ya parseada de retention.policies:
+//   - ttl:<d>      cada clave expira <d> despues de que el janitor la ve sin TTL.
+//   - reset:<d>    las claves expiran en la siguiente frontera multiplo de <d>
+//     (24h = medianoche en retention.timezone), reiniciando el agregado.
+//   - archive:<d>  como ttl, pero antes de expirar el valor se copia al stream
+//     archivo:<familia> y la clave se borra.
+//   - none         no se toca.
+type politica struct {
+	modo string
+	dur  time.Duration
+}
+
+func parsePolitica(s string) (politica, error) {
+	modo, d, _ := strings.Cut(strings.TrimSpace(s), ":")
+	p := politica{modo: modo}
+	switch modo {
+	case "none", "":
+		p.modo = "none"
+		return p, nil
+	case "ttl", "reset", "archive":
+	default:
+		return p, fmt.Errorf("politica desconocida %q (ttl, reset, archive, none)", modo)
+	}
+	dur, err := time.ParseDuration(d)
+	if err != nil || dur <= 0 {
+		return p, fmt.Errorf("%s requiere una duracion positiva, p.ej. %s:24h", modo, modo)
+	}
+	p.dur = dur
+	return p, nil
+}
+
+type ajustesRetencion struct {
+	politicas map[string]politica
+	zona      *time.Location
+	dryRun    bool
+	maxLen    int64
+}
+
+func parseRetencion(cfg config.Retention) (ajustesRetencion, error) {
+	a := ajustesRetencion{politicas: map[string]politica{}, dryRun: cfg.DryRun, maxLen: cfg.ArchiveMaxLen}
+	zona, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return a, fmt.Errorf("retention.timezone: %w", err)
+	}
+	a.zona = zona
+	for fam, spec := range cfg.Policies {
+		if _, ok := familias[fam]; !ok {
+			return a, fmt.Errorf("retention.policies: familia desconocida %q", fam)
+		}
+		p, err := parsePolitica(spec)
+		if err != nil {
+			return a, fmt.Errorf("retention.policies.%s: %w", fam, err)
+		}
+		a.politicas[fam] = p
+	}
+	return a, nil
+}
+
+// Janitor aplica la politica de retencion. Es idempotente: varias replicas del
+// consumer pueden ejecutarlo a la vez sin duplicar expiraciones.
+type Janitor struct {
+	rdb       redis.UniversalClient
+	claves    Claves
+	intervalo time.Duration
+
+	mu      sync.Mutex
+	ajustes ajustesRetencion
+}
+
+func NewJanitor(rdb redis.UniversalClient, claves Claves, cfg config.Retention) (*Janitor, error) {
+	a, err := parseRetencion(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Janitor{rdb: rdb, claves: claves, intervalo: cfg.Interval, ajustes: a}, nil
+}
+
+// Actualizar aplica una configuracion recargada; si es invalida se mantiene
+// la anterior.
+func (j *Janitor) Actualizar(cfg config.Retention) error {
+	a, err := parseRetencion(cfg)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.ajustes = a
+	j.mu.Unlock()
+	return nil
+}
+
+func (j *Janitor) Correr(ctx context.Context) {
+	if j.intervalo <= 0 {
+		return
+	}
+	ticker := time.NewTicker(j.intervalo)
+	defer ticker.Stop()
+	for {
+		j.mu.Lock()
+		dryRun := j.ajustes.dryRun
+		j.mu.Unlock()
+		rep, err := j.Ejecutar(ctx, dryRun)
+		if err != nil {
+			log.Printf("Janitor: %v", err)
+		} else if n := rep.Acciones(); n > 0 {
+			log.Printf("Janitor: %d acciones (dry-run=%v)", n, dryRun)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type accionClave struct {
+	Clave     string `json:"clave"`
+	TTLActual string `json:"ttl_actual"`
+	Accion    string `json:"accion"`
+}
+
+type reporteFamilia struct {
+	Familia  string        `json:"familia"`
+	Politica string        `json:"politica"`
+	Claves   int           `json:"claves"`
+	Acciones []accionClave `json:"acciones"`
+}
+
+type Reporte struct {
+	DryRun   bool             `json:"dry_run"`
+	Generado time.Time        `json:"generado"`
+	Familias []reporteFamilia `json:"familias"`
+}
+
+func (r Reporte) Acciones() int {
+	n := 0
+	for _, f := range r.Familias {
+		n += len(f.Acciones)
+	}
+	return n
+}
+
+// Ejecutar recorre las familias con politica y fija o adelanta la expiracion
+// de cada clave. Con dryRun solo arma el reporte.
+func (j *Janitor) Ejecutar(ctx context.Context, dryRun bool) (Reporte, error) {
+	j.mu.Lock()
+	a := j.ajustes
+	j.mu.Unlock()
+
+	rep := Reporte{DryRun: dryRun, Generado: time.Now()}
+	nombres := make([]string, 0, len(a.politicas))
+	for fam := range a.politicas {
+		nombres = append(nombres, fam)
+	}
+	sort.Strings(nombres)
+
+	for _, fam := range nombres {
+		p := a.politicas[fam]
+		rf := reporteFamilia{Familia: fam, Politica: p.modo}
+		if p.dur > 0 {
+			rf.Politica += ":" + p.dur.String()
+		}
+		claves, err := j.clavesFamilia(ctx, fam)
+		if err != nil {
+			return rep, fmt.Errorf("%s: %w", fam, err)
+		}
+		rf.Claves = len(claves)
+		if p.modo != "none" {
+			for _, clave := range claves {
+				acc, err := j.aplicar(ctx, fam, clave, p, a, dryRun)
+				if err != nil {
+					return rep, fmt.Errorf("%s: %w", clave, err)
+				}
+				if acc.Accion != "" {
+					rf.Acciones = append(rf.Acciones, acc)
+				}
+			}
+		}
+		rep.Familias = append(rep.Familias, rf)
+	}
+	return rep, nil
+}
+
+func (j *Janitor) aplicar(ctx context.Context, fam, clave string, p politica, a ajustesRetencion, dryRun bool) (accionClave, error) {
+	ttl, err := j.rdb.PTTL(ctx, clave).Result()
+	if err != nil {
+		return accionClave{}, err
+	}
+	acc := accionClave{Clave: clave, TTLActual: "sin ttl"}
+	if ttl == -2*time.Nanosecond {
+		// La clave expiro entre el SCAN y el PTTL.
+		return accionClave{}, nil
+	}
+	sinTTL := ttl < 0
+	if !sinTTL {
+		acc.TTLActual = ttl.Round(time.Second).String()
+	}
+
+	switch p.modo {
+	case "ttl":
+		if !sinTTL && ttl <= p.dur {
+			return accionClave{}, nil
+		}
+		acc.Accion = "expira en " + p.dur.String()
+		if !dryRun {
+			err = j.rdb.PExpire(ctx, clave, p.dur).Err()
+		}
+	case "reset":
+		frontera := siguienteFrontera(time.Now(), p.dur, a.zona)
+		if !sinTTL && time.Until(frontera) >= ttl-time.Second {
+			return accionClave{}, nil
+		}
+		acc.Accion = "expira en la frontera " + frontera.Format(time.RFC3339)
+		if !dryRun {
+			err = j.rdb.PExpireAt(ctx, clave, frontera).Err()
+		}
+	case "archive":
+		switch {
+		case sinTTL || ttl > p.dur:
+			acc.Accion = "expira en " + p.dur.String()
+			if !dryRun {
+				err = j.rdb.PExpire(ctx, clave, p.dur).Err()
+			}
+		case ttl <= j.margenArchivo():
+			// Se archiva antes de que Valkey la borre.
+			acc.Accion = "archiva en " + j.claves.global("archivo:"+fam) + " y borra"
+			if !dryRun {
+				err = j.archivar(ctx, fam, clave, a.maxLen)
+			}
+		default:
+			return accionClave{}, nil
+		}
+	}
+	return acc, err
+}
+
+// margenArchivo es cuanto antes de expirar se archiva una clave: dos
+// intervalos cubren una corrida atrasada. El comando janitor sin intervalo
+// usa un minuto.
+func (j *Janitor) margenArchivo() time.Duration {
+	if j.intervalo <= 0 {
+		return time.Minute
+	}
+	return 2 * j.intervalo
+}
+
+// siguienteFrontera devuelve el proximo instante multiplo de cada, contado
+// desde la medianoche local para que reset:24h coincida con el cambio de dia.
+func siguienteFrontera(ahora time.Time, cada time.Duration, zona *time.Location) time.Time {
+	local := ahora.In(zona)
+	dia := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, zona)
+	if cada >= 24*time.Hour {
+		dias := int(cada / (24 * time.Hour))
+		desde := dia.AddDate(0, 0, -(dia.YearDay()-1)%dias)
+		for !desde.After(local) {
+			desde = desde.AddDate(0, 0, dias)
+		}
+		return desde
+	}
+	desde := dia
+	for !desde.After(local) {
+		desde = desde.Add(cada)
+	}
+	return desde
+}
+
+func (j *Janitor) archivar(ctx context.Context, fam, clave string, maxLen int64) error {
+	tipo, err := j.rdb.Type(ctx, clave).Result()
+	if err != nil {
+		return err
+	}
+	var valor any
+	switch tipo {
+	case "none":
+		return nil
+	case "string":
+		valor, err = j.rdb.Get(ctx, clave).Result()
+	case "zset":
+		valor, err = j.rdb.ZRangeWithScores(ctx, clave, 0, -1).Result()
+	case "stream":
+		valor, err = j.rdb.XRange(ctx, clave, "-", "+").Result()
+	case "hash":
+		valor, err = j.rdb.HGetAll(ctx, clave).Result()
+	default:
+		return fmt.Errorf("tipo %s no se puede archivar", tipo)
+	}
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(valor)
+	if err != nil {
+		return err
+	}
+	if err := j.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: j.claves.global("archivo:" + fam),
+		MaxLen: maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"clave":     clave,
+			"tipo":      tipo,
+			"valor":     string(b),
+			"archivado": time.Now().UTC().Format(time.RFC3339),
+		},
+	}).Err(); err != nil {
+		return err
+	}
+	return j.rdb.Del(ctx, clave).Err()
+}
+
+func (j *Janitor) clavesFamilia(ctx context.Context, fam string) ([]string, error) {
+	return clavesConBases(ctx, j.rdb, j.claves, familias[fam])
+}
+
+// clavesConBases lista, sin repetir y ordenadas, las claves de esas bases en
+// el namespace de k.
+func clavesConBases(ctx context.Context, rdb redis.UniversalClient, k Claves, bases []string) ([]string, error) {
+	vistas := map[string]bool{}
+	for _, base := range bases {
+		for _, patron := range k.patrones(base) {
+			err := escanear(ctx, rdb, patron, func(clave string) {
+				vistas[clave] = true
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	claves := make([]string, 0, len(vistas))
+	for c := range vistas {
+		claves = append(claves, c)
+	}
+	sort.Strings(claves)
+	return claves, nil
+}
+
+// escanear recorre las claves que cumplen patron con SCAN; en cluster lo hace
+// en cada master.
+func escanear(ctx context.Context, rdb redis.UniversalClient, patron string, fn func(string)) error {
+	var mu sync.Mutex
+	scan := func(ctx context.Context, c redis.Cmdable) error {
+		iter := c.Scan(ctx, 0, patron, 500).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			fn(iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+	if cc, ok := rdb.(*redis.ClusterClient); ok {
+		return cc.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return scan(ctx, c)
+		})
+	}
+	return scan(ctx, rdb)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go-common/config"
+)
+
+// nuevoValkey levanta un Valkey en memoria para probar los scripts y el
+// janitor sin un servidor real.
+func nuevoValkey(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return m, rdb
+}
+
+func TestParsePolitica(t *testing.T) {
+	casos := []struct {
+		entrada string
+		modo    string
+		dur     time.Duration
+		error   bool
+	}{
+		{"", "none", 0, false},
+		{"none", "none", 0, false},
+		{"ttl:168h", "ttl", 168 * time.Hour, false},
+		{" reset:24h ", "reset", 24 * time.Hour, false},
+		{"archive:30m", "archive", 30 * time.Minute, false},
+		{"ttl", "", 0, true},
+		{"ttl:0s", "", 0, true},
+		{"reset:-1h", "", 0, true},
+		{"borrar:1h", "", 0, true},
+	}
+	for _, c := range casos {
+		p, err := parsePolitica(c.entrada)
+		if c.error {
+			if err == nil {
+				t.Errorf("parsePolitica(%q) deberia fallar, dio %+v", c.entrada, p)
+			}
+			continue
+		}
+		if err != nil || p.modo != c.modo || p.dur != c.dur {
+			t.Errorf("parsePolitica(%q) = %+v, %v", c.entrada, p, err)
+		}
+	}
+}
+
+func TestParseRetencion(t *testing.T) {
+	casos := []struct {
+		nombre string
+		cfg    config.Retention
+		error  string
+	}{
+		{"valida", config.Retention{Timezone: "America/Guatemala", Policies: map[string]string{"contador": "reset:24h"}}, ""},
+		{"familia desconocida", config.Retention{Timezone: "UTC", Policies: map[string]string{"otra": "ttl:1h"}}, "familia desconocida"},
+		{"politica invalida", config.Retention{Timezone: "UTC", Policies: map[string]string{"contador": "ttl:x"}}, "retention.policies.contador"},
+		{"zona invalida", config.Retention{Timezone: "Marte/Olympus"}, "retention.timezone"},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			_, err := parseRetencion(c.cfg)
+			if c.error == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.error) {
+				t.Fatalf("se esperaba un error con %q, se obtuvo %v", c.error, err)
+			}
+		})
+	}
+}
+
+func TestSiguienteFrontera(t *testing.T) {
+	gt, err := time.LoadLocation("America/Guatemala")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ahora := time.Date(2026, 10, 19, 15, 30, 0, 0, gt)
+	casos := []struct {
+		cada time.Duration
+		zona *time.Location
+		fin  time.Time
+	}{
+		{24 * time.Hour, gt, time.Date(2026, 10, 20, 0, 0, 0, 0, gt)},
+		{24 * time.Hour, time.UTC, time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+		{time.Hour, gt, time.Date(2026, 10, 19, 16, 0, 0, 0, gt)},
+		{15 * time.Minute, gt, time.Date(2026, 10, 19, 15, 45, 0, 0, gt)},
+		// Cada dos dias contando desde el 1 de enero: el 20 de octubre esta
+		// 292 dias despues, asi que es frontera.
+		{48 * time.Hour, gt, time.Date(2026, 10, 20, 0, 0, 0, 0, gt)},
+		{72 * time.Hour, gt, time.Date(2026, 10, 22, 0, 0, 0, 0, gt)},
+	}
+	for _, c := range casos {
+		if got := siguienteFrontera(ahora, c.cada, c.zona); !got.Equal(c.fin) {
+			t.Errorf("siguienteFrontera(%s, %s) = %s, se esperaba %s", c.cada, c.zona, got, c.fin)
+		}
+	}
+	// Justo en la frontera, la siguiente es la proxima.
+	if got := siguienteFrontera(time.Date(2026, 10, 20, 0, 0, 0, 0, gt), 24*time.Hour, gt); !got.Equal(time.Date(2026, 10, 21, 0, 0, 0, 0, gt)) {
+		t.Errorf("en la frontera: %s", got)
+	}
+}
+
+func TestJanitorEjecutar(t *testing.T) {
+	m, rdb := nuevoValkey(t)
+	ctx := context.Background()
+	k := Claves{}
+	m.Set(k.categoria("contador", "Electronica"), "3")
+	m.Set(k.global("total_ventas"), "3")
+	m.ZAdd(k.global("ranking_productos"), 2, "P-1")
+	m.XAdd(k.global("stream_precio_producto_unico"), "*", []string{"precio", "10"})
+
+	j, err := NewJanitor(rdb, k, config.Retention{
+		Interval:      time.Minute,
+		Timezone:      "UTC",
+		ArchiveMaxLen: 100,
+		Policies: map[string]string{
+			"contador":          "reset:24h",
+			"total_ventas":      "ttl:1h",
+			"stream_precio":     "archive:1h",
+			"ranking_productos": "none",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := j.Ejecutar(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Acciones() != 3 {
+		t.Errorf("dry-run con %d acciones, se esperaban 3: %+v", rep.Acciones(), rep)
+	}
+	for _, clave := range m.Keys() {
+		if ttl := m.TTL(clave); ttl != 0 {
+			t.Errorf("dry-run no deberia tocar %s (ttl %s)", clave, ttl)
+		}
+	}
+
+	if _, err := j.Ejecutar(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := m.TTL(k.global("total_ventas")); ttl != time.Hour {
+		t.Errorf("ttl:1h dejo total_ventas con %s", ttl)
+	}
+	if ttl := m.TTL(k.categoria("contador", "Electronica")); ttl <= 0 || ttl > 24*time.Hour {
+		t.Errorf("reset:24h dejo contador con %s", ttl)
+	}
+	if ttl := m.TTL(k.global("ranking_productos")); ttl != 0 {
+		t.Errorf("none no deberia tocar ranking_productos (ttl %s)", ttl)
+	}
+
+	// Una segunda corrida no repite acciones sobre claves que ya expiran a
+	// tiempo.
+	rep, err = j.Ejecutar(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Acciones() != 0 {
+		t.Errorf("segunda corrida con %d acciones: %+v", rep.Acciones(), rep)
+	}
+
+	// Cerca de expirar, archive copia el valor al stream y borra la clave.
+	m.SetTTL(k.global("stream_precio_producto_unico"), 30*time.Second)
+	if _, err := j.Ejecutar(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if m.Exists(k.global("stream_precio_producto_unico")) {
+		t.Error("la clave archivada deberia borrarse")
+	}
+	archivo, err := m.Stream(k.global("archivo:stream_precio"))
+	if err != nil || len(archivo) != 1 {
+		t.Fatalf("archivo: %v (%v)", archivo, err)
+	}
+	campos := map[string]string{}
+	for i := 0; i+1 < len(archivo[0].Values); i += 2 {
+		campos[archivo[0].Values[i]] = archivo[0].Values[i+1]
+	}
+	if campos["clave"] != k.global("stream_precio_producto_unico") || campos["tipo"] != "stream" || !strings.Contains(campos["valor"], `"precio":"10"`) {
+		t.Errorf("entrada archivada %v", campos)
+	}
+}
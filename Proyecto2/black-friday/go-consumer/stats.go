@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// servirStats expone lecturas de los agregados y los histogramas de latencia.
+// Las lecturas de Valkey pasan por Lectura, asi que pueden resolverse en una
+// replica; el header X-Valkey-Source indica de donde salio cada respuesta.
+func (consumer *Consumer) servirStats(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", consumer.handleStats)
+	mux.HandleFunc("GET /ranking", consumer.handleRanking)
+	mux.HandleFunc("GET /tendencias", consumer.handleTendencias)
+	mux.HandleFunc("GET /categorias", consumer.handleCategorias)
+	mux.HandleFunc("GET /sell-through", consumer.handleSellThrough)
+	mux.HandleFunc("GET /campanas", consumer.handleCampanas)
+	mux.HandleFunc("GET /reembolsos", consumer.handleReembolsos)
+	mux.HandleFunc("GET /reglas", consumer.handleReglas)
+	mux.HandleFunc("GET /fraude", consumer.handleFraude)
+	mux.HandleFunc("GET /replicas", func(w http.ResponseWriter, r *http.Request) {
+		responderJSON(w, http.StatusOK, consumer.lectura.Estado())
+	})
+	mux.HandleFunc("GET /latencias", func(w http.ResponseWriter, r *http.Request) {
+		responderJSON(w, http.StatusOK, consumer.latencias.Reporte())
+	})
+	mux.HandleFunc("POST /latencias/reset", func(w http.ResponseWriter, r *http.Request) {
+		consumer.latencias.Reiniciar()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	log.Printf("Stats API en %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("Error en stats API: %v", err)
+	}
+}
+
+func responderJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// clavesConsulta permite leer otro namespace con ?namespace=; sin el
+// parametro se usa el del consumer.
+func (consumer *Consumer) clavesConsulta(w http.ResponseWriter, r *http.Request) (Claves, bool) {
+	ns, ok := r.URL.Query()["namespace"]
+	if !ok {
+		return consumer.claves, true
+	}
+	if err := validarNamespace(ns[0]); err != nil {
+		responderJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return Claves{}, false
+	}
+	return consumer.claves.EnNamespace(ns[0]), true
+}
+
+func (consumer *Consumer) nombresCategorias() []string {
+	var nombres []string
+	for _, c := range consumer.registro.Lista() {
+		nombres = append(nombres, c.Slug)
+	}
+	sort.Strings(nombres)
+	return append(nombres, categoriaOtros)
+}
+
+type statsCategoria struct {
+	Contador          int64   `json:"contador"`
+	PromedioProductos float64 `json:"promedio_productos"`
+	PromedioPrecio    float64 `json:"promedio_precio"`
+}
+
+func (consumer *Consumer) handleStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	k, ok := consumer.clavesConsulta(w, r)
+	if !ok {
+		return
+	}
+	rdb, fuente := consumer.lectura.ClienteConFuente()
+
+	pipe := rdb.Pipeline()
+	total := pipe.Get(ctx, k.global("total_ventas"))
+	pmax := pipe.Get(ctx, k.global("precio_max_global"))
+	pmin := pipe.Get(ctx, k.global("precio_min_global"))
+	nombres := consumer.nombresCategorias()
+	porCat := make([][3]*redis.StringCmd, len(nombres))
+	for i, n := range nombres {
+		porCat[i] = [3]*redis.StringCmd{
+			pipe.Get(ctx, k.categoria("contador", n)),
+			pipe.Get(ctx, k.categoria("promedio_productos", n)),
+			pipe.Get(ctx, k.categoria("promedio_precio_tag", n)),
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		responderJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+
+	cats := map[string]statsCategoria{}
+	for i, n := range nombres {
+		c, _ := porCat[i][0].Int64()
+		if c == 0 {
+			continue
+		}
+		pp, _ := porCat[i][1].Float64()
+		pr, _ := porCat[i][2].Float64()
+		cats[n] = statsCategoria{Contador: c, PromedioProductos: pp, PromedioPrecio: pr}
+	}
+	t, _ := total.Int64()
+	max, _ := pmax.Float64()
+	min, _ := pmin.Float64()
+	w.Header().Set("X-Valkey-Source", fuente)
+	responderJSON(w, http.StatusOK, map[string]any{
+		"total_ventas":      t,
+		"precio_max_global": max,
+		"precio_min_global": min,
+		"categorias":        cats,
+	})
+}
+
+type entradaRanking struct {
+	ProductoID string  `json:"producto_id"`
+	Unidades   float64 `json:"unidades"`
+}
+
+func (consumer *Consumer) handleRanking(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 {
+		n = 10
+	}
+	k, ok := consumer.clavesConsulta(w, r)
+	if !ok {
+		return
+	}
+	key := k.global("ranking_productos")
+	if cat := r.URL.Query().Get("categoria"); cat != "" {
+		key = k.categoria("ranking_productos_cat", cat)
+	}
+	rdb, fuente := consumer.lectura.ClienteConFuente()
+	zs, err := rdb.ZRevRangeWithScores(r.Context(), key, 0, int64(n-1)).Result()
+	if err != nil {
+		responderJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	ranking := make([]entradaRanking, 0, len(zs))
+	for _, z := range zs {
+		ranking = append(ranking, entradaRanking{ProductoID: z.Member.(string), Unidades: z.Score})
+	}
+	w.Header().Set("X-Valkey-Source", fuente)
+	responderJSON(w, http.StatusOK, ranking)
+}
+
+// handleTendencias es el top de productos por puntaje de tendencia: global,
+// de una categoria con ?categoria= o, con ?por=categorias, de categorias.
+func (consumer *Consumer) handleTendencias(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 {
+		n = 10
+	}
+	k, ok := consumer.clavesConsulta(w, r)
+	if !ok {
+		return
+	}
+	zset, epoca := k.global("tendencia_productos"), k.global("tendencia_epoca")
+	if cat := r.URL.Query().Get("categoria"); cat != "" {
+		zset, epoca = k.categoria("tendencia_productos_cat", cat), k.categoria("tendencia_epoca", cat)
+	} else if r.URL.Query().Get("por") == "categorias" {
+		zset = k.global("tendencia_categorias")
+	}
+	rdb, fuente := consumer.lectura.ClienteConFuente()
+	lista, err := leerTendencia(r.Context(), rdb, zset, epoca, n, time.Now())
+	if err != nil {
+		responderJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("X-Valkey-Source", fuente)
+	responderJSON(w, http.StatusOK, lista)
+}
+
+type entradaSellThrough struct {
+	ProductoID string  `json:"producto_id"`
+	Porcentaje float64 `json:"porcentaje"`
+}
+
+// handleSellThrough lista los productos con mayor porcentaje vendido de su
+// stock total.
+func (consumer *Consumer) handleSellThrough(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 {
+		n = 10
+	}
+	k, ok := consumer.clavesConsulta(w, r)
+	if !ok {
+		return
+	}
+	rdb, fuente := consumer.lectura.ClienteConFuente()
+	zs, err := rdb.ZRevRangeWithScores(r.Context(), k.global("sell_through"), 0, int64(n-1)).Result()
+	if err != nil {
+		responderJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	lista := make([]entradaSellThrough, 0, len(zs))
+	for _, z := range zs {
+		lista = append(lista, entradaSellThrough{ProductoID: z.Member.(string), Porcentaje: z.Score})
+	}
+	w.Header().Set("X-Valkey-Source", fuente)
+	responderJSON(w, http.StatusOK, lista)
+}
+
+type statsCampana struct {
+	Nombre      string  `json:"nombre,omitempty"`
+	Ventas      int64   `json:"ventas"`
+	Unidades    int64   `json:"unidades"`
+	Ingresos    float64 `json:"ingresos"`
+	Anticipadas int64   `json:"anticipadas"`
+}
+
+// handleCampanas devuelve ventas, unidades e ingresos por campana. El nombre
+// sale de la configuracion vigente, asi que falta en campanas ya retiradas.
+func (consumer *Consumer) handleCampanas(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	k, ok := consumer.clavesConsulta(w, r)
+	if !ok {
+		return
+	}
+	rdb, fuente := consumer.lectura.ClienteConFuente()
+	pipe := rdb.Pipeline()
+	ventas := pipe.HGetAll(ctx, k.global("campana_ventas"))
+	unidades := pipe.HGetAll(ctx, k.global("campana_unidades"))
+	ingresos := pipe.HGetAll(ctx, k.global("campana_ingresos"))
+	anticipadas := pipe.HGetAll(ctx, k.global("campana_anticipadas"))
+	if _, err := pipe.Exec(ctx); err != nil {
+		responderJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+
+	nombres := map[string]string{}
+	for _, cp := range consumer.cfg.Get().Campaigns {
+		nombres[cp.ID] = cp.Name
+	}
+	campanas := map[string]statsCampana{}
+	for id, v := range ventas.Val() {
+		s := statsCampana{Nombre: nombres[id]}
+		s.Ventas, _ = strconv.ParseInt(v, 10, 64)
+		s.Unidades, _ = strconv.ParseInt(unidades.Val()[id], 10, 64)
+		s.Ingresos, _ = strconv.ParseFloat(ingresos.Val()[id], 64)
+		s.Anticipadas, _ = strconv.ParseInt(anticipadas.Val()[id], 10, 64)
+		campanas[id] = s
+	}
+	w.Header().Set("X-Valkey-Source", fuente)
+	responderJSON(w, http.StatusOK, campanas)
+}
+
+// handleReembolsos devuelve los reembolsos y cancelaciones por categoria con
+// sus tasas sobre las unidades vendidas, y los ajustes que no se aplicaron.
+func (consumer *Consumer) handleReembolsos(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	k, ok := consumer.clavesConsulta(w, r)
+	if !ok {
+		return
+	}
+	rdb, fuente := consumer.lectura.ClienteConFuente()
+	pipe := rdb.Pipeline()
+	nombres := consumer.nombresCategorias()
+	ajustes := make([]*redis.MapStringStringCmd, len(nombres))
+	netas := make([]*redis.StringCmd, len(nombres))
+	for i, n := range nombres {
+		ajustes[i] = pipe.HGetAll(ctx, k.categoria("ajustes", n))
+		netas[i] = pipe.Get(ctx, k.categoria("suma_cantidad", n))
+	}
+	rechazados := pipe.HGetAll(ctx, k.global("ajustes_rechazados"))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		responderJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+
+	cats := map[string]tasasAjustes{}
+	for i, n := range nombres {
+		cant, _ := netas[i].Int64()
+		if cant == 0 && len(ajustes[i].Val()) == 0 {
+			continue
+		}
+		cats[n] = nuevasTasas(ajustes[i].Val(), cant)
+	}
+	rech := map[string]int64{}
+	for motivo, v := range rechazados.Val() {
+		rech[motivo], _ = strconv.ParseInt(v, 10, 64)
+	}
+	w.Header().Set("X-Valkey-Source", fuente)
+	responderJSON(w, http.StatusOK, map[string]any{
+		"categorias": cats,
+		"rechazados": rech,
+	})
+}
+
+type estadoReglaAPI struct {
+	Nombre     string     `json:"nombre"`
+	Metrica    string     `json:"metrica"`
+	Categoria  string     `json:"categoria,omitempty"`
+	Comparador string     `json:"comparador"`
+	Umbral     float64    `json:"umbral"`
+	Estado     string     `json:"estado"`
+	Valor      *float64   `json:"valor,omitempty"`
+	Desde      *time.Time `json:"desde,omitempty"`
+	Notificada *time.Time `json:"notificada,omitempty"`
+}
+
+// handleReglas devuelve las reglas de alerting.rules con el estado y el
+// ultimo valor que dejo la replica que las evalua.
+func (consumer *Consumer) handleReglas(w http.ResponseWriter, r *http.Request) {
+	k, ok := consumer.clavesConsulta(w, r)
+	if !ok {
+		return
+	}
+	rdb, fuente := consumer.lectura.ClienteConFuente()
+	crudo, err := rdb.HGetAll(r.Context(), k.global("alertas_estado")).Result()
+	if err != nil {
+		responderJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	milis := func(campo string) *time.Time {
+		ms, err := strconv.ParseInt(crudo[campo], 10, 64)
+		if err != nil || ms == 0 {
+			return nil
+		}
+		t := time.UnixMilli(ms)
+		return &t
+	}
+	reglas := []estadoReglaAPI{}
+	for _, regla := range consumer.cfg.Get().Alerting.Rules {
+		e := estadoReglaAPI{
+			Nombre:     regla.Name,
+			Metrica:    regla.Metric,
+			Categoria:  regla.Category,
+			Comparador: regla.Comparator,
+			Umbral:     regla.Threshold,
+			Estado:     crudo[regla.Name+":estado"],
+			Desde:      milis(regla.Name + ":desde"),
+			Notificada: milis(regla.Name + ":notificada"),
+		}
+		if e.Estado == "" {
+			e.Estado = reglaResuelta
+		}
+		if v, err := strconv.ParseFloat(crudo[regla.Name+":valor"], 64); err == nil {
+			e.Valor = &v
+		}
+		reglas = append(reglas, e)
+	}
+	w.Header().Set("X-Valkey-Source", fuente)
+	responderJSON(w, http.StatusOK, reglas)
+}
+
+// handleFraude devuelve los contadores del puntaje de fraude y una pagina de
+// hasta ?n= ventas en cuarentena (100 por defecto); ?cursor= pide la
+// siguiente, 0 en la respuesta indica que no hay mas. ?decisiones=N agrega
+// las N decisiones mas recientes.
+func (consumer *Consumer) handleFraude(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	n, err := strconv.ParseInt(q.Get("n"), 10, 64)
+	if err != nil || n <= 0 {
+		n = 100
+	}
+	cursor, _ := strconv.ParseUint(q.Get("cursor"), 10, 64)
+	nDecisiones, _ := strconv.ParseInt(q.Get("decisiones"), 10, 64)
+	k, ok := consumer.clavesConsulta(w, r)
+	if !ok {
+		return
+	}
+	rdb, fuente := consumer.lectura.ClienteConFuente()
+	contadores, err := rdb.HGetAll(ctx, k.global("fraude")).Result()
+	var lista []Retenida
+	var siguiente uint64
+	if err == nil {
+		lista, siguiente, err = leerCuarentena(ctx, rdb, k, cursor, n)
+	}
+	decisiones := []Decision{}
+	if err == nil && nDecisiones > 0 {
+		decisiones, err = leerDecisiones(ctx, rdb, k, nDecisiones)
+	}
+	if err != nil {
+		responderJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	retenidas := []Retenida{}
+	for _, ret := range lista {
+		ret.Clave, ret.Valor, ret.Headers = nil, nil, nil
+		retenidas = append(retenidas, ret)
+	}
+	resumen := map[string]int64{}
+	for _, c := range []string{"evaluadas", "retenidas", "liberadas", "rechazadas"} {
+		resumen[c], _ = strconv.ParseInt(contadores[c], 10, 64)
+	}
+	w.Header().Set("X-Valkey-Source", fuente)
+	respuesta := map[string]any{
+		"contadores": resumen,
+		"retenidas":  retenidas,
+		"cursor":     siguiente,
+	}
+	if nDecisiones > 0 {
+		respuesta["decisiones"] = decisiones
+	}
+	responderJSON(w, http.StatusOK, respuesta)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// El puntaje de tendencia es la suma de las unidades vendidas, cada una con
+// peso 2^(-edad/vida media). Para no reescribir todo el sorted set en cada
+// venta se guarda escalado a una epoca: una venta en t suma
+// unidades*2^((t-t0)/vida) y el puntaje actual es el guardado por
+// 2^(-(ahora-t0)/vida). El orden no cambia con el tiempo, asi que ZREVRANGE
+// sigue dando el top. La epoca (t0 y la vida con la que se escalo) vive en un
+// hash en el mismo slot que los sorted sets que escala.
+
+// rebaseEpoca es cuantas vidas medias se deja avanzar la epoca antes de
+// reescalar; 2^50 todavia deja margen de sobra en un double.
+const rebaseEpoca = 50
+
+// tendenciaScript suma ARGV[1] unidades al miembro ARGV[i+3] de cada sorted
+// set KEYS[i] (i >= 2), escaladas a la epoca de KEYS[1]. ARGV[2] es ahora y
+// ARGV[3] la vida media, en segundos. Si la epoca quedo mas de ARGV[4] vidas
+// medias atras o cambio la vida media, primero pasa los puntajes a su valor
+// actual y mueve la epoca a ahora; los que quedaron en casi nada se borran.
+// Una venta mas de ARGV[4] vidas medias anterior a la epoca ya no pesa y se
+// ignora.
+var tendenciaScript = redis.NewScript(`
+local ahora, vida = tonumber(ARGV[2]), tonumber(ARGV[3])
+local t0 = tonumber(redis.call('HGET', KEYS[1], 't0'))
+local vieja = tonumber(redis.call('HGET', KEYS[1], 'vida'))
+if not t0 or not vieja then
+  t0, vieja = ahora, vida
+  redis.call('HSET', KEYS[1], 't0', ARGV[2], 'vida', ARGV[3])
+end
+local e = (ahora - t0) / vieja
+local limite = tonumber(ARGV[4])
+if e < -limite then
+  return 0
+end
+if vieja ~= vida or e > limite then
+  local f = 2 ^ (-e)
+  for i = 2, #KEYS do
+    local items = redis.call('ZRANGE', KEYS[i], 0, -1, 'WITHSCORES')
+    for j = 1, #items, 2 do
+      local s = tonumber(items[j + 1]) * f
+      if s < 0.001 then
+        redis.call('ZREM', KEYS[i], items[j])
+      else
+        redis.call('ZADD', KEYS[i], s, items[j])
+      end
+    end
+  end
+  e = 0
+  redis.call('HSET', KEYS[1], 't0', ARGV[2], 'vida', ARGV[3])
+end
+local peso = tonumber(ARGV[1]) * 2 ^ e
+for i = 2, #KEYS do
+  redis.call('ZINCRBY', KEYS[i], peso, ARGV[i + 3])
+end
+return 1
+`)
+
+// actualizarTendencias suma la venta a las tendencias de productos (global y
+// de su categoria) y de categorias. ahora es el timestamp del registro en
+// Kafka, asi un replay no hace pasar ventas viejas por recientes; no se usa
+// el event_time porque lo elige el cliente. Los reembolsos no se descuentan:
+// la tendencia mide demanda.
+func (k Claves) actualizarTendencias(ctx context.Context, rdb redis.UniversalClient, cat string, venta Venta, ahora time.Time, vida time.Duration) error {
+	t := strconv.FormatFloat(float64(ahora.UnixMilli())/1000, 'f', 3, 64)
+	v := strconv.FormatFloat(vida.Seconds(), 'f', -1, 64)
+	err := tendenciaScript.Run(ctx, rdb, []string{
+		k.categoria("tendencia_epoca", cat),
+		k.categoria("tendencia_productos_cat", cat),
+	}, venta.CantidadVendida, t, v, rebaseEpoca, venta.ProductoID).Err()
+	if err != nil {
+		return err
+	}
+	return tendenciaScript.Run(ctx, rdb, []string{
+		k.global("tendencia_epoca"),
+		k.global("tendencia_productos"),
+		k.global("tendencia_categorias"),
+	}, venta.CantidadVendida, t, v, rebaseEpoca, venta.ProductoID, cat).Err()
+}
+
+// entradaTendencia es un puesto del top. Velocidad es el ritmo de ventas que
+// corresponde al puntaje, en unidades por minuto: con ventas a ritmo
+// constante el puntaje tiende a ritmo*vida/ln 2.
+type entradaTendencia struct {
+	ID        string  `json:"id"`
+	Puntaje   float64 `json:"puntaje"`
+	Velocidad float64 `json:"velocidad"`
+}
+
+// leerTendencia devuelve los n primeros de un sorted set de tendencia con el
+// puntaje llevado a ahora.
+func leerTendencia(ctx context.Context, rdb redis.Cmdable, zset, epoca string, n int, ahora time.Time) ([]entradaTendencia, error) {
+	pipe := rdb.Pipeline()
+	ep := pipe.HGetAll(ctx, epoca)
+	zs := pipe.ZRevRangeWithScores(ctx, zset, 0, int64(n-1))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	lista := make([]entradaTendencia, 0, len(zs.Val()))
+	t0, err1 := strconv.ParseFloat(ep.Val()["t0"], 64)
+	vida, err2 := strconv.ParseFloat(ep.Val()["vida"], 64)
+	if err1 != nil || err2 != nil || vida <= 0 {
+		return lista, nil
+	}
+	factor := math.Exp2(-(float64(ahora.UnixMilli())/1000 - t0) / vida)
+	for _, z := range zs.Val() {
+		p := z.Score * factor
+		lista = append(lista, entradaTendencia{
+			ID:        z.Member.(string),
+			Puntaje:   math.Round(p*1000) / 1000,
+			Velocidad: math.Round(p*math.Ln2/(vida/60)*1000) / 1000,
+		})
+	}
+	return lista, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+
+	"go-common/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	mdCallerIdentity  = "x-caller-identity"
+	mdCallerSignature = "x-caller-signature"
+	headerIdentidad   = "caller-identity"
+	// identidadAnonima es la que pone el bridge sin auth; no cuenta como
+	// identidad cuando se exige una.
+	identidadAnonima = "anonimo"
+)
+
+type ctxKeyIdentidad struct{}
+
+func identidadDe(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKeyIdentidad{}).(string)
+	return id
+}
+
+// authConfig replica en el writer la identidad autenticada por el bridge.
+// La lista de identidades permitidas se lee del loader en cada llamada, asi
+// se puede cambiar sin reiniciar.
+type authConfig struct {
+	requerida  bool
+	secreto    []byte
+	permitidas func() []string
+}
+
+func newAuthConfig(loader *config.Loader) authConfig {
+	cfg := loader.Get().Auth
+	return authConfig{
+		requerida:  cfg.RequireIdentity,
+		secreto:    []byte(cfg.IdentitySecret),
+		permitidas: func() []string { return loader.Get().Auth.AllowedIdentities },
+	}
+}
+
+func (a authConfig) verificar(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var id, firma string
+	if v := md.Get(mdCallerIdentity); len(v) > 0 {
+		id = v[0]
+	}
+	if v := md.Get(mdCallerSignature); len(v) > 0 {
+		firma = v[0]
+	}
+	if len(a.secreto) == 0 {
+		// Sin secreto no se puede saber si la identidad la puso el bridge;
+		// se descarta en lugar de confiar en ella. La configuracion no
+		// permite exigir ni filtrar identidades en ese caso.
+		id = ""
+	}
+	if id == "" || (a.requerida && id == identidadAnonima) {
+		if a.requerida {
+			return "", status.Error(codes.Unauthenticated, "identidad requerida")
+		}
+		return "", nil
+	}
+	mac := hmac.New(sha256.New, a.secreto)
+	mac.Write([]byte(id))
+	esperada := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(esperada), []byte(firma)) {
+		return "", status.Error(codes.Unauthenticated, "firma de identidad invalida")
+	}
+	if permitidas := a.permitidas(); len(permitidas) > 0 && !slices.Contains(permitidas, id) {
+		return "", status.Errorf(codes.PermissionDenied, "identidad %q no permitida", id)
+	}
+	return id, nil
+}
+
+// UnaryInterceptor valida la identidad del llamante y la deja en el contexto
+// para que ProcesarVenta la registre como header de Kafka.
+func (a authConfig) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		id, err := a.verificar(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if id != "" {
+			ctx = context.WithValue(ctx, ctxKeyIdentidad{}, id)
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const secretoPrueba = "secreto-de-prueba"
+
+func firmar(secreto, id string) string {
+	mac := hmac.New(sha256.New, []byte(secreto))
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func nuevaAuth(requerida bool, secreto string, permitidas ...string) authConfig {
+	return authConfig{
+		requerida:  requerida,
+		secreto:    []byte(secreto),
+		permitidas: func() []string { return permitidas },
+	}
+}
+
+func contextoCon(pares ...string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(pares...))
+}
+
+func TestVerificarIdentidad(t *testing.T) {
+	firmada := func(id string) context.Context {
+		return contextoCon(mdCallerIdentity, id, mdCallerSignature, firmar(secretoPrueba, id))
+	}
+	casos := []struct {
+		nombre    string
+		auth      authConfig
+		ctx       context.Context
+		identidad string
+		codigo    codes.Code
+	}{
+		{"firma valida", nuevaAuth(false, secretoPrueba), firmada("cliente-1"), "cliente-1", codes.OK},
+		{"sin firma", nuevaAuth(false, secretoPrueba), contextoCon(mdCallerIdentity, "cliente-1"), "", codes.Unauthenticated},
+		{"firma de otro secreto", nuevaAuth(false, secretoPrueba),
+			contextoCon(mdCallerIdentity, "cliente-1", mdCallerSignature, firmar("otro", "cliente-1")), "", codes.Unauthenticated},
+		{"firma de otra identidad", nuevaAuth(false, secretoPrueba),
+			contextoCon(mdCallerIdentity, "admin", mdCallerSignature, firmar(secretoPrueba, "cliente-1")), "", codes.Unauthenticated},
+		{"sin identidad y opcional", nuevaAuth(false, secretoPrueba), contextoCon(), "", codes.OK},
+		{"sin identidad y requerida", nuevaAuth(true, secretoPrueba), contextoCon(), "", codes.Unauthenticated},
+		{"anonima y requerida", nuevaAuth(true, secretoPrueba), firmada(identidadAnonima), "", codes.Unauthenticated},
+		{"anonima y opcional", nuevaAuth(false, secretoPrueba), firmada(identidadAnonima), identidadAnonima, codes.OK},
+		{"permitida", nuevaAuth(false, secretoPrueba, "bridge", "cliente-1"), firmada("cliente-1"), "cliente-1", codes.OK},
+		{"no permitida", nuevaAuth(false, secretoPrueba, "bridge"), firmada("cliente-1"), "", codes.PermissionDenied},
+		// Sin secreto cualquiera podria declarar una identidad: se descarta.
+		{"sin secreto se descarta", nuevaAuth(false, ""), contextoCon(mdCallerIdentity, "admin"), "", codes.OK},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			id, err := c.auth.verificar(c.ctx)
+			if got := status.Code(err); got != c.codigo {
+				t.Fatalf("codigo %s, se esperaba %s (%v)", got, c.codigo, err)
+			}
+			if id != c.identidad {
+				t.Errorf("identidad %q, se esperaba %q", id, c.identidad)
+			}
+		})
+	}
+}
+
+func TestInterceptorGuardaIdentidad(t *testing.T) {
+	a := nuevaAuth(false, secretoPrueba)
+	ctx := contextoCon(mdCallerIdentity, "cliente-1", mdCallerSignature, firmar(secretoPrueba, "cliente-1"))
+	var vista string
+	_, err := a.UnaryInterceptor()(ctx, nil, nil, func(ctx context.Context, req any) (any, error) {
+		vista = identidadDe(ctx)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vista != "cliente-1" {
+		t.Errorf("el handler vio la identidad %q", vista)
+	}
+}
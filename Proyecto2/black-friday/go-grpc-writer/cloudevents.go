@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	pb "go-grpc-writer/pb"
+
+	"github.com/IBM/sarama"
+)
+
+// Formatos de writer.cloudevents, segun el binding de Kafka de CloudEvents
+// 1.0: en binary los atributos van en headers ce_* y el valor es la venta; en
+// structured todo el evento va en el valor.
+const (
+	formatoBinary     = "binary"
+	formatoStructured = "structured"
+
+	contentTypeJSON        = "application/json"
+	contentTypeCloudEvents = "application/cloudevents+json; charset=UTF-8"
+)
+
+// eventoEstructurado es un CloudEvent en modo structured. tenant e
+// ingesttime son atributos de extension.
+type eventoEstructurado struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Tenant          string          `json:"tenant,omitempty"`
+	IngestTime      string          `json:"ingesttime"`
+	Data            json.RawMessage `json:"data"`
+}
+
+func tiempoCE(ns int64) string {
+	return time.Unix(0, ns).UTC().Format(time.RFC3339Nano)
+}
+
+// codificar arma el valor y los headers del registro; tipo es el type de
+// CloudEvents (eventid.TipoVenta, TipoReembolso o TipoCancelacion). Los datos
+// se serializan igual en los tres formatos, asi un consumer que solo entiende
+// JSON plano sigue leyendo el valor en modo binary.
+func codificar(datos any, sobre *pb.Envelope, tipo, formato string) ([]byte, []sarama.RecordHeader, error) {
+	venta, err := json.Marshal(datos)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch formato {
+	case formatoBinary:
+		h := func(k, v string) sarama.RecordHeader {
+			return sarama.RecordHeader{Key: []byte(k), Value: []byte(v)}
+		}
+		headers := []sarama.RecordHeader{
+			h("ce_specversion", "1.0"),
+			h("ce_id", sobre.EventId),
+			h("ce_source", sobre.Source),
+			h("ce_type", tipo),
+			h("ce_time", tiempoCE(sobre.EventTime)),
+			h("ce_ingesttime", tiempoCE(sobre.IngestTime)),
+			h("content-type", contentTypeJSON),
+		}
+		if sobre.Tenant != "" {
+			headers = append(headers, h("ce_tenant", sobre.Tenant))
+		}
+		return venta, headers, nil
+	case formatoStructured:
+		valor, err := json.Marshal(eventoEstructurado{
+			SpecVersion:     "1.0",
+			ID:              sobre.EventId,
+			Source:          sobre.Source,
+			Type:            tipo,
+			Time:            tiempoCE(sobre.EventTime),
+			DataContentType: contentTypeJSON,
+			Tenant:          sobre.Tenant,
+			IngestTime:      tiempoCE(sobre.IngestTime),
+			Data:            venta,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return valor, []sarama.RecordHeader{{Key: []byte("content-type"), Value: []byte(contentTypeCloudEvents)}}, nil
+	}
+	return venta, nil, nil
+}
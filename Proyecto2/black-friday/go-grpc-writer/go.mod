@@ -4,6 +4,12 @@ go 1.24.0
 
 require (
 	github.com/IBM/sarama v1.43.0
+	github.com/xdg-go/scram v1.1.2
 	google.golang.org/grpc v1.65.0
 	google.golang.org/protobuf v1.34.2
-)
\ No newline at end of file
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require go-common v0.0.0
+
+replace go-common => ../go-common
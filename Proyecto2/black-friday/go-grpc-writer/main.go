@@ -5,63 +5,248 @@ import (
 	"encoding/json"
 	"log"
 	"net"
-	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"go-common/config"
+	"go-common/eventid"
+	"go-common/kafkaconf"
 	pb "go-grpc-writer/pb"
+	"go-common/tlsutil"
 
 	"github.com/IBM/sarama"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 type server struct {
 	pb.UnimplementedProductSaleServiceServer
-	producer sarama.SyncProducer
+	producer        sarama.SyncProducer
+	topic           string
+	topicInventario string
+	cfg             *config.Loader
+}
+
+// Headers con las marcas de tiempo por salto, en nanosegundos Unix. El salto
+// de Kafka lo aporta el timestamp del registro (LogAppendTime en sales-topic).
+const (
+	headerBridgeRecibido = "t-bridge-recibido"
+	headerBridgeEnviado  = "t-bridge-enviado"
+	headerWriterRecibido = "t-writer-recibido"
+)
+
+// Headers con el id y la version del sobre y el tipo de evento, para
+// filtrar o deduplicar sin decodificar el valor.
+const (
+	headerEventID      = "event-id"
+	headerEventVersion = "event-version"
+	headerEventType    = "event-type"
+)
+
+func headerSello(key string, ns int64) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(key), Value: []byte(strconv.FormatInt(ns, 10))}
+}
+
+// completarSobre arma el sobre si el bridge no lo envio (los anteriores al
+// sobre no lo hacen), asi todo lo que llega a Kafka tiene id y hora de
+// ingreso; si vino, valida el id.
+func completarSobre(sobre *pb.Envelope, recibido int64) (*pb.Envelope, error) {
+	if sobre != nil {
+		return sobre, eventid.Validar(sobre.EventId)
+	}
+	return &pb.Envelope{
+		Version:    eventid.Version,
+		EventId:    eventid.Nuevo(time.Unix(0, recibido)),
+		EventTime:  recibido,
+		IngestTime: recibido,
+		Source:     "go-grpc-writer",
+	}, nil
+}
+
+// mensaje arma el registro para el topic de ventas con los headers comunes a
+// todos los tipos de evento.
+func (s *server) mensaje(ctx context.Context, datos any, sobre *pb.Envelope, tipo, clave string) (*sarama.ProducerMessage, error) {
+	valor, headers, err := codificar(datos, sobre, tipo, s.cfg.Get().Writer.CloudEvents)
+	if err != nil {
+		return nil, err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic:   s.topic,
+		Key:     sarama.StringEncoder(clave),
+		Value:   sarama.StringEncoder(valor),
+		Headers: headers,
+	}
+	msg.Headers = append(msg.Headers,
+		sarama.RecordHeader{Key: []byte(headerEventID), Value: []byte(sobre.EventId)},
+		sarama.RecordHeader{Key: []byte(headerEventVersion), Value: []byte(strconv.FormatUint(uint64(sobre.Version), 10))},
+		sarama.RecordHeader{Key: []byte(headerEventType), Value: []byte(tipo)})
+	if id := identidadDe(ctx); id != "" {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(headerIdentidad), Value: []byte(id)})
+	}
+	return msg, nil
 }
 
 func (s *server) ProcesarVenta(ctx context.Context, req *pb.ProductSaleRequest) (*pb.ProductSaleResponse, error) {
-	msgBytes, err := json.Marshal(req)
+	sellos := req.GetSellos()
+	if sellos == nil {
+		sellos = &pb.SellosLatencia{}
+		req.Sellos = sellos
+	}
+	sellos.WriterRecibido = time.Now().UnixNano()
+
+	sobre, err := completarSobre(req.GetEnvelope(), sellos.WriterRecibido)
 	if err != nil {
-		return &pb.ProductSaleResponse{Estado: "Error marshaling"}, nil
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	req.Envelope = sobre
 
-	msg := &sarama.ProducerMessage{
-		Topic: "sales-topic",
-		Value: sarama.StringEncoder(msgBytes),
+	// La clave es el event_id, asi un reembolso o cancelacion (que usa el id
+	// original como clave) cae en la misma particion que su venta.
+	msg, err := s.mensaje(ctx, req, sobre, eventid.TipoVenta, strings.ToLower(sobre.EventId))
+	if err != nil {
+		return &pb.ProductSaleResponse{Estado: "Error marshaling"}, nil
+	}
+	if sellos.BridgeRecibido != 0 {
+		msg.Headers = append(msg.Headers,
+			headerSello(headerBridgeRecibido, sellos.BridgeRecibido),
+			headerSello(headerBridgeEnviado, sellos.BridgeEnviado))
 	}
+	msg.Headers = append(msg.Headers, headerSello(headerWriterRecibido, sellos.WriterRecibido))
 
 	_, _, err = s.producer.SendMessage(msg)
 	if err != nil {
 		return &pb.ProductSaleResponse{Estado: "Error Kafka"}, nil
 	}
+	sellos.KafkaAck = time.Now().UnixNano()
+
+	return &pb.ProductSaleResponse{Estado: "Procesado", Sellos: sellos}, nil
+}
+
+// ProcesarReembolso publica la devolucion de unidades de una venta anterior.
+func (s *server) ProcesarReembolso(ctx context.Context, aj *pb.AjusteVenta) (*pb.ProductSaleResponse, error) {
+	aj.Tipo = "reembolso"
+	return s.publicarAjuste(ctx, aj, eventid.TipoReembolso)
+}
+
+// CancelarVenta publica la cancelacion de lo que quede de una venta anterior.
+func (s *server) CancelarVenta(ctx context.Context, aj *pb.AjusteVenta) (*pb.ProductSaleResponse, error) {
+	aj.Tipo, aj.Cantidad = "cancelacion", 0
+	return s.publicarAjuste(ctx, aj, eventid.TipoCancelacion)
+}
+
+// publicarAjuste escribe el ajuste en el topic de ventas con el id original
+// como clave. El writer no sabe si la venta existe; eso lo resuelve el
+// consumer, que es quien guarda las ventas.
+func (s *server) publicarAjuste(ctx context.Context, aj *pb.AjusteVenta, tipo string) (*pb.ProductSaleResponse, error) {
+	if err := eventid.Validar(aj.EventIdOriginal); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "event_id_original: "+err.Error())
+	}
+	if aj.Cantidad < 0 {
+		return nil, status.Error(codes.InvalidArgument, "cantidad debe ser >= 0")
+	}
+	sobre, err := completarSobre(aj.GetEnvelope(), time.Now().UnixNano())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	aj.Envelope = sobre
+
+	msg, err := s.mensaje(ctx, aj, sobre, tipo, strings.ToLower(aj.EventIdOriginal))
+	if err != nil {
+		return &pb.ProductSaleResponse{Estado: "Error marshaling"}, nil
+	}
+	if _, _, err := s.producer.SendMessage(msg); err != nil {
+		return &pb.ProductSaleResponse{Estado: "Error Kafka"}, nil
+	}
+	return &pb.ProductSaleResponse{Estado: "Procesado"}, nil
+}
 
+// PublicarInventario escribe en el topic de inventario los cambios de stock
+// que detecta el bridge. La clave es el producto, asi los eventos de un mismo
+// producto quedan en orden.
+func (s *server) PublicarInventario(ctx context.Context, ev *pb.EventoInventario) (*pb.ProductSaleResponse, error) {
+	if ev.GetEnvelope() == nil {
+		ahora := time.Now()
+		ev.Envelope = &pb.Envelope{
+			Version:    eventid.Version,
+			EventId:    eventid.Nuevo(ahora),
+			EventTime:  ahora.UnixNano(),
+			IngestTime: ahora.UnixNano(),
+			Source:     "go-grpc-writer",
+		}
+	}
+	valor, err := json.Marshal(ev)
+	if err != nil {
+		return &pb.ProductSaleResponse{Estado: "Error marshaling"}, nil
+	}
+	_, _, err = s.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   s.topicInventario,
+		Key:     sarama.StringEncoder(ev.ProductoId),
+		Value:   sarama.ByteEncoder(valor),
+		Headers: []sarama.RecordHeader{{Key: []byte(headerEventID), Value: []byte(ev.Envelope.EventId)}},
+	})
+	if err != nil {
+		return &pb.ProductSaleResponse{Estado: "Error Kafka"}, nil
+	}
 	return &pb.ProductSaleResponse{Estado: "Procesado"}, nil
 }
 
 func main() {
-	kafkaEnv := os.Getenv("KAFKA_BROKERS")
-	if kafkaEnv == "" {
-		kafkaEnv = "localhost:9092"
-	}
-	brokers := strings.Split(kafkaEnv, ",")
+	loader := config.MustLoad("go-grpc-writer")
+	cfg := loader.Get()
+	// writer.cloudevents y auth.allowed_identities se leen del loader en
+	// cada solicitud; el resto requiere reinicio.
+	go loader.Watch(context.Background(), 5*time.Second)
 
-	config := sarama.NewConfig()
-	config.Producer.Return.Successes = true
-	config.Producer.RequiredAcks = sarama.WaitForAll
-	
-	producer, err := sarama.NewSyncProducer(brokers, config)
+	saramaCfg, err := kafkaconf.New(kafkaconf.Kafka{
+		Version: cfg.Kafka.Version,
+		SASL:    kafkaconf.SASL(cfg.Kafka.SASL),
+		TLS:     kafkaconf.TLS(cfg.Kafka.TLS),
+	})
 	if err != nil {
 		log.Fatalf("Fatal Kafka: %v", err)
 	}
+	saramaCfg.Producer.Return.Successes = true
+	saramaCfg.Producer.RequiredAcks = sarama.WaitForAll
+
+	producer, err := sarama.NewSyncProducer(cfg.Kafka.Brokers, saramaCfg)
+	if err != nil {
+		log.Fatalf("Fatal Kafka: %v", kafkaconf.Explicar(err))
+	}
 	defer producer.Close()
 
-	lis, err := net.Listen("tcp", ":50051")
+	lis, err := net.Listen("tcp", cfg.Writer.Listen)
 	if err != nil {
 		log.Fatalf("Fatal Listen: %v", err)
 	}
 
-	s := grpc.NewServer()
-	pb.RegisterProductSaleServiceServer(s, &server{producer: producer})
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(newAuthConfig(loader).UnaryInterceptor())}
+	tlsOpts := tlsutil.Options{
+		Mode:        cfg.GRPCTLS.Mode,
+		CertFile:    cfg.GRPCTLS.Cert,
+		KeyFile:     cfg.GRPCTLS.Key,
+		CAFile:      cfg.GRPCTLS.CA,
+		AllowedSANs: cfg.GRPCTLS.AllowedSANs,
+		DevDir:      cfg.GRPCTLS.DevDir,
+	}
+	if tlsOpts.Enabled() {
+		tlsCfg, err := tlsutil.ServerConfig(tlsOpts)
+		if err != nil {
+			log.Fatalf("Fatal TLS: %v", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+		log.Printf("gRPC con TLS (modo %s)", tlsOpts.Mode)
+	}
+
+	s := grpc.NewServer(opts...)
+	pb.RegisterProductSaleServiceServer(s, &server{
+		producer:        producer,
+		topic:           cfg.Kafka.Topic,
+		topicInventario: cfg.Inventory.Topic,
+		cfg:             loader,
+	})
 
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("Fatal Serve: %v", err)
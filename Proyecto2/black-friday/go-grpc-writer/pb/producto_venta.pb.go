@@ -21,69 +21,23 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
-type CategoriaProducto int32
-
-const (
-	CategoriaProducto_UNKNOWN     CategoriaProducto = 0
-	CategoriaProducto_Electronica CategoriaProducto = 1
-	CategoriaProducto_Ropa        CategoriaProducto = 2
-	CategoriaProducto_Hogar       CategoriaProducto = 3
-	CategoriaProducto_Belleza     CategoriaProducto = 4
-)
-
-// Enum value maps for CategoriaProducto.
-var (
-	CategoriaProducto_name = map[int32]string{
-		0: "UNKNOWN",
-		1: "Electronica",
-		2: "Ropa",
-		3: "Hogar",
-		4: "Belleza",
-	}
-	CategoriaProducto_value = map[string]int32{
-		"UNKNOWN":     0,
-		"Electronica": 1,
-		"Ropa":        2,
-		"Hogar":       3,
-		"Belleza":     4,
-	}
-)
-
-func (x CategoriaProducto) Enum() *CategoriaProducto {
-	p := new(CategoriaProducto)
-	*p = x
-	return p
-}
-
-func (x CategoriaProducto) String() string {
-	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
-}
-
-func (CategoriaProducto) Descriptor() protoreflect.EnumDescriptor {
-	return file_producto_venta_proto_enumTypes[0].Descriptor()
-}
-
-func (CategoriaProducto) Type() protoreflect.EnumType {
-	return &file_producto_venta_proto_enumTypes[0]
-}
-
-func (x CategoriaProducto) Number() protoreflect.EnumNumber {
-	return protoreflect.EnumNumber(x)
-}
-
-// Deprecated: Use CategoriaProducto.Descriptor instead.
-func (CategoriaProducto) EnumDescriptor() ([]byte, []int) {
-	return file_producto_venta_proto_rawDescGZIP(), []int{0}
-}
-
 type ProductSaleRequest struct {
-	state           protoimpl.MessageState `protogen:"open.v1"`
-	Categoria       CategoriaProducto      `protobuf:"varint,1,opt,name=categoria,proto3,enum=blackfriday.CategoriaProducto" json:"categoria,omitempty"`
-	ProductoId      string                 `protobuf:"bytes,2,opt,name=producto_id,json=productoId,proto3" json:"producto_id,omitempty"`
-	Precio          float64                `protobuf:"fixed64,3,opt,name=precio,proto3" json:"precio,omitempty"`
-	CantidadVendida int32                  `protobuf:"varint,4,opt,name=cantidad_vendida,json=cantidadVendida,proto3" json:"cantidad_vendida,omitempty"`
-	unknownFields   protoimpl.UnknownFields
-	sizeCache       protoimpl.SizeCache
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Id del registro de categorias; antes era el enum CategoriaProducto, con
+	// el mismo encoding en el cable.
+	Categoria       int32           `protobuf:"varint,1,opt,name=categoria,proto3" json:"categoria,omitempty"`
+	ProductoId      string          `protobuf:"bytes,2,opt,name=producto_id,json=productoId,proto3" json:"producto_id,omitempty"`
+	Precio          float64         `protobuf:"fixed64,3,opt,name=precio,proto3" json:"precio,omitempty"`
+	CantidadVendida int32           `protobuf:"varint,4,opt,name=cantidad_vendida,json=cantidadVendida,proto3" json:"cantidad_vendida,omitempty"`
+	Sellos          *SellosLatencia `protobuf:"bytes,5,opt,name=sellos,proto3" json:"sellos,omitempty"`
+	// Venta sintetica del canary; el consumer la guarda aparte.
+	Canary        bool              `protobuf:"varint,6,opt,name=canary,proto3" json:"canary,omitempty"`
+	Envelope      *Envelope         `protobuf:"bytes,7,opt,name=envelope,proto3" json:"envelope,omitempty"`
+	Catalogo      *ProductoCatalogo `protobuf:"bytes,8,opt,name=catalogo,proto3" json:"catalogo,omitempty"`
+	Inventario    *InventarioVenta  `protobuf:"bytes,9,opt,name=inventario,proto3" json:"inventario,omitempty"`
+	Campana       *CampanaVenta     `protobuf:"bytes,10,opt,name=campana,proto3" json:"campana,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ProductSaleRequest) Reset() {
@@ -116,11 +70,11 @@ func (*ProductSaleRequest) Descriptor() ([]byte, []int) {
 	return file_producto_venta_proto_rawDescGZIP(), []int{0}
 }
 
-func (x *ProductSaleRequest) GetCategoria() CategoriaProducto {
+func (x *ProductSaleRequest) GetCategoria() int32 {
 	if x != nil {
 		return x.Categoria
 	}
-	return CategoriaProducto_UNKNOWN
+	return 0
 }
 
 func (x *ProductSaleRequest) GetProductoId() string {
@@ -144,17 +98,618 @@ func (x *ProductSaleRequest) GetCantidadVendida() int32 {
 	return 0
 }
 
+func (x *ProductSaleRequest) GetSellos() *SellosLatencia {
+	if x != nil {
+		return x.Sellos
+	}
+	return nil
+}
+
+func (x *ProductSaleRequest) GetCanary() bool {
+	if x != nil {
+		return x.Canary
+	}
+	return false
+}
+
+func (x *ProductSaleRequest) GetEnvelope() *Envelope {
+	if x != nil {
+		return x.Envelope
+	}
+	return nil
+}
+
+func (x *ProductSaleRequest) GetCatalogo() *ProductoCatalogo {
+	if x != nil {
+		return x.Catalogo
+	}
+	return nil
+}
+
+func (x *ProductSaleRequest) GetInventario() *InventarioVenta {
+	if x != nil {
+		return x.Inventario
+	}
+	return nil
+}
+
+func (x *ProductSaleRequest) GetCampana() *CampanaVenta {
+	if x != nil {
+		return x.Campana
+	}
+	return nil
+}
+
+// Campana que el bridge aplico a la venta. precio del request queda como lo
+// envio el cliente; precio_efectivo es el que resulta de la campana.
+type CampanaVenta struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Id             string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Nombre         string                 `protobuf:"bytes,2,opt,name=nombre,proto3" json:"nombre,omitempty"`
+	PrecioEfectivo float64                `protobuf:"fixed64,3,opt,name=precio_efectivo,json=precioEfectivo,proto3" json:"precio_efectivo,omitempty"`
+	DescuentoPct   float64                `protobuf:"fixed64,4,opt,name=descuento_pct,json=descuentoPct,proto3" json:"descuento_pct,omitempty"`
+	// La venta entro por el acceso anticipado, antes del inicio publico.
+	AccesoAnticipado bool `protobuf:"varint,5,opt,name=acceso_anticipado,json=accesoAnticipado,proto3" json:"acceso_anticipado,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *CampanaVenta) Reset() {
+	*x = CampanaVenta{}
+	mi := &file_producto_venta_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CampanaVenta) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CampanaVenta) ProtoMessage() {}
+
+func (x *CampanaVenta) ProtoReflect() protoreflect.Message {
+	mi := &file_producto_venta_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CampanaVenta.ProtoReflect.Descriptor instead.
+func (*CampanaVenta) Descriptor() ([]byte, []int) {
+	return file_producto_venta_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CampanaVenta) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *CampanaVenta) GetNombre() string {
+	if x != nil {
+		return x.Nombre
+	}
+	return ""
+}
+
+func (x *CampanaVenta) GetPrecioEfectivo() float64 {
+	if x != nil {
+		return x.PrecioEfectivo
+	}
+	return 0
+}
+
+func (x *CampanaVenta) GetDescuentoPct() float64 {
+	if x != nil {
+		return x.DescuentoPct
+	}
+	return 0
+}
+
+func (x *CampanaVenta) GetAccesoAnticipado() bool {
+	if x != nil {
+		return x.AccesoAnticipado
+	}
+	return false
+}
+
+// Stock del producto despues de reservar la venta; falta si el producto no
+// tiene inventario.
+type InventarioVenta struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Disponible    int64                  `protobuf:"varint,1,opt,name=disponible,proto3" json:"disponible,omitempty"`
+	Total         int64                  `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InventarioVenta) Reset() {
+	*x = InventarioVenta{}
+	mi := &file_producto_venta_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InventarioVenta) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InventarioVenta) ProtoMessage() {}
+
+func (x *InventarioVenta) ProtoReflect() protoreflect.Message {
+	mi := &file_producto_venta_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InventarioVenta.ProtoReflect.Descriptor instead.
+func (*InventarioVenta) Descriptor() ([]byte, []int) {
+	return file_producto_venta_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *InventarioVenta) GetDisponible() int64 {
+	if x != nil {
+		return x.Disponible
+	}
+	return 0
+}
+
+func (x *InventarioVenta) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+// Reembolso o cancelacion de una venta ya publicada, identificada por el
+// event_id de su sobre. Va al mismo topic que las ventas con su propio type
+// de CloudEvents y con el id original como clave, asi cae en la misma
+// particion que la venta y se procesa despues de ella.
+type AjusteVenta struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	EventIdOriginal string                 `protobuf:"bytes,1,opt,name=event_id_original,json=eventIdOriginal,proto3" json:"event_id_original,omitempty"`
+	// Unidades que se devuelven; 0 es lo que quede de la venta. Una
+	// cancelacion siempre revierte lo que quede.
+	Cantidad int32     `protobuf:"varint,2,opt,name=cantidad,proto3" json:"cantidad,omitempty"`
+	Motivo   string    `protobuf:"bytes,3,opt,name=motivo,proto3" json:"motivo,omitempty"`
+	Envelope *Envelope `protobuf:"bytes,4,opt,name=envelope,proto3" json:"envelope,omitempty"`
+	// "reembolso" o "cancelacion"; lo llena el writer segun el rpc.
+	Tipo          string `protobuf:"bytes,5,opt,name=tipo,proto3" json:"tipo,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AjusteVenta) Reset() {
+	*x = AjusteVenta{}
+	mi := &file_producto_venta_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AjusteVenta) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AjusteVenta) ProtoMessage() {}
+
+func (x *AjusteVenta) ProtoReflect() protoreflect.Message {
+	mi := &file_producto_venta_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AjusteVenta.ProtoReflect.Descriptor instead.
+func (*AjusteVenta) Descriptor() ([]byte, []int) {
+	return file_producto_venta_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AjusteVenta) GetEventIdOriginal() string {
+	if x != nil {
+		return x.EventIdOriginal
+	}
+	return ""
+}
+
+func (x *AjusteVenta) GetCantidad() int32 {
+	if x != nil {
+		return x.Cantidad
+	}
+	return 0
+}
+
+func (x *AjusteVenta) GetMotivo() string {
+	if x != nil {
+		return x.Motivo
+	}
+	return ""
+}
+
+func (x *AjusteVenta) GetEnvelope() *Envelope {
+	if x != nil {
+		return x.Envelope
+	}
+	return nil
+}
+
+func (x *AjusteVenta) GetTipo() string {
+	if x != nil {
+		return x.Tipo
+	}
+	return ""
+}
+
+// Cambio de stock que el bridge publica en el topic de inventario. tipo es
+// "agotado", "stock_bajo" o "reabastecido"; total es todo lo recibido desde
+// la primera carga.
+type EventoInventario struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tipo          string                 `protobuf:"bytes,1,opt,name=tipo,proto3" json:"tipo,omitempty"`
+	ProductoId    string                 `protobuf:"bytes,2,opt,name=producto_id,json=productoId,proto3" json:"producto_id,omitempty"`
+	Disponible    int64                  `protobuf:"varint,3,opt,name=disponible,proto3" json:"disponible,omitempty"`
+	Total         int64                  `protobuf:"varint,4,opt,name=total,proto3" json:"total,omitempty"`
+	Umbral        int64                  `protobuf:"varint,5,opt,name=umbral,proto3" json:"umbral,omitempty"`
+	Envelope      *Envelope              `protobuf:"bytes,6,opt,name=envelope,proto3" json:"envelope,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EventoInventario) Reset() {
+	*x = EventoInventario{}
+	mi := &file_producto_venta_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EventoInventario) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EventoInventario) ProtoMessage() {}
+
+func (x *EventoInventario) ProtoReflect() protoreflect.Message {
+	mi := &file_producto_venta_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EventoInventario.ProtoReflect.Descriptor instead.
+func (*EventoInventario) Descriptor() ([]byte, []int) {
+	return file_producto_venta_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *EventoInventario) GetTipo() string {
+	if x != nil {
+		return x.Tipo
+	}
+	return ""
+}
+
+func (x *EventoInventario) GetProductoId() string {
+	if x != nil {
+		return x.ProductoId
+	}
+	return ""
+}
+
+func (x *EventoInventario) GetDisponible() int64 {
+	if x != nil {
+		return x.Disponible
+	}
+	return 0
+}
+
+func (x *EventoInventario) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *EventoInventario) GetUmbral() int64 {
+	if x != nil {
+		return x.Umbral
+	}
+	return 0
+}
+
+func (x *EventoInventario) GetEnvelope() *Envelope {
+	if x != nil {
+		return x.Envelope
+	}
+	return nil
+}
+
+// Lo que el bridge encontro en el catalogo de productos. producto_id del
+// request ya viene normalizado al id canonico.
+type ProductoCatalogo struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Nombre      string                 `protobuf:"bytes,1,opt,name=nombre,proto3" json:"nombre,omitempty"`
+	Categoria   int32                  `protobuf:"varint,2,opt,name=categoria,proto3" json:"categoria,omitempty"`
+	PrecioLista float64                `protobuf:"fixed64,3,opt,name=precio_lista,json=precioLista,proto3" json:"precio_lista,omitempty"`
+	// producto_id tal como lo envio el cliente cuando era un alias.
+	Alias       string `protobuf:"bytes,4,opt,name=alias,proto3" json:"alias,omitempty"`
+	Desconocido bool   `protobuf:"varint,5,opt,name=desconocido,proto3" json:"desconocido,omitempty"`
+	// La categoria del payload no era la del catalogo; categoria_payload es
+	// la que venia.
+	CategoriaDistinta bool  `protobuf:"varint,6,opt,name=categoria_distinta,json=categoriaDistinta,proto3" json:"categoria_distinta,omitempty"`
+	CategoriaPayload  int32 `protobuf:"varint,7,opt,name=categoria_payload,json=categoriaPayload,proto3" json:"categoria_payload,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *ProductoCatalogo) Reset() {
+	*x = ProductoCatalogo{}
+	mi := &file_producto_venta_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProductoCatalogo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProductoCatalogo) ProtoMessage() {}
+
+func (x *ProductoCatalogo) ProtoReflect() protoreflect.Message {
+	mi := &file_producto_venta_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProductoCatalogo.ProtoReflect.Descriptor instead.
+func (*ProductoCatalogo) Descriptor() ([]byte, []int) {
+	return file_producto_venta_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ProductoCatalogo) GetNombre() string {
+	if x != nil {
+		return x.Nombre
+	}
+	return ""
+}
+
+func (x *ProductoCatalogo) GetCategoria() int32 {
+	if x != nil {
+		return x.Categoria
+	}
+	return 0
+}
+
+func (x *ProductoCatalogo) GetPrecioLista() float64 {
+	if x != nil {
+		return x.PrecioLista
+	}
+	return 0
+}
+
+func (x *ProductoCatalogo) GetAlias() string {
+	if x != nil {
+		return x.Alias
+	}
+	return ""
+}
+
+func (x *ProductoCatalogo) GetDesconocido() bool {
+	if x != nil {
+		return x.Desconocido
+	}
+	return false
+}
+
+func (x *ProductoCatalogo) GetCategoriaDistinta() bool {
+	if x != nil {
+		return x.CategoriaDistinta
+	}
+	return false
+}
+
+func (x *ProductoCatalogo) GetCategoriaPayload() int32 {
+	if x != nil {
+		return x.CategoriaPayload
+	}
+	return 0
+}
+
+// Sobre versionado del evento. event_id es un UUIDv7 que asigna el bridge o
+// envia el cliente; event_time es la hora de la venta segun el cliente e
+// ingest_time la llegada al bridge, ambos en nanosegundos Unix.
+type Envelope struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       uint32                 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	EventId       string                 `protobuf:"bytes,2,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	EventTime     int64                  `protobuf:"varint,3,opt,name=event_time,json=eventTime,proto3" json:"event_time,omitempty"`
+	IngestTime    int64                  `protobuf:"varint,4,opt,name=ingest_time,json=ingestTime,proto3" json:"ingest_time,omitempty"`
+	Source        string                 `protobuf:"bytes,5,opt,name=source,proto3" json:"source,omitempty"`
+	Tenant        string                 `protobuf:"bytes,6,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Envelope) Reset() {
+	*x = Envelope{}
+	mi := &file_producto_venta_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Envelope) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Envelope) ProtoMessage() {}
+
+func (x *Envelope) ProtoReflect() protoreflect.Message {
+	mi := &file_producto_venta_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Envelope.ProtoReflect.Descriptor instead.
+func (*Envelope) Descriptor() ([]byte, []int) {
+	return file_producto_venta_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Envelope) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Envelope) GetEventId() string {
+	if x != nil {
+		return x.EventId
+	}
+	return ""
+}
+
+func (x *Envelope) GetEventTime() int64 {
+	if x != nil {
+		return x.EventTime
+	}
+	return 0
+}
+
+func (x *Envelope) GetIngestTime() int64 {
+	if x != nil {
+		return x.IngestTime
+	}
+	return 0
+}
+
+func (x *Envelope) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Envelope) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+// Marcas de tiempo en nanosegundos Unix que agrega cada salto. El bridge
+// llena las suyas en la solicitud; el writer devuelve en la respuesta las
+// de recepcion y ack de Kafka.
+type SellosLatencia struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	BridgeRecibido int64                  `protobuf:"varint,1,opt,name=bridge_recibido,json=bridgeRecibido,proto3" json:"bridge_recibido,omitempty"`
+	BridgeEnviado  int64                  `protobuf:"varint,2,opt,name=bridge_enviado,json=bridgeEnviado,proto3" json:"bridge_enviado,omitempty"`
+	WriterRecibido int64                  `protobuf:"varint,3,opt,name=writer_recibido,json=writerRecibido,proto3" json:"writer_recibido,omitempty"`
+	KafkaAck       int64                  `protobuf:"varint,4,opt,name=kafka_ack,json=kafkaAck,proto3" json:"kafka_ack,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *SellosLatencia) Reset() {
+	*x = SellosLatencia{}
+	mi := &file_producto_venta_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SellosLatencia) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SellosLatencia) ProtoMessage() {}
+
+func (x *SellosLatencia) ProtoReflect() protoreflect.Message {
+	mi := &file_producto_venta_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SellosLatencia.ProtoReflect.Descriptor instead.
+func (*SellosLatencia) Descriptor() ([]byte, []int) {
+	return file_producto_venta_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SellosLatencia) GetBridgeRecibido() int64 {
+	if x != nil {
+		return x.BridgeRecibido
+	}
+	return 0
+}
+
+func (x *SellosLatencia) GetBridgeEnviado() int64 {
+	if x != nil {
+		return x.BridgeEnviado
+	}
+	return 0
+}
+
+func (x *SellosLatencia) GetWriterRecibido() int64 {
+	if x != nil {
+		return x.WriterRecibido
+	}
+	return 0
+}
+
+func (x *SellosLatencia) GetKafkaAck() int64 {
+	if x != nil {
+		return x.KafkaAck
+	}
+	return 0
+}
+
 type ProductSaleResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Estado        string                 `protobuf:"bytes,1,opt,name=estado,proto3" json:"estado,omitempty"`
 	Exito         bool                   `protobuf:"varint,2,opt,name=exito,proto3" json:"exito,omitempty"`
+	Sellos        *SellosLatencia        `protobuf:"bytes,3,opt,name=sellos,proto3" json:"sellos,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ProductSaleResponse) Reset() {
 	*x = ProductSaleResponse{}
-	mi := &file_producto_venta_proto_msgTypes[1]
+	mi := &file_producto_venta_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -166,7 +721,7 @@ func (x *ProductSaleResponse) String() string {
 func (*ProductSaleResponse) ProtoMessage() {}
 
 func (x *ProductSaleResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_producto_venta_proto_msgTypes[1]
+	mi := &file_producto_venta_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -179,7 +734,7 @@ func (x *ProductSaleResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ProductSaleResponse.ProtoReflect.Descriptor instead.
 func (*ProductSaleResponse) Descriptor() ([]byte, []int) {
-	return file_producto_venta_proto_rawDescGZIP(), []int{1}
+	return file_producto_venta_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *ProductSaleResponse) GetEstado() string {
@@ -196,28 +751,91 @@ func (x *ProductSaleResponse) GetExito() bool {
 	return false
 }
 
+func (x *ProductSaleResponse) GetSellos() *SellosLatencia {
+	if x != nil {
+		return x.Sellos
+	}
+	return nil
+}
+
 var File_producto_venta_proto protoreflect.FileDescriptor
 
 const file_producto_venta_proto_rawDesc = "" +
 	"\n" +
-	"\x14producto_venta.proto\x12\vblackfriday\"\xb6\x01\n" +
-	"\x12ProductSaleRequest\x12<\n" +
-	"\tcategoria\x18\x01 \x01(\x0e2\x1e.blackfriday.CategoriaProductoR\tcategoria\x12\x1f\n" +
+	"\x14producto_venta.proto\x12\vblackfriday\"\xc4\x03\n" +
+	"\x12ProductSaleRequest\x12\x1c\n" +
+	"\tcategoria\x18\x01 \x01(\x05R\tcategoria\x12\x1f\n" +
 	"\vproducto_id\x18\x02 \x01(\tR\n" +
 	"productoId\x12\x16\n" +
 	"\x06precio\x18\x03 \x01(\x01R\x06precio\x12)\n" +
-	"\x10cantidad_vendida\x18\x04 \x01(\x05R\x0fcantidadVendida\"C\n" +
+	"\x10cantidad_vendida\x18\x04 \x01(\x05R\x0fcantidadVendida\x123\n" +
+	"\x06sellos\x18\x05 \x01(\v2\x1b.blackfriday.SellosLatenciaR\x06sellos\x12\x16\n" +
+	"\x06canary\x18\x06 \x01(\bR\x06canary\x121\n" +
+	"\benvelope\x18\a \x01(\v2\x15.blackfriday.EnvelopeR\benvelope\x129\n" +
+	"\bcatalogo\x18\b \x01(\v2\x1d.blackfriday.ProductoCatalogoR\bcatalogo\x12<\n" +
+	"\n" +
+	"inventario\x18\t \x01(\v2\x1c.blackfriday.InventarioVentaR\n" +
+	"inventario\x123\n" +
+	"\acampana\x18\n" +
+	" \x01(\v2\x19.blackfriday.CampanaVentaR\acampana\"\xb1\x01\n" +
+	"\fCampanaVenta\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06nombre\x18\x02 \x01(\tR\x06nombre\x12'\n" +
+	"\x0fprecio_efectivo\x18\x03 \x01(\x01R\x0eprecioEfectivo\x12#\n" +
+	"\rdescuento_pct\x18\x04 \x01(\x01R\fdescuentoPct\x12+\n" +
+	"\x11acceso_anticipado\x18\x05 \x01(\bR\x10accesoAnticipado\"G\n" +
+	"\x0fInventarioVenta\x12\x1e\n" +
+	"\n" +
+	"disponible\x18\x01 \x01(\x03R\n" +
+	"disponible\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\x03R\x05total\"\xb4\x01\n" +
+	"\vAjusteVenta\x12*\n" +
+	"\x11event_id_original\x18\x01 \x01(\tR\x0feventIdOriginal\x12\x1a\n" +
+	"\bcantidad\x18\x02 \x01(\x05R\bcantidad\x12\x16\n" +
+	"\x06motivo\x18\x03 \x01(\tR\x06motivo\x121\n" +
+	"\benvelope\x18\x04 \x01(\v2\x15.blackfriday.EnvelopeR\benvelope\x12\x12\n" +
+	"\x04tipo\x18\x05 \x01(\tR\x04tipo\"\xc8\x01\n" +
+	"\x10EventoInventario\x12\x12\n" +
+	"\x04tipo\x18\x01 \x01(\tR\x04tipo\x12\x1f\n" +
+	"\vproducto_id\x18\x02 \x01(\tR\n" +
+	"productoId\x12\x1e\n" +
+	"\n" +
+	"disponible\x18\x03 \x01(\x03R\n" +
+	"disponible\x12\x14\n" +
+	"\x05total\x18\x04 \x01(\x03R\x05total\x12\x16\n" +
+	"\x06umbral\x18\x05 \x01(\x03R\x06umbral\x121\n" +
+	"\benvelope\x18\x06 \x01(\v2\x15.blackfriday.EnvelopeR\benvelope\"\xff\x01\n" +
+	"\x10ProductoCatalogo\x12\x16\n" +
+	"\x06nombre\x18\x01 \x01(\tR\x06nombre\x12\x1c\n" +
+	"\tcategoria\x18\x02 \x01(\x05R\tcategoria\x12!\n" +
+	"\fprecio_lista\x18\x03 \x01(\x01R\vprecioLista\x12\x14\n" +
+	"\x05alias\x18\x04 \x01(\tR\x05alias\x12 \n" +
+	"\vdesconocido\x18\x05 \x01(\bR\vdesconocido\x12-\n" +
+	"\x12categoria_distinta\x18\x06 \x01(\bR\x11categoriaDistinta\x12+\n" +
+	"\x11categoria_payload\x18\a \x01(\x05R\x10categoriaPayload\"\xaf\x01\n" +
+	"\bEnvelope\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\rR\aversion\x12\x19\n" +
+	"\bevent_id\x18\x02 \x01(\tR\aeventId\x12\x1d\n" +
+	"\n" +
+	"event_time\x18\x03 \x01(\x03R\teventTime\x12\x1f\n" +
+	"\vingest_time\x18\x04 \x01(\x03R\n" +
+	"ingestTime\x12\x16\n" +
+	"\x06source\x18\x05 \x01(\tR\x06source\x12\x16\n" +
+	"\x06tenant\x18\x06 \x01(\tR\x06tenant\"\xa6\x01\n" +
+	"\x0eSellosLatencia\x12'\n" +
+	"\x0fbridge_recibido\x18\x01 \x01(\x03R\x0ebridgeRecibido\x12%\n" +
+	"\x0ebridge_enviado\x18\x02 \x01(\x03R\rbridgeEnviado\x12'\n" +
+	"\x0fwriter_recibido\x18\x03 \x01(\x03R\x0ewriterRecibido\x12\x1b\n" +
+	"\tkafka_ack\x18\x04 \x01(\x03R\bkafkaAck\"x\n" +
 	"\x13ProductSaleResponse\x12\x16\n" +
 	"\x06estado\x18\x01 \x01(\tR\x06estado\x12\x14\n" +
-	"\x05exito\x18\x02 \x01(\bR\x05exito*S\n" +
-	"\x11CategoriaProducto\x12\v\n" +
-	"\aUNKNOWN\x10\x00\x12\x0f\n" +
-	"\vElectronica\x10\x01\x12\b\n" +
-	"\x04Ropa\x10\x02\x12\t\n" +
-	"\x05Hogar\x10\x03\x12\v\n" +
-	"\aBelleza\x10\x042h\n" +
+	"\x05exito\x18\x02 \x01(\bR\x05exito\x123\n" +
+	"\x06sellos\x18\x03 \x01(\v2\x1b.blackfriday.SellosLatenciaR\x06sellos2\xdd\x02\n" +
 	"\x12ProductSaleService\x12R\n" +
-	"\rProcesarVenta\x12\x1f.blackfriday.ProductSaleRequest\x1a .blackfriday.ProductSaleResponseB\x06Z\x04./pbb\x06proto3"
+	"\rProcesarVenta\x12\x1f.blackfriday.ProductSaleRequest\x1a .blackfriday.ProductSaleResponse\x12U\n" +
+	"\x12PublicarInventario\x12\x1d.blackfriday.EventoInventario\x1a .blackfriday.ProductSaleResponse\x12O\n" +
+	"\x11ProcesarReembolso\x12\x18.blackfriday.AjusteVenta\x1a .blackfriday.ProductSaleResponse\x12K\n" +
+	"\rCancelarVenta\x12\x18.blackfriday.AjusteVenta\x1a .blackfriday.ProductSaleResponseB\x06Z\x04./pbb\x06proto3"
 
 var (
 	file_producto_venta_proto_rawDescOnce sync.Once
@@ -231,22 +849,40 @@ func file_producto_venta_proto_rawDescGZIP() []byte {
 	return file_producto_venta_proto_rawDescData
 }
 
-var file_producto_venta_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_producto_venta_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_producto_venta_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_producto_venta_proto_goTypes = []any{
-	(CategoriaProducto)(0),      // 0: blackfriday.CategoriaProducto
-	(*ProductSaleRequest)(nil),  // 1: blackfriday.ProductSaleRequest
-	(*ProductSaleResponse)(nil), // 2: blackfriday.ProductSaleResponse
+	(*ProductSaleRequest)(nil),  // 0: blackfriday.ProductSaleRequest
+	(*CampanaVenta)(nil),        // 1: blackfriday.CampanaVenta
+	(*InventarioVenta)(nil),     // 2: blackfriday.InventarioVenta
+	(*AjusteVenta)(nil),         // 3: blackfriday.AjusteVenta
+	(*EventoInventario)(nil),    // 4: blackfriday.EventoInventario
+	(*ProductoCatalogo)(nil),    // 5: blackfriday.ProductoCatalogo
+	(*Envelope)(nil),            // 6: blackfriday.Envelope
+	(*SellosLatencia)(nil),      // 7: blackfriday.SellosLatencia
+	(*ProductSaleResponse)(nil), // 8: blackfriday.ProductSaleResponse
 }
 var file_producto_venta_proto_depIdxs = []int32{
-	0, // 0: blackfriday.ProductSaleRequest.categoria:type_name -> blackfriday.CategoriaProducto
-	1, // 1: blackfriday.ProductSaleService.ProcesarVenta:input_type -> blackfriday.ProductSaleRequest
-	2, // 2: blackfriday.ProductSaleService.ProcesarVenta:output_type -> blackfriday.ProductSaleResponse
-	2, // [2:3] is the sub-list for method output_type
-	1, // [1:2] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	7,  // 0: blackfriday.ProductSaleRequest.sellos:type_name -> blackfriday.SellosLatencia
+	6,  // 1: blackfriday.ProductSaleRequest.envelope:type_name -> blackfriday.Envelope
+	5,  // 2: blackfriday.ProductSaleRequest.catalogo:type_name -> blackfriday.ProductoCatalogo
+	2,  // 3: blackfriday.ProductSaleRequest.inventario:type_name -> blackfriday.InventarioVenta
+	1,  // 4: blackfriday.ProductSaleRequest.campana:type_name -> blackfriday.CampanaVenta
+	6,  // 5: blackfriday.AjusteVenta.envelope:type_name -> blackfriday.Envelope
+	6,  // 6: blackfriday.EventoInventario.envelope:type_name -> blackfriday.Envelope
+	7,  // 7: blackfriday.ProductSaleResponse.sellos:type_name -> blackfriday.SellosLatencia
+	0,  // 8: blackfriday.ProductSaleService.ProcesarVenta:input_type -> blackfriday.ProductSaleRequest
+	4,  // 9: blackfriday.ProductSaleService.PublicarInventario:input_type -> blackfriday.EventoInventario
+	3,  // 10: blackfriday.ProductSaleService.ProcesarReembolso:input_type -> blackfriday.AjusteVenta
+	3,  // 11: blackfriday.ProductSaleService.CancelarVenta:input_type -> blackfriday.AjusteVenta
+	8,  // 12: blackfriday.ProductSaleService.ProcesarVenta:output_type -> blackfriday.ProductSaleResponse
+	8,  // 13: blackfriday.ProductSaleService.PublicarInventario:output_type -> blackfriday.ProductSaleResponse
+	8,  // 14: blackfriday.ProductSaleService.ProcesarReembolso:output_type -> blackfriday.ProductSaleResponse
+	8,  // 15: blackfriday.ProductSaleService.CancelarVenta:output_type -> blackfriday.ProductSaleResponse
+	12, // [12:16] is the sub-list for method output_type
+	8,  // [8:12] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
 }
 
 func init() { file_producto_venta_proto_init() }
@@ -259,14 +895,13 @@ func file_producto_venta_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_producto_venta_proto_rawDesc), len(file_producto_venta_proto_rawDesc)),
-			NumEnums:      1,
-			NumMessages:   2,
+			NumEnums:      0,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_producto_venta_proto_goTypes,
 		DependencyIndexes: file_producto_venta_proto_depIdxs,
-		EnumInfos:         file_producto_venta_proto_enumTypes,
 		MessageInfos:      file_producto_venta_proto_msgTypes,
 	}.Build()
 	File_producto_venta_proto = out.File
@@ -19,7 +19,10 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	ProductSaleService_ProcesarVenta_FullMethodName = "/blackfriday.ProductSaleService/ProcesarVenta"
+	ProductSaleService_ProcesarVenta_FullMethodName      = "/blackfriday.ProductSaleService/ProcesarVenta"
+	ProductSaleService_PublicarInventario_FullMethodName = "/blackfriday.ProductSaleService/PublicarInventario"
+	ProductSaleService_ProcesarReembolso_FullMethodName  = "/blackfriday.ProductSaleService/ProcesarReembolso"
+	ProductSaleService_CancelarVenta_FullMethodName      = "/blackfriday.ProductSaleService/CancelarVenta"
 )
 
 // ProductSaleServiceClient is the client API for ProductSaleService service.
@@ -27,6 +30,9 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ProductSaleServiceClient interface {
 	ProcesarVenta(ctx context.Context, in *ProductSaleRequest, opts ...grpc.CallOption) (*ProductSaleResponse, error)
+	PublicarInventario(ctx context.Context, in *EventoInventario, opts ...grpc.CallOption) (*ProductSaleResponse, error)
+	ProcesarReembolso(ctx context.Context, in *AjusteVenta, opts ...grpc.CallOption) (*ProductSaleResponse, error)
+	CancelarVenta(ctx context.Context, in *AjusteVenta, opts ...grpc.CallOption) (*ProductSaleResponse, error)
 }
 
 type productSaleServiceClient struct {
@@ -47,11 +53,44 @@ func (c *productSaleServiceClient) ProcesarVenta(ctx context.Context, in *Produc
 	return out, nil
 }
 
+func (c *productSaleServiceClient) PublicarInventario(ctx context.Context, in *EventoInventario, opts ...grpc.CallOption) (*ProductSaleResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ProductSaleResponse)
+	err := c.cc.Invoke(ctx, ProductSaleService_PublicarInventario_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *productSaleServiceClient) ProcesarReembolso(ctx context.Context, in *AjusteVenta, opts ...grpc.CallOption) (*ProductSaleResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ProductSaleResponse)
+	err := c.cc.Invoke(ctx, ProductSaleService_ProcesarReembolso_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *productSaleServiceClient) CancelarVenta(ctx context.Context, in *AjusteVenta, opts ...grpc.CallOption) (*ProductSaleResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ProductSaleResponse)
+	err := c.cc.Invoke(ctx, ProductSaleService_CancelarVenta_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ProductSaleServiceServer is the server API for ProductSaleService service.
 // All implementations must embed UnimplementedProductSaleServiceServer
 // for forward compatibility.
 type ProductSaleServiceServer interface {
 	ProcesarVenta(context.Context, *ProductSaleRequest) (*ProductSaleResponse, error)
+	PublicarInventario(context.Context, *EventoInventario) (*ProductSaleResponse, error)
+	ProcesarReembolso(context.Context, *AjusteVenta) (*ProductSaleResponse, error)
+	CancelarVenta(context.Context, *AjusteVenta) (*ProductSaleResponse, error)
 	mustEmbedUnimplementedProductSaleServiceServer()
 }
 
@@ -65,6 +104,15 @@ type UnimplementedProductSaleServiceServer struct{}
 func (UnimplementedProductSaleServiceServer) ProcesarVenta(context.Context, *ProductSaleRequest) (*ProductSaleResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method ProcesarVenta not implemented")
 }
+func (UnimplementedProductSaleServiceServer) PublicarInventario(context.Context, *EventoInventario) (*ProductSaleResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method PublicarInventario not implemented")
+}
+func (UnimplementedProductSaleServiceServer) ProcesarReembolso(context.Context, *AjusteVenta) (*ProductSaleResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ProcesarReembolso not implemented")
+}
+func (UnimplementedProductSaleServiceServer) CancelarVenta(context.Context, *AjusteVenta) (*ProductSaleResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method CancelarVenta not implemented")
+}
 func (UnimplementedProductSaleServiceServer) mustEmbedUnimplementedProductSaleServiceServer() {}
 func (UnimplementedProductSaleServiceServer) testEmbeddedByValue()                            {}
 
@@ -104,6 +152,60 @@ func _ProductSaleService_ProcesarVenta_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ProductSaleService_PublicarInventario_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EventoInventario)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProductSaleServiceServer).PublicarInventario(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ProductSaleService_PublicarInventario_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProductSaleServiceServer).PublicarInventario(ctx, req.(*EventoInventario))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ProductSaleService_ProcesarReembolso_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AjusteVenta)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProductSaleServiceServer).ProcesarReembolso(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ProductSaleService_ProcesarReembolso_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProductSaleServiceServer).ProcesarReembolso(ctx, req.(*AjusteVenta))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ProductSaleService_CancelarVenta_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AjusteVenta)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProductSaleServiceServer).CancelarVenta(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ProductSaleService_CancelarVenta_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProductSaleServiceServer).CancelarVenta(ctx, req.(*AjusteVenta))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ProductSaleService_ServiceDesc is the grpc.ServiceDesc for ProductSaleService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,6 +217,18 @@ var ProductSaleService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ProcesarVenta",
 			Handler:    _ProductSaleService_ProcesarVenta_Handler,
 		},
+		{
+			MethodName: "PublicarInventario",
+			Handler:    _ProductSaleService_PublicarInventario_Handler,
+		},
+		{
+			MethodName: "ProcesarReembolso",
+			Handler:    _ProductSaleService_ProcesarReembolso_Handler,
+		},
+		{
+			MethodName: "CancelarVenta",
+			Handler:    _ProductSaleService_CancelarVenta_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "producto_venta.proto",